@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/TheOrnyx/psx-go/spu"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	AUDIO_BUFFER_SAMPLES = 1024                                     // samples SDL asks for at a time
+	AUDIO_CHANNELS       = 2                                        // stereo
+	AUDIO_MAX_QUEUED     = spu.SAMPLE_RATE / 5 * AUDIO_CHANNELS * 2 // bytes queued before we start dropping, about 200ms
+)
+
+// Plays the SPU's samples through SDL
+type audioOutput struct {
+	device sdl.AudioDeviceID // the SDL audio device
+	buffer []byte            // samples turned into bytes for SDL
+}
+
+// openAudio open the default audio device for 44.1kHz 16 bit stereo
+func openAudio() (*audioOutput, error) {
+	if err := sdl.InitSubSystem(sdl.INIT_AUDIO); err != nil {
+		return nil, fmt.Errorf("Failed to initialize SDL audio: %v", err)
+	}
+
+	spec := sdl.AudioSpec{
+		Freq:     spu.SAMPLE_RATE,
+		Format:   sdl.AUDIO_S16LSB,
+		Channels: AUDIO_CHANNELS,
+		Samples:  AUDIO_BUFFER_SAMPLES,
+	}
+
+	device, err := sdl.OpenAudioDevice("", false, &spec, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open audio device: %v", err)
+	}
+
+	sdl.PauseAudioDevice(device, false)
+	return &audioOutput{device: device}, nil
+}
+
+// queue queue samples to be played. When the emulator runs faster than
+// real time they get dropped instead of piling up
+//
+// TODO - sync the emulator to the audio instead
+func (a *audioOutput) queue(samples []int16) {
+	if len(samples) == 0 || sdl.GetQueuedAudioSize(a.device) > AUDIO_MAX_QUEUED {
+		return
+	}
+
+	a.buffer = a.buffer[:0]
+	for _, sample := range samples {
+		a.buffer = binary.LittleEndian.AppendUint16(a.buffer, uint16(sample))
+	}
+
+	sdl.QueueAudio(a.device, a.buffer)
+}
+
+// close close the audio device
+func (a *audioOutput) close() {
+	sdl.CloseAudioDevice(a.device)
+}
@@ -0,0 +1,199 @@
+package cdrom
+
+import (
+	"encoding/binary"
+
+	"github.com/TheOrnyx/psx-go/log"
+)
+
+// CD-DA playback. Playing reads audio sectors at the same pace as data
+// and sends their 588 stereo samples through the volume matrix to the
+// SPU's CD input. With report mode on there's an INT1 with the position
+// every 10 sectors, and with auto pause the drive pauses with an INT4
+// at the end of the track
+
+const (
+	SAMPLES_PER_SECTOR = SECTOR_SIZE / 4 // 16 bit stereo samples in an audio sector
+	SCAN_SECTORS       = 8               // sectors Forward and Backward skip each sector time
+	FULL_VOLUME        = 0x80            // volume matrix value for 100%
+
+	AUDIO_APPLY_MUTE_ADPCM uint8 = 1 << 0 // audio volume apply bit, mute XA-ADPCM
+	AUDIO_APPLY_CHANGES    uint8 = 1 << 5 // audio volume apply bit, use the new volumes
+)
+
+// Where CD audio goes, the SPU's CD input
+type AudioSink interface {
+	PushCDAudio(left, right int16)
+}
+
+// How much of each CD channel goes to each SPU input, 0x80 is 100%
+type volumeMatrix struct {
+	leftToLeft   uint8
+	leftToRight  uint8
+	rightToRight uint8
+	rightToLeft  uint8
+}
+
+// defaultVolume left to left and right to right at 100%
+var defaultVolume = volumeMatrix{leftToLeft: FULL_VOLUME, rightToRight: FULL_VOLUME}
+
+// ConnectAudio send CD audio to sink
+func (c *CDROM) ConnectAudio(sink AudioSink) {
+	c.audio = sink
+}
+
+// startPlaying start playing from the current position
+func (c *CDROM) startPlaying(seeked bool) {
+	c.state = DrivePlaying
+	c.scan = 0
+	c.lastReport = 0xff
+	c.playTrack = 0
+	if track, ok := TrackAt(c.disc, c.position); ok {
+		c.playTrack = track.Number
+	}
+
+	c.readCycles = c.sectorCycles()
+	if seeked {
+		c.readCycles += SEEK_CYCLES
+	}
+}
+
+// tickPlay count down to the next sector and play it
+func (c *CDROM) tickPlay(cpuCycles uint32) {
+	if c.state != DrivePlaying {
+		return
+	}
+
+	c.readCycles -= int32(cpuCycles)
+	if c.readCycles > 0 {
+		return
+	}
+	c.readCycles += c.sectorCycles()
+
+	track, ok := TrackAt(c.disc, c.position)
+	if !ok || c.mode&MODE_AUTO_PAUSE != 0 && track.Number != c.playTrack {
+		// end of the disc or the track
+		c.state = DriveIdle
+		c.respond(0, INT4, c.stat())
+		return
+	}
+
+	if err := c.disc.ReadSector(c.position, c.sector[:]); err != nil {
+		log.Warnf("CDROM play stopped: %v", err)
+		c.state = DriveIdle
+		c.respond(0, INT4, c.stat())
+		return
+	}
+	c.updateSubQ(c.position)
+
+	// data sectors don't make any sound
+	if track.Type == TrackAudio {
+		c.playSector()
+	}
+
+	if c.mode&MODE_REPORT != 0 {
+		c.report(track)
+	}
+
+	switch {
+	case c.scan > 0:
+		c.position += SCAN_SECTORS
+	case c.scan < 0 && c.position > SCAN_SECTORS:
+		c.position -= SCAN_SECTORS
+	case c.scan < 0:
+		c.position = 0
+	default:
+		c.position++
+	}
+}
+
+// playSector send the samples of the sector in the buffer to the SPU
+func (c *CDROM) playSector() {
+	for i := range SAMPLES_PER_SECTOR {
+		left := int16(binary.LittleEndian.Uint16(c.sector[i*4:]))
+		right := int16(binary.LittleEndian.Uint16(c.sector[i*4+2:]))
+		c.outputAudio(left, right)
+	}
+}
+
+// outputAudio send a stereo sample through the volume matrix to the SPU
+func (c *CDROM) outputAudio(left, right int16) {
+	if c.audio == nil {
+		return
+	}
+
+	if c.muted {
+		c.audio.PushCDAudio(0, 0)
+		return
+	}
+
+	v := c.volume
+	outLeft := (int32(left)*int32(v.leftToLeft) + int32(right)*int32(v.rightToLeft)) >> 7
+	outRight := (int32(right)*int32(v.rightToRight) + int32(left)*int32(v.leftToRight)) >> 7
+
+	c.audio.PushCDAudio(clampSample(outLeft), clampSample(outRight))
+}
+
+// report queue an INT1 position report when the tens digit of the
+// absolute frame changes. Odd tens report the position in the track
+// with bit 7 of the seconds set, even ones the position on the disc
+func (c *CDROM) report(track *Track) {
+	am, as, af := MSFFromLBA(c.position).BCD()
+	if af>>4 == c.lastReport {
+		return
+	}
+	c.lastReport = af >> 4
+
+	// the peak level of the sector, alternating channels, bit 15 says which
+	channel := int(c.lastReport & 1)
+	var peak uint16
+	for i := range SAMPLES_PER_SECTOR {
+		sample := int16(binary.LittleEndian.Uint16(c.sector[i*4+channel*2:]))
+		peak = max(peak, uint16(min(max(int32(sample), -int32(sample)), 0x7fff)))
+	}
+	peak |= uint16(channel) << 15
+
+	index, relative := track.position(c.position)
+	m, s, f := am, as, af
+	if af&0x10 != 0 {
+		m, s, f = relative.BCD()
+		s |= 0x80
+	}
+
+	c.respond(0, INT1, c.stat(), toBCD(track.Number), index, m, s, f, uint8(peak), uint8(peak>>8))
+}
+
+// clampSample clamp a mixed sample to 16 bits
+func clampSample(sample int32) int16 {
+	return int16(max(-0x8000, min(0x7fff, sample)))
+}
+
+// writeLeftToLeftVol Audio Volume for Left-CD-Out to Left-SPU-Input
+func (c *CDROM) writeLeftToLeftVol(val uint8) {
+	c.pendingVolume.leftToLeft = val
+}
+
+// writeLeftToRightVol Audio Volume for Left-CD-Out to Right-SPU-Input
+func (c *CDROM) writeLeftToRightVol(val uint8) {
+	c.pendingVolume.leftToRight = val
+}
+
+// writeRightToRightVol Audio Volume for Right-CD-Out to Right-SPU-Input
+func (c *CDROM) writeRightToRightVol(val uint8) {
+	c.pendingVolume.rightToRight = val
+}
+
+// writeRightToLeftVol Audio Volume for Right-CD-Out to Left-SPU-Input
+func (c *CDROM) writeRightToLeftVol(val uint8) {
+	c.pendingVolume.rightToLeft = val
+}
+
+// writeAudioVolApply Apply changes to volume (By writing bit5=1), bit 0
+// mutes XA-ADPCM
+func (c *CDROM) writeAudioVolApply(val uint8) {
+	c.adpcmMuted = val&AUDIO_APPLY_MUTE_ADPCM != 0
+
+	if val&AUDIO_APPLY_CHANGES != 0 {
+		c.volume = c.pendingVolume
+	}
+}
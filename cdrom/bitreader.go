@@ -0,0 +1,85 @@
+package cdrom
+
+// Reads bits out of a byte slice, most significant bit first. Used by
+// the CHD map and FLAC decoders. Reading past the end gives zeroes and
+// sets overrun so the caller can tell the data was cut short
+
+type bitReader struct {
+	data    []byte // the bytes being read
+	pos     int    // position in bits
+	overrun bool   // read past the end of data
+}
+
+// read read n bits, up to 32
+func (b *bitReader) read(n uint) uint32 {
+	var val uint64
+
+	for n > 0 {
+		var cur uint8
+		if idx := b.pos >> 3; idx < len(b.data) {
+			cur = b.data[idx]
+		} else {
+			b.overrun = true
+		}
+
+		avail := 8 - uint(b.pos&7)
+		take := min(avail, n)
+		bits := (cur >> (avail - take)) & uint8(1<<take-1)
+
+		val = val<<take | uint64(bits)
+		n -= take
+		b.pos += int(take)
+	}
+
+	return uint32(val)
+}
+
+// readSigned read n bits as a two's complement number
+func (b *bitReader) readSigned(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+
+	shift := 32 - n
+	return int32(b.read(n)<<shift) >> shift
+}
+
+// readUnary count the zero bits before the next one bit
+func (b *bitReader) readUnary() uint32 {
+	var count uint32
+	for b.read(1) == 0 {
+		if b.overrun {
+			return count
+		}
+		count++
+	}
+
+	return count
+}
+
+// peek look at the next n bits without reading them
+func (b *bitReader) peek(n uint) uint32 {
+	pos, overrun := b.pos, b.overrun
+	val := b.read(n)
+	b.pos, b.overrun = pos, overrun
+
+	return val
+}
+
+// skip move past n bits
+func (b *bitReader) skip(n uint) {
+	b.pos += int(n)
+	if b.pos > len(b.data)*8 {
+		b.overrun = true
+	}
+}
+
+// align move to the start of the next byte
+func (b *bitReader) align() {
+	b.pos = (b.pos + 7) &^ 7
+}
+
+// offset bytes used so far, counting a partly read byte
+func (b *bitReader) offset() int {
+	return (b.pos + 7) >> 3
+}
@@ -4,24 +4,99 @@
 package cdrom
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/TheOrnyx/psx-go/log"
+	"github.com/TheOrnyx/psx-go/utils"
 )
 
 type CDROM struct {
-	Data         []byte // The executable data (TODO - make this into an actual type interface alter)
+	disc         Disc   // The disc in the drive, nil if there isn't one
 	status       Status // Index/Status register (0x1f801800)
 	intFlagReg   uint8  // Interrupt flag register
 	intEnableReg uint8  // Interrupt enable register
+	requestReg   uint8  // Request register
+
+	params    ByteFIFO          // Parameter FIFO
+	response  ByteFIFO          // Response FIFO
+	responses []pendingResponse // Responses waiting to be delivered, in order
+	busy      bool              // a command is waiting for its first response
+	irqLine   bool              // last state of the interrupt line
+
+	state         DriveState // what the drive is doing
+	mode          uint8      // Setmode byte, see the MODE_ bits
+	position      uint32     // LBA of the sector under the head
+	seekTarget    MSF        // position from the last Setloc
+	seekPending   bool       // Setloc hasn't been seeked to yet
+	filterFile    uint8      // Setfilter file number
+	filterChannel uint8      // Setfilter channel number
+	muted         bool       // CD audio muted
+	lastHeader    [8]uint8   // header and subheader of the last data sector read
+	haveHeader    bool       // lastHeader holds something
+	region        uint8      // last letter of the SCEx region string
+
+	sector        [SECTOR_SIZE]uint8       // the last sector read off the disc
+	sectorPending bool                     // sector hasn't had its INT1 yet
+	readCycles    int32                    // CPU cycles until the next sector is read
+	dataBuffer    [WHOLE_SECTOR_SIZE]uint8 // the last delivered sector, what BFRD loads
+	dataSize      int                      // bytes of dataBuffer in use
+	data          []uint8                  // the data FIFO, what's left of dataBuffer to read
+
+	audio         AudioSink    // where CD audio goes, nil drops it
+	volume        volumeMatrix // CD audio volumes in use
+	pendingVolume volumeMatrix // CD audio volumes written but not applied yet
+	adpcmMuted    bool         // XA-ADPCM muted by the audio volume apply register
+	playTrack     uint8        // track Play started in, for auto pause
+	scan          int8         // 1 for Forward, -1 for Backward, 0 playing normally
+	lastReport    uint8        // tens digit of the frame of the last report
+	xa            xaDecoder    // XA-ADPCM decoder state
+
+	lidOpen     bool     // the lid is open
+	shellOpened bool     // the lid was opened since the last GetStat, the shell open stat bit
+	lidCycles   int32    // CPU cycles until the lid closes after a swap, 0 if it isn't going to
+	playlist    []string // disc images that can be swapped between
+	discIndex   int      // playlist entry in the drive, or going in when the lid closes
+
+	subqPatches map[uint32]SubQ // subchannel Q from a .sbi or .lsd by LBA, for LibCrypt
+	lastSubQ    SubQ            // last subchannel Q with a good CRC, what GetlocP returns
+	haveSubQ    bool            // lastSubQ holds something
 }
 
-type Status uint8 // The Index/Status Register - TODO - maybe convert to struct
+type Status uint8 // The Index/Status Register - only holds the index, the rest comes from the state
 
-// Status read and return the status
+// Status read and return the status register
 func (c *CDROM) Status() uint8 {
-	return c.status.index()
+	r := c.status.index()
+	// bit 2 - XA-ADPCM FIFO not empty, the audio goes straight to the SPU so it's never set
+	r |= uint8(utils.BoolToUint32(c.params.empty())) << 3
+	r |= uint8(utils.BoolToUint32(!c.params.full())) << 4
+	r |= uint8(utils.BoolToUint32(!c.response.empty())) << 5
+	r |= uint8(utils.BoolToUint32(len(c.data) > 0)) << 6
+	r |= uint8(utils.BoolToUint32(c.busy)) << 7
+
+	return r
+}
+
+// stat build the stat byte most responses start with
+func (c *CDROM) stat() uint8 {
+	var r uint8
+
+	switch c.state {
+	case DriveSeeking:
+		r |= STAT_SEEKING
+	case DriveReading:
+		r |= STAT_READING
+	case DrivePlaying:
+		r |= STAT_PLAYING
+	}
+
+	if c.state != DriveStopped {
+		r |= STAT_MOTOR_ON
+	}
+
+	if c.shellOpened {
+		r |= STAT_SHELL_OPEN
+	}
+
+	return r
 }
 
 // writeStatus write to the status register
@@ -39,28 +114,100 @@ func (s Status) index() uint8 {
 	return uint8(s) & 3
 }
 
-// NewCDROM Create and return a new CDROM
+// NewCDROM Create and return a new CDROM with the disc image at path
+// in it, an empty path leaves the drive empty. A .m3u playlist puts the
+// first disc in
 func NewCDROM(path string) (CDROM, error) {
-	var cd CDROM
-	data, err := os.ReadFile(path)
+	cd := CDROM{region: 'A', volume: defaultVolume, pendingVolume: defaultVolume}
+	if path == "" {
+		return cd, nil
+	}
+
+	playlist, err := LoadPlaylist(path)
 	if err != nil {
-		return cd, fmt.Errorf("Failed to open file: %v", err)
+		return cd, err
+	}
+	cd.playlist = playlist
+
+	if err := cd.insertDisc(playlist[0]); err != nil {
+		return cd, err
 	}
 
-	cd.Data = data
 	return cd, nil
 }
 
-// ReadResponse read from the Response FIFO - TODO complete
-func (c *CDROM) ReadResponse() uint8 {
-	log.Warn("(Not implemented yet) attempted read from REsponse FIFO")
-	return 0x00
+// insertDisc open the disc image at path and its subchannel patches
+// and put it in the drive
+func (c *CDROM) insertDisc(path string) error {
+	disc, err := OpenDisc(path)
+	if err != nil {
+		return err
+	}
+
+	patches, err := loadSubQPatches(disc, path)
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+
+	c.disc = disc
+	c.subqPatches = patches
+	c.haveSubQ = false
+	logDiscInfo(disc)
+
+	return nil
+}
+
+// logDiscInfo log what game the disc is, audio CDs and homebrew without
+// a filesystem just don't get anything
+func logDiscInfo(disc Disc) {
+	fs, err := OpenFilesystem(disc)
+	if err != nil {
+		return
+	}
+
+	boot, err := fs.Boot()
+	if err != nil {
+		log.Warnf("%v", err)
+		return
+	}
+
+	if boot.Serial == "" {
+		log.Infof("Disc boots %s", boot.Exe)
+		return
+	}
+	log.Infof("Disc %s, boots %s", boot.Serial, boot.Exe)
+}
+
+// Quit close the disc image
+func (c *CDROM) Quit() {
+	if c.disc != nil {
+		c.disc.Close()
+	}
 }
 
-// ReadData Read data from the CDROM Data FIFO
-func (c *CDROM) ReadData() uint8 {
-	log.Warn("(Not implemented yet) attempted read from Data FIFO")
-	return 0x00
+// hasDisc whether there's a disc in the drive
+func (c *CDROM) hasDisc() bool {
+	return c.disc != nil
+}
+
+// trackCount number of tracks on the disc
+func (c *CDROM) trackCount() uint8 {
+	return uint8(len(c.disc.Tracks()))
+}
+
+// trackStart position of the start of a track
+func (c *CDROM) trackStart(track uint8) MSF {
+	return MSFFromLBA(c.disc.Tracks()[track-1].Start)
+}
+
+// discEnd position of the end of the disc, where the lead out starts
+func (c *CDROM) discEnd() MSF {
+	return MSFFromLBA(c.disc.LeadOut())
+}
+
+// ReadResponse read from the Response FIFO
+func (c *CDROM) ReadResponse() uint8 {
+	return c.response.pop()
 }
 
 // LoadByte read byte in CDROM register at addr
@@ -128,52 +275,42 @@ func (c *CDROM) StoreByte(offset uint32, val uint8) {
 	}
 }
 
-// WriteCMD write to command register (should run a command)
-func (c *CDROM) WriteCMD(val uint8)  {
-	log.Panicf("(Not implemented yet) attempted write to command reg with val 0x%02x", val)
-}
-
 // writeParam write to the parameter FIFO
 func (c *CDROM) writeParam(val uint8)  {
-	log.Warnf("(Not implemented yet) attempted write to Parameter FIFO with val 0x%02x", val)
+	if c.params.full() {
+		log.Warnf("CDROM parameter FIFO full, dropping 0x%02x", val)
+	}
+
+	c.params.push(val)
 }
 
-// writeRequest write to the request register
+// writeRequest write to the request register. Setting BFRD loads the
+// last sector into the data FIFO, clearing it empties the FIFO
 func (c *CDROM) writeRequest(val uint8)  {
-	log.Warnf("(Not implemented yet) attempted write to Request Register with val 0x%02x", val)
+	c.requestReg = val
+
+	if val&REQUEST_BFRD == 0 {
+		c.data = nil
+	} else if len(c.data) == 0 {
+		c.loadDataFIFO()
+	}
 }
 
 // writeIntEnable write to the interrupt enable register
 func (c *CDROM) writeIntEnable(val uint8)  {
-	c.intEnableReg = val
+	c.intEnableReg = val & 0x1f
 }
 
-// writeIntFlag write to interrupt flag register
+// writeIntFlag write to interrupt flag register, writing 1 to a bit
+// acknowledges it. Bit 6 clears the parameter FIFO
 func (c *CDROM) writeIntFlag(val uint8)  {
-	c.intFlagReg = val
-}
+	c.intFlagReg &^= val & 0x1f
 
-// writeLeftToLeftVol Audio Volume for Left-CD-Out to Left-SPU-Input
-func (c *CDROM) writeLeftToLeftVol(val uint8)  {
-	log.Infof("(Not implemented yet) write Audio Volume for Left-CD-Out to Left-SPU-Input with val %d", val)
-}
-
-// writeLeftToRightVol Audio Volume for Left-CD-Out to Right-SPU-Input
-func (c *CDROM) writeLeftToRightVol(val uint8)  {
-	log.Infof("(Not implemented yet) write Audio Volume for Left-CD-Out to Right-SPU-Input with val %d", val)
-}
-
-// writeRightToRightVol Audio Volume for Right-CD-Out to Right-SPU-Input
-func (c *CDROM) writeRightToRightVol(val uint8)  {
-	log.Infof("(Not implemented yet) write Audio Volume for Right-CD-Out to Right-SPU-Input with val %d", val)
-}
-
-// writeRightToLeftVol Audio Volume for Right-CD-Out to Left-SPU-Input
-func (c *CDROM) writeRightToLeftVol(val uint8)  {
-	log.Infof("(Not implemented yet) write Audio Volume for Right-CD-Out to Left-SPU-Input with val %d", val)
-}
+	// the line drops straight away, a response delivered on the next
+	// tick is a new edge
+	c.irqLine = c.intFlagReg&c.intEnableReg&0x1f != 0
 
-// writeAudioVolApply Apply changes to volume (By writing bit5=1)
-func (c *CDROM) writeAudioVolApply(val uint8)  {
-	log.Infof("(Not implemented yet) write Audio Volume Apply Changes with val %d", val)
+	if val&0x40 != 0 {
+		c.params.clear()
+	}
 }
@@ -0,0 +1,168 @@
+package cdrom
+
+import "fmt"
+
+const (
+	CPU_CLOCK = 33868800 // CPU cycles per second, all the delays are counted in these
+
+	SECTOR_SIZE        = 2352 // size of a raw sector
+	SECTORS_PER_SECOND = 75   // sectors per second at 1x speed
+	PREGAP_SECTORS     = 150  // the 2 second gap before the first track
+)
+
+// Interrupt codes, what ends up in the low 3 bits of the interrupt flag
+const (
+	INT1 uint8 = 1 // data or report ready
+	INT2 uint8 = 2 // second response of a command
+	INT3 uint8 = 3 // first response of a command
+	INT4 uint8 = 4 // end of data
+	INT5 uint8 = 5 // error
+)
+
+// Bits of the stat byte most responses start with
+const (
+	STAT_ERROR      uint8 = 1 << 0 // command failed
+	STAT_MOTOR_ON   uint8 = 1 << 1 // spindle motor is spinning
+	STAT_SEEK_ERROR uint8 = 1 << 2 // seek failed
+	STAT_ID_ERROR   uint8 = 1 << 3 // GetID denied
+	STAT_SHELL_OPEN uint8 = 1 << 4 // lid is or was open
+	STAT_READING    uint8 = 1 << 5 // reading data sectors
+	STAT_SEEKING    uint8 = 1 << 6 // seeking
+	STAT_PLAYING    uint8 = 1 << 7 // playing CD-DA
+)
+
+// Error codes sent after the stat byte in INT5 responses
+const (
+	ERR_INVALID_PARAM   uint8 = 0x10 // bad parameter value or sub function
+	ERR_PARAM_COUNT     uint8 = 0x20 // wrong number of parameters
+	ERR_INVALID_COMMAND uint8 = 0x40 // no such command
+	ERR_NOT_READY       uint8 = 0x80 // can't do that right now, no disc etc
+)
+
+// Bits of the Setmode byte
+const (
+	MODE_CDDA         uint8 = 1 << 0 // allow reading CD-DA sectors
+	MODE_AUTO_PAUSE   uint8 = 1 << 1 // pause at the end of a track
+	MODE_REPORT       uint8 = 1 << 2 // INT1 position reports while playing
+	MODE_XA_FILTER    uint8 = 1 << 3 // only take XA-ADPCM sectors matching Setfilter
+	MODE_IGNORE       uint8 = 1 << 4 // ignore the sector size bit
+	MODE_WHOLE_SECTOR uint8 = 1 << 5 // 0x924 byte sectors instead of 0x800
+	MODE_XA_ADPCM     uint8 = 1 << 6 // send XA-ADPCM sectors to the SPU
+	MODE_DOUBLE_SPEED uint8 = 1 << 7 // 2x speed
+)
+
+// The state of the drive, what it's busy doing
+type DriveState uint8
+
+// Drive state constants
+const (
+	DriveIdle    DriveState = 0 // motor on, nothing happening
+	DriveSeeking DriveState = 1 // moving to the Setloc position
+	DriveReading DriveState = 2 // reading data sectors
+	DrivePlaying DriveState = 3 // playing CD-DA
+	DriveStopped DriveState = 4 // motor off
+)
+
+// String return the name of the drive state
+func (d DriveState) String() string {
+	switch d {
+	case DriveSeeking:
+		return "seeking"
+	case DriveReading:
+		return "reading"
+	case DrivePlaying:
+		return "playing"
+	case DriveStopped:
+		return "stopped"
+	default:
+		return "idle"
+	}
+}
+
+// A position on the disc in minutes, seconds and frames (sectors),
+// counted from the very start including the 2 second pregap
+type MSF struct {
+	M uint8 // minute
+	S uint8 // second, 0-59
+	F uint8 // frame, 0-74
+}
+
+// MSFFromLBA convert a logical block address to a position. LBA 0 is
+// 00:02:00, the first sector after the pregap
+func MSFFromLBA(lba uint32) MSF {
+	return MSFFromSectors(lba + PREGAP_SECTORS)
+}
+
+// MSFFromSectors convert a number of sectors to minutes, seconds and
+// frames without the pregap, for relative positions and lengths
+func MSFFromSectors(sectors uint32) MSF {
+	return MSF{
+		M: uint8(sectors / (60 * SECTORS_PER_SECOND)),
+		S: uint8(sectors / SECTORS_PER_SECOND % 60),
+		F: uint8(sectors % SECTORS_PER_SECOND),
+	}
+}
+
+// MSFFromBCD build a position from the BCD bytes the controller uses
+func MSFFromBCD(m, s, f uint8) (MSF, error) {
+	msf := MSF{M: fromBCD(m), S: fromBCD(s), F: fromBCD(f)}
+	if !validBCD(m) || !validBCD(s) || !validBCD(f) || msf.S >= 60 || msf.F >= SECTORS_PER_SECOND {
+		return msf, fmt.Errorf("Invalid position %02x:%02x:%02x", m, s, f)
+	}
+
+	return msf, nil
+}
+
+// LBA return the logical block address of the position, positions in
+// the pregap wrap around
+func (m MSF) LBA() uint32 {
+	return (uint32(m.M)*60+uint32(m.S))*SECTORS_PER_SECOND + uint32(m.F) - PREGAP_SECTORS
+}
+
+// BCD return the position as BCD bytes
+func (m MSF) BCD() (uint8, uint8, uint8) {
+	return toBCD(m.M), toBCD(m.S), toBCD(m.F)
+}
+
+// String return the position as mm:ss:ff
+func (m MSF) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d", m.M, m.S, m.F)
+}
+
+// toBCD convert a value under 100 to BCD
+func toBCD(val uint8) uint8 {
+	return (val/10)<<4 | val%10
+}
+
+// fromBCD convert a BCD byte to its value
+func fromBCD(val uint8) uint8 {
+	return (val>>4)*10 + val&0x0f
+}
+
+// validBCD whether both digits of val are 0-9
+func validBCD(val uint8) bool {
+	return val&0x0f < 10 && val>>4 < 10
+}
+
+// What kind of sectors a track is made of
+type TrackType uint8
+
+// Track type constants
+const (
+	TrackMode1 TrackType = 0 // mode 1 data, 2048 bytes of user data per sector
+	TrackMode2 TrackType = 1 // mode 2 data, XA form 1 and form 2 sectors
+	TrackAudio TrackType = 2 // CD-DA audio
+)
+
+// String return the name of the track type, as it would be in a CUE
+// sheet
+func (t TrackType) String() string {
+	switch t {
+	case TrackMode2:
+		return "MODE2"
+	case TrackAudio:
+		return "AUDIO"
+	default:
+		return "MODE1"
+	}
+}
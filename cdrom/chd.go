@@ -0,0 +1,466 @@
+package cdrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CHD (MAME's compressed hunks of data) images. The disc is cut into
+// hunks of a few frames that are compressed on their own, so any sector
+// can be read by decompressing just its hunk. A frame is a 2352 byte
+// sector plus 96 bytes of subcode and every track is padded out to a
+// multiple of 4 frames. Only v5 CHDs without a parent are handled. The
+// v5 header, all big endian, is
+//
+//	0x00  "MComprHD"
+//	0x08  header length
+//	0x0c  version
+//	0x10  4 codec tags
+//	0x20  uncompressed size
+//	0x28  map offset
+//	0x30  metadata offset
+//	0x38  bytes per hunk
+//	0x3c  bytes per unit, a frame for CDs
+//	0x40  SHA1s of the raw data, of the data and metadata, and of the parent
+
+const (
+	CHD_HEADER_SIZE   = 124                            // v5 header
+	CHD_SUBCODE_SIZE  = 96                             // subcode in a frame
+	CHD_FRAME_SIZE    = SECTOR_SIZE + CHD_SUBCODE_SIZE // a sector and its subcode
+	CHD_TRACK_PADDING = 4                              // tracks are padded to this many frames
+	CHD_HUNK_CACHE    = 16                             // decompressed hunks kept around
+	CHD_METADATA_SIZE = 16                             // metadata entry header
+	CHD_TRACK_TAG     = 0x43485432                     // CHT2, CD track metadata
+	CHD_OLD_TRACK_TAG = 0x43485452                     // CHTR, CD track metadata without pregaps
+	CHD_CD_TAG        = 0x43484344                     // CHCD, binary CD metadata from old versions
+)
+
+var chdMagic = []byte("MComprHD")
+
+// Where a track's frames are in the CHD
+type chdTrackSource struct {
+	frame      int64  // frame the track's index 01 is at
+	filePregap uint32 // frames of the pregap that are in the CHD
+	dataSize   int    // bytes of sector data at the start of each frame
+}
+
+// A decompressed hunk
+type chdCachedHunk struct {
+	hunk     uint32 // which hunk
+	data     []byte // hunkBytes of it
+	lastUsed uint64 // cache tick it was last read at
+}
+
+// A CHD disc image
+type ChdDisc struct {
+	file      *os.File         // the CHD
+	hunkBytes uint32           // bytes per hunk
+	unitBytes uint32           // bytes per unit
+	codecs    [4]chdCodec      // codecs for compression types 0-3
+	hunkMap   []chdMapEntry    // every hunk
+	tracks    []Track          // the tracks
+	sources   []chdTrackSource // where the frames of each track are
+	leadOut   uint32           // LBA of the lead out
+	cache     []chdCachedHunk  // recently decompressed hunks
+	cacheTick uint64           // counts reads for the cache
+}
+
+// OpenChd open a CHD image
+func OpenChd(path string) (*ChdDisc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open CHD: %v", err)
+	}
+
+	disc := &ChdDisc{file: file}
+	if err := disc.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return disc, nil
+}
+
+// readHeader read the header, map and track metadata
+func (d *ChdDisc) readHeader() error {
+	var header [CHD_HEADER_SIZE]byte
+	if _, err := d.file.ReadAt(header[:], 0); err != nil {
+		return fmt.Errorf("Failed to read CHD header: %v", err)
+	}
+
+	if !bytes.Equal(header[:8], chdMagic) {
+		return fmt.Errorf("Not a CHD")
+	}
+
+	if version := binary.BigEndian.Uint32(header[0x0c:]); version != 5 {
+		return fmt.Errorf("Unsupported CHD version %d, only v5 is supported", version)
+	}
+
+	if binary.BigEndian.Uint32(header[0x08:]) < CHD_HEADER_SIZE {
+		return fmt.Errorf("CHD header is too short")
+	}
+
+	if !bytes.Equal(header[0x68:0x7c], make([]byte, 20)) {
+		return fmt.Errorf("CHDs with a parent aren't supported")
+	}
+
+	logicalBytes := binary.BigEndian.Uint64(header[0x20:])
+	mapOffset := binary.BigEndian.Uint64(header[0x28:])
+	metaOffset := binary.BigEndian.Uint64(header[0x30:])
+	d.hunkBytes = binary.BigEndian.Uint32(header[0x38:])
+	d.unitBytes = binary.BigEndian.Uint32(header[0x3c:])
+
+	if d.unitBytes != CHD_FRAME_SIZE || d.hunkBytes == 0 || d.hunkBytes%CHD_FRAME_SIZE != 0 {
+		return fmt.Errorf("Not a CD CHD, %d byte units in %d byte hunks", d.unitBytes, d.hunkBytes)
+	}
+
+	for i := range d.codecs {
+		tag := binary.BigEndian.Uint32(header[0x10+i*4:])
+		if tag == CHD_CODEC_NONE {
+			continue
+		}
+
+		codec, err := newChdCodec(tag)
+		if err != nil {
+			return err
+		}
+		d.codecs[i] = codec
+	}
+
+	hunkCount := uint32((logicalBytes + uint64(d.hunkBytes) - 1) / uint64(d.hunkBytes))
+	compressed := binary.BigEndian.Uint32(header[0x10:]) != CHD_CODEC_NONE
+	if err := d.readMap(mapOffset, hunkCount, compressed); err != nil {
+		return err
+	}
+
+	return d.readTracks(metaOffset, logicalBytes/CHD_FRAME_SIZE)
+}
+
+// readTracks read the track metadata and lay the tracks out on the disc
+func (d *ChdDisc) readTracks(metaOffset uint64, frameCount uint64) error {
+	var entries []map[string]string
+
+	for offset := metaOffset; offset != 0; {
+		var header [CHD_METADATA_SIZE]byte
+		if _, err := d.file.ReadAt(header[:], int64(offset)); err != nil {
+			return fmt.Errorf("Failed to read CHD metadata: %v", err)
+		}
+
+		tag := binary.BigEndian.Uint32(header[0:])
+		length := binary.BigEndian.Uint32(header[4:]) & 0xffffff
+		next := binary.BigEndian.Uint64(header[8:])
+
+		switch tag {
+		case CHD_TRACK_TAG, CHD_OLD_TRACK_TAG:
+			data := make([]byte, length)
+			if _, err := d.file.ReadAt(data, int64(offset)+CHD_METADATA_SIZE); err != nil {
+				return fmt.Errorf("Failed to read CHD metadata: %v", err)
+			}
+
+			// TRACK:1 TYPE:MODE2_RAW SUBTYPE:NONE FRAMES:1234 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0
+			fields := map[string]string{}
+			for _, field := range strings.Fields(string(bytes.TrimRight(data, "\x00"))) {
+				if key, val, ok := strings.Cut(field, ":"); ok {
+					fields[key] = val
+				}
+			}
+			entries = append(entries, fields)
+
+		case CHD_CD_TAG:
+			return fmt.Errorf("Old binary CD metadata isn't supported")
+		}
+
+		offset = next
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("No CD track metadata, not a CD CHD")
+	}
+
+	// the entries aren't always in order
+	ordered := make([]map[string]string, len(entries))
+	for _, fields := range entries {
+		number, err := strconv.Atoi(fields["TRACK"])
+		if err != nil || number < 1 || number > len(entries) || ordered[number-1] != nil {
+			return fmt.Errorf("Bad track number %q in CHD metadata", fields["TRACK"])
+		}
+		ordered[number-1] = fields
+	}
+
+	// track 1's index 01 is LBA 0 (00:02:00), the pregaps of the other
+	// tracks push them along whether they're in the CHD or not
+	lba := int64(0)
+	frame := int64(0)
+
+	for i, fields := range ordered {
+		ttype, dataSize, err := parseChdTrackType(fields["TYPE"])
+		if err != nil {
+			return fmt.Errorf("Track %d: %v", i+1, err)
+		}
+
+		frames, err := strconv.ParseInt(fields["FRAMES"], 10, 64)
+		if err != nil || frames <= 0 {
+			return fmt.Errorf("Track %d: bad frame count %q", i+1, fields["FRAMES"])
+		}
+
+		pregap := int64(0)
+		if val, ok := fields["PREGAP"]; ok {
+			if pregap, err = strconv.ParseInt(val, 10, 64); err != nil || pregap < 0 {
+				return fmt.Errorf("Track %d: bad pregap %q", i+1, val)
+			}
+		}
+
+		// a V pregap type means the pregap's frames are in the CHD
+		// before the track's, otherwise it's silence that isn't stored
+		filePregap := int64(0)
+		if strings.HasPrefix(fields["PGTYPE"], "V") {
+			if pregap >= frames {
+				return fmt.Errorf("Track %d: pregap is longer than the track", i+1)
+			}
+			filePregap = pregap
+		}
+
+		frame += filePregap
+		frames -= filePregap
+
+		trackPregap := pregap
+		if i == 0 {
+			trackPregap = filePregap
+		} else {
+			lba += pregap
+		}
+
+		d.tracks = append(d.tracks, Track{
+			Number: uint8(i + 1),
+			Type:   ttype,
+			Start:  uint32(lba),
+			Pregap: uint32(trackPregap),
+			Length: uint32(frames),
+		})
+
+		d.sources = append(d.sources, chdTrackSource{
+			frame:      frame,
+			filePregap: uint32(filePregap),
+			dataSize:   dataSize,
+		})
+
+		lba += frames
+		frame += frames
+		frame = (frame + CHD_TRACK_PADDING - 1) / CHD_TRACK_PADDING * CHD_TRACK_PADDING
+	}
+
+	if uint64(d.sources[len(d.sources)-1].frame)+uint64(d.tracks[len(d.tracks)-1].Length) > frameCount {
+		return fmt.Errorf("CHD track metadata goes past the end of the image")
+	}
+
+	d.leadOut = d.tracks[len(d.tracks)-1].End()
+	return nil
+}
+
+// Tracks return the tracks in order
+func (d *ChdDisc) Tracks() []Track {
+	return d.tracks
+}
+
+// LeadOut return the LBA the lead out starts at
+func (d *ChdDisc) LeadOut() uint32 {
+	return d.leadOut
+}
+
+// ReadSector read the raw sector at lba into buf, decompressing its hunk
+// if it isn't cached. Pregaps that aren't in the CHD read as zeroes
+func (d *ChdDisc) ReadSector(lba uint32, buf []byte) error {
+	for i := range d.tracks {
+		t := &d.tracks[i]
+		if lba+t.Pregap < t.Start || lba >= t.End() {
+			continue
+		}
+
+		src := &d.sources[i]
+		offset := int64(lba) - int64(t.Start)
+		if offset < -int64(src.filePregap) {
+			clear(buf[:SECTOR_SIZE])
+			return nil
+		}
+
+		pos := uint64(src.frame+offset) * CHD_FRAME_SIZE
+		hunk, err := d.readHunk(uint32(pos / uint64(d.hunkBytes)))
+		if err != nil {
+			return fmt.Errorf("Failed to read sector %d: %v", lba, err)
+		}
+		data := hunk[pos%uint64(d.hunkBytes):][:src.dataSize]
+
+		switch src.dataSize {
+		case SECTOR_SIZE:
+			copy(buf, data)
+
+			// CD audio in a CHD is big endian
+			if t.Type == TrackAudio {
+				for j := 0; j < SECTOR_SIZE; j += 2 {
+					buf[j], buf[j+1] = buf[j+1], buf[j]
+				}
+			}
+
+		case DATA_SIZE:
+			dataOffset := MODE2_DATA
+			if t.Type == TrackMode1 {
+				dataOffset = MODE1_DATA
+			}
+			copy(buf[dataOffset:], data)
+			buildSector(buf, lba, t.Type)
+
+		case SECTOR_SIZE - SUBHEADER_OFFSET:
+			// mode 2 without the sync and header
+			copy(buf[SUBHEADER_OFFSET:], data)
+			putSectorHeader(buf, lba, 2)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Sector %d isn't on the disc", lba)
+}
+
+// Close close the CHD
+func (d *ChdDisc) Close() error {
+	d.cache = nil
+	return d.file.Close()
+}
+
+// readHunk get a decompressed hunk from the cache, decompressing it
+// into the least recently used slot if it isn't there
+func (d *ChdDisc) readHunk(hunk uint32) ([]byte, error) {
+	if hunk >= uint32(len(d.hunkMap)) {
+		return nil, fmt.Errorf("Hunk %d is past the end of the CHD", hunk)
+	}
+
+	d.cacheTick++
+
+	oldest := -1
+	for i := range d.cache {
+		entry := &d.cache[i]
+		if entry.hunk == hunk {
+			entry.lastUsed = d.cacheTick
+			return entry.data, nil
+		}
+
+		if oldest < 0 || entry.lastUsed < d.cache[oldest].lastUsed {
+			oldest = i
+		}
+	}
+
+	var entry *chdCachedHunk
+	if len(d.cache) < CHD_HUNK_CACHE {
+		d.cache = append(d.cache, chdCachedHunk{data: make([]byte, d.hunkBytes)})
+		entry = &d.cache[len(d.cache)-1]
+	} else {
+		entry = &d.cache[oldest]
+	}
+
+	// it's not in the cache whatever happens next
+	entry.hunk = ^uint32(0)
+	if err := d.decompressHunk(hunk, entry.data); err != nil {
+		return nil, err
+	}
+
+	entry.hunk = hunk
+	entry.lastUsed = d.cacheTick
+
+	return entry.data, nil
+}
+
+// decompressHunk decompress a hunk into dst and check its CRC
+func (d *ChdDisc) decompressHunk(hunk uint32, dst []byte) error {
+	e := &d.hunkMap[hunk]
+
+	switch e.compression {
+	case CHD_COMPRESSION_TYPE_0, CHD_COMPRESSION_TYPE_1, CHD_COMPRESSION_TYPE_2, CHD_COMPRESSION_TYPE_3:
+		codec := d.codecs[e.compression]
+		if codec == nil {
+			return fmt.Errorf("Hunk %d uses codec %d which the CHD doesn't have", hunk, e.compression)
+		}
+
+		src := make([]byte, e.length)
+		if _, err := d.file.ReadAt(src, int64(e.offset)); err != nil {
+			return fmt.Errorf("Failed to read hunk %d: %v", hunk, err)
+		}
+
+		if err := codec.decompress(src, dst); err != nil {
+			return fmt.Errorf("Hunk %d: %v", hunk, err)
+		}
+
+	case CHD_COMPRESSION_NONE:
+		// a zero offset in an uncompressed CHD is a hunk that was never written
+		if e.offset == 0 {
+			clear(dst)
+			return nil
+		}
+
+		if _, err := d.file.ReadAt(dst, int64(e.offset)); err != nil {
+			return fmt.Errorf("Failed to read hunk %d: %v", hunk, err)
+		}
+
+	case CHD_COMPRESSION_SELF:
+		// always an earlier hunk, the map was checked for that
+		return d.decompressHunk(uint32(e.offset), dst)
+
+	case CHD_COMPRESSION_PARENT:
+		return fmt.Errorf("Hunk %d is in a parent CHD", hunk)
+	}
+
+	// uncompressed CHDs don't have CRCs in the map
+	if d.codecs[0] != nil {
+		if crc := crc16(dst); crc != e.crc {
+			return fmt.Errorf("Hunk %d CRC is %04x, expected %04x", hunk, crc, e.crc)
+		}
+	}
+
+	return nil
+}
+
+// parseChdTrackType parse a track's TYPE into the track type and the
+// bytes of each sector that are stored
+func parseChdTrackType(ttype string) (TrackType, int, error) {
+	switch ttype {
+	case "MODE1":
+		return TrackMode1, DATA_SIZE, nil
+	case "MODE1_RAW":
+		return TrackMode1, SECTOR_SIZE, nil
+	case "MODE2_FORM1":
+		return TrackMode2, DATA_SIZE, nil
+	case "MODE2", "MODE2_FORM_MIX":
+		return TrackMode2, SECTOR_SIZE - SUBHEADER_OFFSET, nil
+	case "MODE2_RAW":
+		return TrackMode2, SECTOR_SIZE, nil
+	case "AUDIO":
+		return TrackAudio, SECTOR_SIZE, nil
+	default:
+		return TrackMode1, 0, fmt.Errorf("unsupported track type %q", ttype)
+	}
+}
+
+var crc16LUT [256]uint16
+
+func init() {
+	for i := range 256 {
+		crc := uint16(i) << 8
+		for range 8 {
+			crc = crc<<1 ^ (crc>>15)*0x1021
+		}
+		crc16LUT[i] = crc
+	}
+}
+
+// crc16 CRC-16/CCITT of data, what CHD checks hunks with
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crc16LUT[uint8(crc>>8)^b]
+	}
+
+	return crc
+}
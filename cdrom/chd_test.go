@@ -0,0 +1,283 @@
+package cdrom
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Known answer tests for the hand written decoders the CHD reader uses.
+// The inputs are built bit by bit here so there are no binary fixtures
+
+// bitWriter the other end of bitReader, most significant bit first
+type bitWriter struct {
+	data []byte
+	pos  int
+}
+
+// write write the low n bits of val
+func (w *bitWriter) write(val uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if val>>uint(i)&1 != 0 {
+			w.data[w.pos/8] |= 0x80 >> (w.pos % 8)
+		}
+		w.pos++
+	}
+}
+
+// writeSigned write val as an n bit two's complement number
+func (w *bitWriter) writeSigned(val int32, n uint) {
+	w.write(uint32(val)&(1<<n-1), n)
+}
+
+// writeString write a string of 0s and 1s, spaces are ignored
+func (w *bitWriter) writeString(bits string) {
+	for _, c := range strings.ReplaceAll(bits, " ", "") {
+		w.write(uint32(c-'0'), 1)
+	}
+}
+
+// align pad to the next byte with zeroes
+func (w *bitWriter) align() {
+	for w.pos%8 != 0 {
+		w.write(0, 1)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// CRC-16/CCITT-FALSE check value
+	if crc := crc16([]byte("123456789")); crc != 0x29b1 {
+		t.Errorf("crc16 = 0x%04x, want 0x29b1", crc)
+	}
+}
+
+func TestHuffmanTree(t *testing.T) {
+	// lengths 1, 2, 3, 3 then 12 unused codes as a run: 1 0 9 is 0
+	// repeated 9+3 times
+	var w bitWriter
+	w.write(1, 4)
+	w.write(1, 4) // 1 1 is a real 1
+	w.write(2, 4)
+	w.write(3, 4)
+	w.write(3, 4)
+	w.write(1, 4)
+	w.write(0, 4)
+	w.write(9, 4)
+
+	// longer codes get the smaller values: 2 is 000, 3 is 001, 1 is 01
+	// and 0 is 1
+	w.writeString("1 01 000 001 1 001")
+
+	bits := &bitReader{data: w.data}
+	tree, err := readHuffmanTree(bits, CHD_MAP_CODES, CHD_MAP_MAX_BITS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []uint8
+	for range 6 {
+		got = append(got, tree.decode(bits))
+	}
+
+	if want := []uint8{0, 1, 2, 3, 0, 3}; !slices.Equal(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
+
+func TestHuffmanTreeIncomplete(t *testing.T) {
+	// three 2 bit codes don't fill the tree
+	var w bitWriter
+	w.write(2, 4)
+	w.write(2, 4)
+	w.write(2, 4)
+
+	if _, err := readHuffmanTree(&bitReader{data: w.data}, 3, CHD_MAP_MAX_BITS); err == nil {
+		t.Error("incomplete tree was accepted")
+	}
+}
+
+// writeFlacHeader write a frame header for a block of blockSize 16 bit
+// samples, blockSize has to fit in 8 bits
+func writeFlacHeader(w *bitWriter, assignment uint32, blockSize int) {
+	w.write(FLAC_SYNC, 14)
+	w.write(0, 2)
+	w.write(6, 4) // 8 bit block size at the end of the header
+	w.write(9, 4) // 44.1kHz
+	w.write(assignment, 4)
+	w.write(4, 3) // 16 bit
+	w.write(0, 1)
+	w.write(0, 8) // frame number
+	w.write(uint32(blockSize-1), 8)
+	w.write(0, 8) // CRC8, not checked
+}
+
+// writeFlacFooter finish a frame
+func writeFlacFooter(w *bitWriter) {
+	w.align()
+	w.write(0, 16) // CRC16, not checked
+}
+
+func TestFlacFrameIndependent(t *testing.T) {
+	var w bitWriter
+	writeFlacHeader(&w, 1, 4)
+
+	// left, constant
+	w.writeString("0 000000 0")
+	w.writeSigned(-1000, 16)
+
+	// right, verbatim
+	w.writeString("0 000001 0")
+	for _, s := range []int32{1, -2, 3, -4} {
+		w.writeSigned(s, 16)
+	}
+
+	writeFlacFooter(&w)
+
+	checkFlacFrame(t, w.data, []int32{-1000, -1000, -1000, -1000}, []int32{1, -2, 3, -4})
+}
+
+func TestFlacFrameLeftSide(t *testing.T) {
+	var w bitWriter
+	writeFlacHeader(&w, FLAC_LEFT_SIDE, 4)
+
+	// left 10 12 11 15, fixed order 1 with the residuals 2 -1 4 Rice
+	// coded with parameter 2, zigzagged to 4 1 8
+	w.writeString("0 001001 0")
+	w.writeSigned(10, 16)
+	w.writeString("00 0000 0010")
+	w.writeString("01 00  1 01  001 00")
+
+	// side 2 -2 4 0, verbatim with a wasted bit so 16 bits instead of 17
+	w.writeString("0 000001 1 1")
+	for _, s := range []int32{1, -1, 2, 0} {
+		w.writeSigned(s, 16)
+	}
+
+	writeFlacFooter(&w)
+
+	checkFlacFrame(t, w.data, []int32{10, 12, 11, 15}, []int32{8, 14, 7, 15})
+}
+
+func TestFlacFrameLPC(t *testing.T) {
+	var w bitWriter
+	writeFlacHeader(&w, 1, 4)
+
+	// left, fixed order 0 with an escaped partition of raw 8 bit numbers
+	w.writeString("0 001000 0")
+	w.writeString("00 0000 1111")
+	w.write(8, 5)
+	for _, s := range []int32{5, -5, 7, -7} {
+		w.writeSigned(s, 8)
+	}
+
+	// right, LPC order 1 with 4 bit coefficients, 2 shifted right by 1
+	// so it predicts the last sample, then zero residuals
+	w.writeString("0 100000 0")
+	w.writeSigned(100, 16)
+	w.write(3, 4)
+	w.writeSigned(1, 5)
+	w.writeSigned(2, 4)
+	w.writeString("00 0000 0000")
+	w.writeString("1 1 1")
+
+	writeFlacFooter(&w)
+
+	checkFlacFrame(t, w.data, []int32{5, -5, 7, -7}, []int32{100, 100, 100, 100})
+}
+
+// checkFlacFrame decode the frame in data and compare both channels
+func checkFlacFrame(t *testing.T, data []byte, left, right []int32) {
+	t.Helper()
+
+	channels, err := decodeFlacFrame(&bitReader{data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(channels[0], left) {
+		t.Errorf("left %v, want %v", channels[0], left)
+	}
+	if !slices.Equal(channels[1], right) {
+		t.Errorf("right %v, want %v", channels[1], right)
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	const hunkBytes = 8 * CHD_FRAME_SIZE
+	const firstOffset = 0x1000
+
+	// every type gets a 4 bit code: 4 repeated 13+3 times
+	var w bitWriter
+	w.write(1, 4)
+	w.write(4, 4)
+	w.write(13, 4)
+
+	// stored, a copy of hunk 0, compressed with the first codec
+	w.write(CHD_COMPRESSION_NONE, 4)
+	w.write(CHD_COMPRESSION_SELF, 4)
+	w.write(CHD_COMPRESSION_TYPE_0, 4)
+
+	w.write(0x1234, 16) // hunk 0 CRC
+	w.write(0, 8)       // hunk 1 refers to hunk 0
+	w.write(0x100, 20)  // hunk 2 length
+	w.write(0xbeef, 16) // hunk 2 CRC
+	w.align()
+
+	// what MAME hashes, 12 bytes a hunk
+	raw := []byte{
+		CHD_COMPRESSION_NONE, 0x00, 0x4c, 0x80, 0, 0, 0, 0, 0x10, 0x00, 0x12, 0x34,
+		CHD_COMPRESSION_SELF, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0x00, 0x00, 0x00, 0x00,
+		CHD_COMPRESSION_TYPE_0, 0x00, 0x01, 0x00, 0, 0, 0, 0, 0x5c, 0x80, 0xbe, 0xef,
+	}
+
+	header := make([]byte, CHD_MAP_HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(w.data)))
+	binary.BigEndian.PutUint32(header[6:], firstOffset)
+	binary.BigEndian.PutUint16(header[10:], crc16(raw))
+	header[12], header[13], header[14] = 20, 8, 0
+
+	want := []chdMapEntry{
+		{CHD_COMPRESSION_NONE, hunkBytes, firstOffset, 0x1234},
+		{CHD_COMPRESSION_SELF, 0, 0, 0},
+		{CHD_COMPRESSION_TYPE_0, 0x100, firstOffset + hunkBytes, 0xbeef},
+	}
+
+	disc := openTestMap(t, append(header, w.data...))
+	if err := disc.readMap(0, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(disc.hunkMap, want) {
+		t.Errorf("map %+v, want %+v", disc.hunkMap, want)
+	}
+
+	// a map that doesn't match its CRC gets turned down
+	header[11] ^= 1
+	disc = openTestMap(t, append(header, w.data...))
+	if err := disc.readMap(0, 3, true); err == nil {
+		t.Error("map with a bad CRC was accepted")
+	}
+}
+
+// openTestMap a ChdDisc with just the map in its file
+func openTestMap(t *testing.T, data []byte) *ChdDisc {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "map")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	return &ChdDisc{file: file, hunkBytes: 8 * CHD_FRAME_SIZE, unitBytes: CHD_FRAME_SIZE}
+}
@@ -0,0 +1,208 @@
+package cdrom
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// CHD hunk codecs. The plain ones decompress a whole hunk, the CD ones
+// split each hunk into the sector data and the subcode of its frames,
+// compress them apart and leave out the sync and ECC of sectors where
+// they can be built again. A CD hunk is
+//
+//	ECC bitmap, one bit per frame, set if its sync and ECC were removed
+//	length of the compressed sector data, 2 or 3 bytes
+//	sector data, compressed with the base codec
+//	subcode, compressed with zlib
+
+// Codec tags from the CHD header
+const (
+	CHD_CODEC_NONE = 0
+	CHD_CODEC_ZLIB = 0x7a6c6962 // zlib
+	CHD_CODEC_LZMA = 0x6c7a6d61 // lzma
+	CHD_CODEC_FLAC = 0x666c6163 // flac
+	CHD_CODEC_CDZL = 0x63647a6c // cdzl, CD with zlib
+	CHD_CODEC_CDLZ = 0x63646c7a // cdlz, CD with LZMA
+	CHD_CODEC_CDFL = 0x6364666c // cdfl, CD with FLAC
+)
+
+const (
+	LZMA_PROPERTIES   = (2*5+0)*9 + 3 // pb 2, lp 0, lc 3, what CHD always uses
+	LZMA_MIN_DICT_CAP = 1 << 12       // smallest dictionary the decoder takes
+)
+
+// Decompresses a hunk
+type chdCodec interface {
+	// decompress decompress src, filling all of dst
+	decompress(src []byte, dst []byte) error
+}
+
+// newChdCodec make the codec for a tag in the header
+func newChdCodec(tag uint32) (chdCodec, error) {
+	switch tag {
+	case CHD_CODEC_ZLIB:
+		return zlibCodec{}, nil
+	case CHD_CODEC_LZMA:
+		return lzmaCodec{}, nil
+	case CHD_CODEC_FLAC:
+		return flacCodec{}, nil
+	case CHD_CODEC_CDZL:
+		return cdCodec{base: zlibCodec{}}, nil
+	case CHD_CODEC_CDLZ:
+		return cdCodec{base: lzmaCodec{}}, nil
+	case CHD_CODEC_CDFL:
+		return cdFlacCodec{}, nil
+	default:
+		var name [4]byte
+		binary.BigEndian.PutUint32(name[:], tag)
+		return nil, fmt.Errorf("Unsupported CHD codec %q", name[:])
+	}
+}
+
+// Raw deflate, despite the name there's no zlib header
+type zlibCodec struct{}
+
+func (zlibCodec) decompress(src []byte, dst []byte) error {
+	reader := flate.NewReader(bytes.NewReader(src))
+	defer reader.Close()
+
+	if _, err := io.ReadFull(reader, dst); err != nil {
+		return fmt.Errorf("Failed to inflate hunk: %v", err)
+	}
+
+	return nil
+}
+
+// LZMA without its header or an end marker. The properties are always
+// the same and the size is the size of dst, so a header gets made up
+// for the decoder
+type lzmaCodec struct{}
+
+func (lzmaCodec) decompress(src []byte, dst []byte) error {
+	var header [13]byte
+	header[0] = LZMA_PROPERTIES
+	binary.LittleEndian.PutUint32(header[1:], uint32(max(len(dst), LZMA_MIN_DICT_CAP)))
+	binary.LittleEndian.PutUint64(header[5:], uint64(len(dst)))
+
+	reader, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header[:]), bytes.NewReader(src)))
+	if err != nil {
+		return fmt.Errorf("Failed to decompress LZMA hunk: %v", err)
+	}
+
+	if _, err := io.ReadFull(reader, dst); err != nil {
+		return fmt.Errorf("Failed to decompress LZMA hunk: %v", err)
+	}
+
+	return nil
+}
+
+// FLAC of 16 bit stereo, the first byte says the endianness to write
+// the samples in
+type flacCodec struct{}
+
+func (flacCodec) decompress(src []byte, dst []byte) error {
+	if len(src) == 0 {
+		return fmt.Errorf("Empty FLAC hunk")
+	}
+
+	var bigEndian bool
+	switch src[0] {
+	case 'B':
+		bigEndian = true
+	case 'L':
+		bigEndian = false
+	default:
+		return fmt.Errorf("Bad FLAC hunk endianness %q", src[0])
+	}
+
+	if _, err := decodeFlac(src[1:], dst, bigEndian); err != nil {
+		return fmt.Errorf("Failed to decompress FLAC hunk: %v", err)
+	}
+
+	return nil
+}
+
+// CD frames with the sector data compressed by base
+type cdCodec struct {
+	base chdCodec
+}
+
+func (c cdCodec) decompress(src []byte, dst []byte) error {
+	frames := len(dst) / CHD_FRAME_SIZE
+
+	lengthBytes := 2
+	if len(dst) >= 1<<16 {
+		lengthBytes = 3
+	}
+
+	eccBytes := (frames + 7) / 8
+	headerBytes := eccBytes + lengthBytes
+	if len(src) < headerBytes {
+		return fmt.Errorf("CD hunk is cut short")
+	}
+
+	baseLength := 0
+	for _, b := range src[eccBytes:headerBytes] {
+		baseLength = baseLength<<8 | int(b)
+	}
+
+	if headerBytes+baseLength > len(src) {
+		return fmt.Errorf("CD hunk is cut short")
+	}
+
+	sectors := make([]byte, frames*SECTOR_SIZE)
+	if err := c.base.decompress(src[headerBytes:headerBytes+baseLength], sectors); err != nil {
+		return err
+	}
+
+	subcode := make([]byte, frames*CHD_SUBCODE_SIZE)
+	if err := (zlibCodec{}).decompress(src[headerBytes+baseLength:], subcode); err != nil {
+		return err
+	}
+
+	joinCDFrames(dst, sectors, subcode, src[:eccBytes])
+	return nil
+}
+
+// CD frames with the sector data as FLAC audio, big endian like all CD
+// audio in a CHD. There's no ECC bitmap or length, the subcode starts
+// right where the FLAC frames end
+type cdFlacCodec struct{}
+
+func (cdFlacCodec) decompress(src []byte, dst []byte) error {
+	frames := len(dst) / CHD_FRAME_SIZE
+
+	sectors := make([]byte, frames*SECTOR_SIZE)
+	used, err := decodeFlac(src, sectors, true)
+	if err != nil {
+		return fmt.Errorf("Failed to decompress FLAC hunk: %v", err)
+	}
+
+	subcode := make([]byte, frames*CHD_SUBCODE_SIZE)
+	if err := (zlibCodec{}).decompress(src[used:], subcode); err != nil {
+		return err
+	}
+
+	joinCDFrames(dst, sectors, subcode, nil)
+	return nil
+}
+
+// joinCDFrames put the sector data and subcode back together into
+// frames, building the sync and ECC of the ones in the bitmap
+func joinCDFrames(dst []byte, sectors []byte, subcode []byte, eccBitmap []byte) {
+	for frame := range len(dst) / CHD_FRAME_SIZE {
+		out := dst[frame*CHD_FRAME_SIZE : (frame+1)*CHD_FRAME_SIZE]
+		copy(out, sectors[frame*SECTOR_SIZE:(frame+1)*SECTOR_SIZE])
+		copy(out[SECTOR_SIZE:], subcode[frame*CHD_SUBCODE_SIZE:(frame+1)*CHD_SUBCODE_SIZE])
+
+		if eccBitmap != nil && eccBitmap[frame/8]&(1<<(frame%8)) != 0 {
+			copy(out[SYNC_OFFSET:], syncPattern)
+			computeECC(out)
+		}
+	}
+}
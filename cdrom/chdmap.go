@@ -0,0 +1,293 @@
+package cdrom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The CHD v5 hunk map says where every hunk is in the file and how it's
+// compressed. When the CHD is compressed the map itself is too: first
+// the compression type of every hunk, Huffman coded with runs, then
+// for each hunk the fields its type needs as plain bit fields
+//
+//	0x00  compressed map length
+//	0x04  offset of the first hunk, 48 bits
+//	0x0a  CRC16 of the decompressed map
+//	0x0c  bits in a compressed length
+//	0x0d  bits in a self reference
+//	0x0e  bits in a parent reference
+//	0x10  the compressed map
+
+// Hunk compression types in the map
+const (
+	CHD_COMPRESSION_TYPE_0      = 0  // compressed with the first codec
+	CHD_COMPRESSION_TYPE_1      = 1  // compressed with the second codec
+	CHD_COMPRESSION_TYPE_2      = 2  // compressed with the third codec
+	CHD_COMPRESSION_TYPE_3      = 3  // compressed with the fourth codec
+	CHD_COMPRESSION_NONE        = 4  // stored as is
+	CHD_COMPRESSION_SELF        = 5  // same as another hunk in this file
+	CHD_COMPRESSION_PARENT      = 6  // same as a unit in the parent
+	CHD_COMPRESSION_RLE_SMALL   = 7  // repeat the last type 2-17 times
+	CHD_COMPRESSION_RLE_LARGE   = 8  // repeat the last type 18-273 times
+	CHD_COMPRESSION_SELF_0      = 9  // same self reference as last time
+	CHD_COMPRESSION_SELF_1      = 10 // last self reference plus one
+	CHD_COMPRESSION_PARENT_SELF = 11 // the same spot in the parent
+	CHD_COMPRESSION_PARENT_0    = 12 // same parent reference as last time
+	CHD_COMPRESSION_PARENT_1    = 13 // last parent reference plus one hunk
+)
+
+const (
+	CHD_MAP_HEADER_SIZE = 16 // compressed map header
+	CHD_MAP_ENTRY_SIZE  = 12 // bytes a hunk in the decoded map the CRC is over
+	CHD_MAP_CODES       = 16 // Huffman codes in the map's type tree
+	CHD_MAP_MAX_BITS    = 8  // longest code in the map's type tree
+)
+
+// Where a hunk is and how to get it back
+type chdMapEntry struct {
+	compression uint8  // CHD_COMPRESSION_TYPE_0 to CHD_COMPRESSION_PARENT
+	length      uint32 // compressed bytes
+	offset      uint64 // file offset, hunk number for self or unit number for parent
+	crc         uint16 // CRC16 of the decompressed hunk
+}
+
+// readMap read and decode the hunk map
+func (d *ChdDisc) readMap(mapOffset uint64, hunkCount uint32, compressed bool) error {
+	d.hunkMap = make([]chdMapEntry, hunkCount)
+
+	// uncompressed CHDs just have the offset of every hunk in hunks
+	if !compressed {
+		raw := make([]byte, hunkCount*4)
+		if _, err := d.file.ReadAt(raw, int64(mapOffset)); err != nil {
+			return fmt.Errorf("Failed to read CHD map: %v", err)
+		}
+
+		for i := range d.hunkMap {
+			d.hunkMap[i] = chdMapEntry{
+				compression: CHD_COMPRESSION_NONE,
+				length:      d.hunkBytes,
+				offset:      uint64(binary.BigEndian.Uint32(raw[i*4:])) * uint64(d.hunkBytes),
+			}
+		}
+
+		return nil
+	}
+
+	var header [CHD_MAP_HEADER_SIZE]byte
+	if _, err := d.file.ReadAt(header[:], int64(mapOffset)); err != nil {
+		return fmt.Errorf("Failed to read CHD map: %v", err)
+	}
+
+	mapBytes := binary.BigEndian.Uint32(header[0:])
+	firstOffset := uint64(binary.BigEndian.Uint16(header[4:]))<<32 | uint64(binary.BigEndian.Uint32(header[6:]))
+	mapCRC := binary.BigEndian.Uint16(header[10:])
+	lengthBits := uint(header[12])
+	selfBits := uint(header[13])
+	parentBits := uint(header[14])
+
+	raw := make([]byte, mapBytes)
+	if _, err := d.file.ReadAt(raw, int64(mapOffset)+CHD_MAP_HEADER_SIZE); err != nil {
+		return fmt.Errorf("Failed to read CHD map: %v", err)
+	}
+
+	bits := &bitReader{data: raw}
+	tree, err := readHuffmanTree(bits, CHD_MAP_CODES, CHD_MAP_MAX_BITS)
+	if err != nil {
+		return fmt.Errorf("Failed to read CHD map: %v", err)
+	}
+
+	// the types first, with runs of the same type
+	repeat := 0
+	last := uint8(0)
+	for i := range d.hunkMap {
+		if repeat > 0 {
+			d.hunkMap[i].compression = last
+			repeat--
+			continue
+		}
+
+		switch val := tree.decode(bits); val {
+		case CHD_COMPRESSION_RLE_SMALL:
+			d.hunkMap[i].compression = last
+			repeat = 2 + int(tree.decode(bits))
+		case CHD_COMPRESSION_RLE_LARGE:
+			d.hunkMap[i].compression = last
+			repeat = 2 + 16 + int(tree.decode(bits))<<4
+			repeat += int(tree.decode(bits))
+		default:
+			d.hunkMap[i].compression = val
+			last = val
+		}
+	}
+
+	// then where each one is, the pseudo types become real ones here
+	offset := firstOffset
+	lastSelf := uint64(0)
+	lastParent := uint64(0)
+	unitsPerHunk := uint64(d.hunkBytes / d.unitBytes)
+
+	for i := range d.hunkMap {
+		e := &d.hunkMap[i]
+
+		switch e.compression {
+		case CHD_COMPRESSION_TYPE_0, CHD_COMPRESSION_TYPE_1, CHD_COMPRESSION_TYPE_2, CHD_COMPRESSION_TYPE_3:
+			e.offset = offset
+			e.length = bits.read(lengthBits)
+			e.crc = uint16(bits.read(16))
+			offset += uint64(e.length)
+		case CHD_COMPRESSION_NONE:
+			e.offset = offset
+			e.length = d.hunkBytes
+			e.crc = uint16(bits.read(16))
+			offset += uint64(e.length)
+		case CHD_COMPRESSION_SELF:
+			lastSelf = uint64(bits.read(selfBits))
+			e.offset = lastSelf
+		case CHD_COMPRESSION_PARENT:
+			lastParent = uint64(bits.read(parentBits))
+			e.offset = lastParent
+		case CHD_COMPRESSION_SELF_1:
+			lastSelf++
+			fallthrough
+		case CHD_COMPRESSION_SELF_0:
+			e.compression = CHD_COMPRESSION_SELF
+			e.offset = lastSelf
+		case CHD_COMPRESSION_PARENT_SELF:
+			e.compression = CHD_COMPRESSION_PARENT
+			lastParent = uint64(i) * unitsPerHunk
+			e.offset = lastParent
+		case CHD_COMPRESSION_PARENT_1:
+			lastParent += unitsPerHunk
+			fallthrough
+		case CHD_COMPRESSION_PARENT_0:
+			e.compression = CHD_COMPRESSION_PARENT
+			e.offset = lastParent
+		default:
+			return fmt.Errorf("Bad compression type %d for hunk %d in CHD map", e.compression, i)
+		}
+
+		if e.compression == CHD_COMPRESSION_SELF && e.offset >= uint64(i) {
+			return fmt.Errorf("Hunk %d in CHD map refers to hunk %d after it", i, e.offset)
+		}
+	}
+
+	if bits.overrun {
+		return fmt.Errorf("CHD map is cut short")
+	}
+
+	if crc := d.mapCRC(); crc != mapCRC {
+		return fmt.Errorf("CHD map CRC is 0x%04x instead of 0x%04x", crc, mapCRC)
+	}
+
+	return nil
+}
+
+// mapCRC the CRC16 of the decoded map laid out the way MAME keeps it,
+// 12 bytes a hunk:
+//
+//	0x00  compression type, SELF or PARENT for the pseudo types
+//	0x01  compressed length, 24 bits
+//	0x04  offset, 48 bits
+//	0x0a  CRC16 of the hunk
+func (d *ChdDisc) mapCRC() uint16 {
+	raw := make([]byte, len(d.hunkMap)*CHD_MAP_ENTRY_SIZE)
+	for i, e := range d.hunkMap {
+		entry := raw[i*CHD_MAP_ENTRY_SIZE:]
+		entry[0] = e.compression
+		entry[1], entry[2], entry[3] = uint8(e.length>>16), uint8(e.length>>8), uint8(e.length)
+		binary.BigEndian.PutUint16(entry[4:], uint16(e.offset>>32))
+		binary.BigEndian.PutUint32(entry[6:], uint32(e.offset))
+		binary.BigEndian.PutUint16(entry[10:], e.crc)
+	}
+
+	return crc16(raw)
+}
+
+// A canonical Huffman tree the way CHD builds them, longer codes get
+// the smaller values
+type huffmanTree struct {
+	maxBits uint
+	lookup  []uint16 // code<<5 | bits, indexed by the next maxBits bits
+}
+
+// readHuffmanTree read a tree stored as run length coded code lengths
+func readHuffmanTree(bits *bitReader, numCodes int, maxBits uint) (*huffmanTree, error) {
+	lengthBits := uint(3)
+	if maxBits >= 16 {
+		lengthBits = 5
+	} else if maxBits >= 8 {
+		lengthBits = 4
+	}
+
+	lengths := make([]uint8, 0, numCodes)
+	for len(lengths) < numCodes {
+		// a 1 is an escape, 1 1 is a real 1 and 1 n r is n repeated r+3 times
+		length := uint8(bits.read(lengthBits))
+		if length != 1 {
+			lengths = append(lengths, length)
+			continue
+		}
+
+		length = uint8(bits.read(lengthBits))
+		if length == 1 {
+			lengths = append(lengths, length)
+			continue
+		}
+
+		repeat := int(bits.read(lengthBits)) + 3
+		if len(lengths)+repeat > numCodes {
+			return nil, fmt.Errorf("Huffman tree has too many codes")
+		}
+
+		for range repeat {
+			lengths = append(lengths, length)
+		}
+	}
+
+	// starting code for each length, going from the longest
+	var starts [33]uint32
+	for _, length := range lengths {
+		if uint(length) > maxBits {
+			return nil, fmt.Errorf("Huffman code is longer than %d bits", maxBits)
+		}
+		starts[length]++
+	}
+
+	cur := uint32(0)
+	for length := 32; length > 0; length-- {
+		next := (cur + starts[length]) >> 1
+		if length != 1 && next*2 != cur+starts[length] {
+			return nil, fmt.Errorf("Huffman tree isn't complete")
+		}
+
+		starts[length] = cur
+		cur = next
+	}
+
+	tree := &huffmanTree{maxBits: maxBits, lookup: make([]uint16, 1<<maxBits)}
+	for code, length := range lengths {
+		if length == 0 {
+			continue
+		}
+
+		bitsVal := starts[length]
+		starts[length]++
+
+		// every lookup index that starts with this code
+		shift := maxBits - uint(length)
+		first := bitsVal << shift
+		for i := range uint32(1) << shift {
+			tree.lookup[first+i] = uint16(code)<<5 | uint16(length)
+		}
+	}
+
+	return tree, nil
+}
+
+// decode read one code
+func (t *huffmanTree) decode(bits *bitReader) uint8 {
+	entry := t.lookup[bits.peek(t.maxBits)]
+	bits.skip(uint(entry & 0x1f))
+
+	return uint8(entry >> 5)
+}
@@ -0,0 +1,367 @@
+package cdrom
+
+import "github.com/TheOrnyx/psx-go/log"
+
+// The controller's commands. Every command gets a first response
+// (INT3, or INT5 if it failed) and some get a second one (INT2 or INT5)
+// once whatever they started is done
+
+// Parameter counts for commands that don't take a fixed number
+const (
+	PARAMS_ANY      = -1 // at least one
+	PARAMS_OPTIONAL = -2 // none or one
+)
+
+// A CDROM controller command
+type Command struct {
+	opcode uint8                          // the opcode
+	params int                            // number of parameters, or PARAMS_ANY or PARAMS_OPTIONAL
+	name   string                         // the name
+	run    func(c *CDROM, params []uint8) // the run function
+}
+
+var cdromCommands map[uint8]Command = map[uint8]Command{
+	0x01: {0x01, 0, "GetStat", func(c *CDROM, params []uint8) { c.cmdGetStat() }},
+	0x02: {0x02, 3, "Setloc", func(c *CDROM, params []uint8) { c.cmdSetloc(params) }},
+	0x03: {0x03, PARAMS_OPTIONAL, "Play", func(c *CDROM, params []uint8) { c.cmdPlay(params) }},
+	0x04: {0x04, 0, "Forward", func(c *CDROM, params []uint8) { c.cmdScan(1) }},
+	0x05: {0x05, 0, "Backward", func(c *CDROM, params []uint8) { c.cmdScan(-1) }},
+	0x06: {0x06, 0, "ReadN", func(c *CDROM, params []uint8) { c.cmdRead() }},
+	0x08: {0x08, 0, "Stop", func(c *CDROM, params []uint8) { c.cmdStop() }},
+	0x09: {0x09, 0, "Pause", func(c *CDROM, params []uint8) { c.cmdPause() }},
+	0x0a: {0x0a, 0, "Init", func(c *CDROM, params []uint8) { c.cmdInit() }},
+	0x0b: {0x0b, 0, "Mute", func(c *CDROM, params []uint8) { c.cmdMute(true) }},
+	0x0c: {0x0c, 0, "Demute", func(c *CDROM, params []uint8) { c.cmdMute(false) }},
+	0x0d: {0x0d, 2, "Setfilter", func(c *CDROM, params []uint8) { c.cmdSetfilter(params) }},
+	0x0e: {0x0e, 1, "Setmode", func(c *CDROM, params []uint8) { c.cmdSetmode(params) }},
+	0x10: {0x10, 0, "GetlocL", func(c *CDROM, params []uint8) { c.cmdGetlocL() }},
+	0x11: {0x11, 0, "GetlocP", func(c *CDROM, params []uint8) { c.cmdGetlocP() }},
+	0x13: {0x13, 0, "GetTN", func(c *CDROM, params []uint8) { c.cmdGetTN() }},
+	0x14: {0x14, 1, "GetTD", func(c *CDROM, params []uint8) { c.cmdGetTD(params) }},
+	0x15: {0x15, 0, "SeekL", func(c *CDROM, params []uint8) { c.cmdSeek() }},
+	0x16: {0x16, 0, "SeekP", func(c *CDROM, params []uint8) { c.cmdSeek() }},
+	0x19: {0x19, PARAMS_ANY, "Test", func(c *CDROM, params []uint8) { c.cmdTest(params) }},
+	0x1a: {0x1a, 0, "GetID", func(c *CDROM, params []uint8) { c.cmdGetID() }},
+	0x1b: {0x1b, 0, "ReadS", func(c *CDROM, params []uint8) { c.cmdRead() }},
+}
+
+// WriteCMD write to command register, runs the command with whatever
+// is in the parameter FIFO
+func (c *CDROM) WriteCMD(val uint8) {
+	params := c.params.drain()
+
+	if c.busy {
+		log.Warnf("CDROM command 0x%02x sent while the last one is still busy", val)
+	}
+	c.busy = true
+
+	cmd, found := cdromCommands[val]
+	if !found {
+		log.Warnf("Unhandled CDROM command 0x%02x", val)
+		c.errorResponse(ERR_INVALID_COMMAND)
+		return
+	}
+
+	var badCount bool
+	switch cmd.params {
+	case PARAMS_ANY:
+		badCount = len(params) == 0
+	case PARAMS_OPTIONAL:
+		badCount = len(params) > 1
+	default:
+		badCount = len(params) != cmd.params
+	}
+
+	if badCount {
+		log.Warnf("CDROM %s sent with %d parameters", cmd.name, len(params))
+		c.errorResponse(ERR_PARAM_COUNT)
+		return
+	}
+
+	log.Infof("CDROM %s %x", cmd.name, params)
+	cmd.run(c, params)
+}
+
+// cmdGetStat 01h - GetStat, just the stat byte. It's what clears the
+// shell open bit once the lid is closed
+func (c *CDROM) cmdGetStat() {
+	c.ack(c.stat())
+
+	if !c.lidOpen {
+		c.shellOpened = false
+	}
+}
+
+// cmdSetloc 02h - Setloc(amm, ass, asect), set where the next read or
+// seek goes to
+func (c *CDROM) cmdSetloc(params []uint8) {
+	target, err := MSFFromBCD(params[0], params[1], params[2])
+	if err != nil {
+		log.Warnf("CDROM Setloc: %v", err)
+		c.errorResponse(ERR_INVALID_PARAM)
+		return
+	}
+
+	c.seekTarget = target
+	c.seekPending = true
+	c.ack(c.stat())
+}
+
+// cmdPlay 03h - Play(track), play CD-DA from the start of a track, or
+// without one (or track 0) from the Setloc position or wherever the
+// drive is
+func (c *CDROM) cmdPlay(params []uint8) {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	track := uint8(0)
+	if len(params) > 0 {
+		track = fromBCD(params[0])
+		if !validBCD(params[0]) || track > c.trackCount() {
+			c.errorResponse(ERR_INVALID_PARAM)
+			return
+		}
+	}
+
+	seeked := c.seekPending
+	if track != 0 {
+		c.position = c.trackStart(track).LBA()
+		c.seekPending = false
+		seeked = true
+	} else if c.seekPending {
+		c.position = c.seekTarget.LBA()
+		c.seekPending = false
+	}
+
+	c.stopReading()
+	c.startPlaying(seeked)
+	c.ack(c.stat())
+}
+
+// cmdScan 04h - Forward and 05h - Backward, skip through the audio
+// while playing
+func (c *CDROM) cmdScan(direction int8) {
+	if c.state != DrivePlaying {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	c.scan = direction
+	c.ack(c.stat())
+}
+
+// cmdRead 06h - ReadN and 1Bh - ReadS, start reading data sectors from
+// the Setloc position or wherever the drive is
+func (c *CDROM) cmdRead() {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	seeked := c.seekPending
+	if c.seekPending {
+		c.position = c.seekTarget.LBA()
+		c.seekPending = false
+	}
+
+	c.stopReading()
+	c.startReading(seeked)
+	c.ack(c.stat())
+}
+
+// cmdStop 08h - Stop, stop reading and turn the motor off
+func (c *CDROM) cmdStop() {
+	delay := int32(STOP_CYCLES)
+	if c.state == DriveStopped {
+		delay = STOPPED_CYCLES
+	}
+
+	c.ack(c.stat())
+	c.stopReading()
+	c.state = DriveStopped
+	c.respond(delay, INT2, c.stat())
+}
+
+// cmdPause 09h - Pause, stop reading or playing and stay where we are
+func (c *CDROM) cmdPause() {
+	delay := int32(PAUSE_IDLE_CYCLES)
+	if c.state != DriveIdle && c.state != DriveStopped {
+		delay = c.sectorCycles()
+	}
+
+	c.ack(c.stat())
+	c.stopReading()
+	if c.state != DriveStopped {
+		c.state = DriveIdle
+	}
+	c.respond(delay, INT2, c.stat())
+}
+
+// cmdInit 0Ah - Init, abort everything, reset the mode and start the
+// motor
+func (c *CDROM) cmdInit() {
+	c.responses = nil
+	c.stopReading()
+	c.mode = MODE_WHOLE_SECTOR
+	c.seekPending = false
+	if !c.lidOpen {
+		c.state = DriveIdle
+	}
+
+	c.queueResponse(INIT_ACK_CYCLES, INT3, true, c.stat())
+	c.respond(INIT_CYCLES, INT2, c.stat())
+}
+
+// cmdMute 0Bh - Mute and 0Ch - Demute, turn CD audio off or on
+func (c *CDROM) cmdMute(muted bool) {
+	c.muted = muted
+	c.ack(c.stat())
+}
+
+// cmdSetfilter 0Dh - Setfilter(file, channel), which XA-ADPCM sectors
+// to play when the filter is on
+func (c *CDROM) cmdSetfilter(params []uint8) {
+	if params[0] != c.filterFile || params[1] != c.filterChannel {
+		c.xa.reset()
+	}
+
+	c.filterFile = params[0]
+	c.filterChannel = params[1]
+	c.ack(c.stat())
+}
+
+// cmdSetmode 0Eh - Setmode(mode), see the MODE_ bits
+func (c *CDROM) cmdSetmode(params []uint8) {
+	c.mode = params[0]
+	c.ack(c.stat())
+}
+
+// cmdGetlocL 10h - GetlocL, the header and subheader of the last data
+// sector read
+func (c *CDROM) cmdGetlocL() {
+	if !c.haveHeader {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	c.ack(c.lastHeader[:]...)
+}
+
+// cmdGetlocP 11h - GetlocP, track, index and position from the last
+// good subchannel Q
+func (c *CDROM) cmdGetlocP() {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	if !c.haveSubQ {
+		c.updateSubQ(c.position)
+	}
+
+	q := c.lastSubQ
+	c.ack(q[1], q[2], q[3], q[4], q[5], q[7], q[8], q[9])
+}
+
+// cmdGetTN 13h - GetTN, first and last track numbers
+func (c *CDROM) cmdGetTN() {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	c.ack(c.stat(), 0x01, toBCD(c.trackCount()))
+}
+
+// cmdGetTD 14h - GetTD(track), start of a track, track 0 is the end of
+// the disc
+func (c *CDROM) cmdGetTD(params []uint8) {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	track := fromBCD(params[0])
+	if !validBCD(params[0]) || track > c.trackCount() {
+		c.errorResponse(ERR_INVALID_PARAM)
+		return
+	}
+
+	start := c.discEnd()
+	if track != 0 {
+		start = c.trackStart(track)
+	}
+
+	m, s, _ := start.BCD()
+	c.ack(c.stat(), m, s)
+}
+
+// cmdSeek 15h - SeekL and 16h - SeekP, move to the Setloc position
+// using the data headers or the subchannel. Both end up in the same
+// place here
+func (c *CDROM) cmdSeek() {
+	if !c.hasDisc() {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	c.stopReading()
+	c.state = DriveSeeking
+	c.ack(c.stat())
+
+	// the drive says it's seeking until it gets there
+	c.respondFinish(SEEK_CYCLES, INT2, func() []uint8 {
+		if c.state != DriveSeeking {
+			return []uint8{c.stat()} // something else took over, like a read or the lid opening
+		}
+
+		c.position = c.seekTarget.LBA()
+		c.seekPending = false
+		c.updateSubQ(c.position)
+		c.state = DriveIdle
+
+		return []uint8{c.stat()}
+	})
+}
+
+// cmdTest 19h - Test(sub), a bunch of sub functions for testing the
+// hardware, only the ones games and the BIOS use are here
+func (c *CDROM) cmdTest(params []uint8) {
+	switch params[0] {
+	case 0x04: // start counting SCEx strings
+		c.ack(c.stat())
+	case 0x05: // stop counting SCEx strings, total and successful reads
+		c.ack(0x00, 0x00)
+	case 0x20: // controller version, 1994-09-19 version C0
+		c.ack(0x94, 0x09, 0x19, 0xc0)
+	default:
+		log.Warnf("Unhandled CDROM Test sub function 0x%02x", params[0])
+		c.errorResponse(ERR_INVALID_PARAM)
+	}
+}
+
+// cmdGetID 1Ah - GetID, whether there's a disc and what region it's
+// licensed for
+func (c *CDROM) cmdGetID() {
+	if c.lidOpen {
+		c.errorResponse(ERR_NOT_READY)
+		return
+	}
+
+	c.ack(c.stat())
+
+	if !c.hasDisc() {
+		c.respond(GETID_CYCLES, INT5, 0x08, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+		return
+	}
+
+	if c.disc.Tracks()[0].Type == TrackAudio {
+		c.respond(GETID_CYCLES, INT5, c.stat()|STAT_ID_ERROR, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+		return
+	}
+
+	// licensed mode 2 disc
+	c.respond(GETID_CYCLES, INT2, c.stat(), 0x00, 0x20, 0x00, 'S', 'C', 'E', c.region)
+}
@@ -0,0 +1,45 @@
+package cdrom
+
+import "testing"
+
+// deliverNext run the controller until the next response is delivered,
+// acknowledging the last interrupt first, and return its interrupt and
+// data
+func deliverNext(t *testing.T, c *CDROM) (uint8, []uint8) {
+	t.Helper()
+
+	c.intFlagReg = 0
+	if len(c.responses) == 0 {
+		t.Fatalf("no response queued")
+	}
+
+	c.Tick(uint32(c.responses[0].delay))
+	return c.intFlagReg & 7, c.response.drain()
+}
+
+func TestSeekReportsSeeking(t *testing.T) {
+	c := &CDROM{disc: &memDisc{}, state: DriveIdle}
+	c.seekTarget = MSFFromLBA(20)
+	c.seekPending = true
+
+	c.cmdSeek()
+
+	interrupt, data := deliverNext(t, c)
+	if interrupt != INT3 || len(data) != 1 || data[0]&STAT_SEEKING == 0 {
+		t.Errorf("first response INT%d % x, want INT3 with the seeking bit", interrupt, data)
+	}
+
+	// still on the way there
+	if c.stat()&STAT_SEEKING == 0 || c.position != 0 {
+		t.Errorf("stat 0x%02x at %d before the seek finished, want seeking at 0", c.stat(), c.position)
+	}
+
+	interrupt, data = deliverNext(t, c)
+	if interrupt != INT2 || len(data) != 1 || data[0]&STAT_SEEKING != 0 {
+		t.Errorf("second response INT%d % x, want INT2 without the seeking bit", interrupt, data)
+	}
+
+	if c.state != DriveIdle || c.position != 20 || c.seekPending {
+		t.Errorf("after the seek state %v at %d pending %v, want idle at 20", c.state, c.position, c.seekPending)
+	}
+}
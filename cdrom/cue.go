@@ -0,0 +1,381 @@
+package cdrom
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheOrnyx/psx-go/log"
+)
+
+// BIN/CUE images. The CUE sheet is a text file listing the BIN files
+// the sectors are in and where the tracks and their indices are in
+// them:
+//
+//	FILE "game (track 1).bin" BINARY
+//	  TRACK 01 MODE2/2352
+//	    INDEX 01 00:00:00
+//	FILE "game (track 2).bin" BINARY
+//	  TRACK 02 AUDIO
+//	    INDEX 00 00:00:00
+//	    INDEX 01 00:02:00
+//
+// INDEX positions are from the start of their file. INDEX 00 is the
+// pregap, stored in the file. A PREGAP line instead is a pregap that
+// isn't in the file and reads as silence
+
+// A track as it's written in the CUE sheet
+type cueSheetTrack struct {
+	number  uint8     // track number
+	ttype   TrackType // track type
+	size    int64     // bytes per sector in the file
+	index0  int64     // INDEX 00 sectors from the start of the file, -1 if there isn't one
+	index1  int64     // INDEX 01 sectors from the start of the file, -1 until it's found
+	pregap  int64     // PREGAP sectors
+	line    int       // line of the TRACK, for errors
+	fileIdx int       // which FILE it's in
+}
+
+// Where a track's sectors are
+type cueTrackSource struct {
+	file       *os.File // the BIN file
+	sectorSize int64    // bytes per sector in the file, 2048 ones get the rest built
+	fileSector int64    // sector in the file the track's index 01 is at
+	filePregap uint32   // sectors of the pregap that are in the file
+}
+
+// A BIN/CUE disc image
+type CueDisc struct {
+	files   []*os.File       // the BIN files
+	tracks  []Track          // the tracks
+	sources []cueTrackSource // where the sectors of each track are
+	leadOut uint32           // LBA of the lead out
+}
+
+// OpenCue open a BIN/CUE image from its CUE sheet
+func OpenCue(path string) (*CueDisc, error) {
+	sheet, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open cue sheet: %v", err)
+	}
+	defer sheet.Close()
+
+	var fileNames []string
+	var sheetTracks []cueSheetTrack
+	scanner := bufio.NewScanner(sheet)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := splitCueLine(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		lineErr := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", filepath.Base(path), lineNum, fmt.Sprintf(format, args...))
+		}
+
+		var cur *cueSheetTrack
+		if len(sheetTracks) > 0 {
+			cur = &sheetTracks[len(sheetTracks)-1]
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			if len(fields) != 3 {
+				return nil, lineErr("expected FILE \"name\" type")
+			}
+
+			if strings.ToUpper(fields[2]) != "BINARY" {
+				return nil, lineErr("unsupported file type %s", fields[2])
+			}
+
+			fileNames = append(fileNames, fields[1])
+
+		case "TRACK":
+			if len(fields) != 3 {
+				return nil, lineErr("expected TRACK number mode")
+			}
+
+			if len(fileNames) == 0 {
+				return nil, lineErr("TRACK before any FILE")
+			}
+
+			var number uint8
+			if _, err := fmt.Sscanf(fields[1], "%d", &number); err != nil || number == 0 || number > 99 {
+				return nil, lineErr("bad track number %q", fields[1])
+			}
+
+			if int(number) != len(sheetTracks)+1 {
+				return nil, lineErr("track %d out of order", number)
+			}
+
+			ttype, size, err := parseCueTrackMode(fields[2])
+			if err != nil {
+				return nil, lineErr("%v", err)
+			}
+
+			sheetTracks = append(sheetTracks, cueSheetTrack{
+				number:  number,
+				ttype:   ttype,
+				size:    size,
+				index0:  -1,
+				index1:  -1,
+				line:    lineNum,
+				fileIdx: len(fileNames) - 1,
+			})
+
+		case "INDEX":
+			if len(fields) != 3 {
+				return nil, lineErr("expected INDEX number mm:ss:ff")
+			}
+
+			if cur == nil {
+				return nil, lineErr("INDEX before any TRACK")
+			}
+
+			pos, err := parseCueMSF(fields[2])
+			if err != nil {
+				return nil, lineErr("%v", err)
+			}
+
+			// only 00 and 01 matter, the rest are just markers
+			switch fields[1] {
+			case "00", "0":
+				cur.index0 = pos
+			case "01", "1":
+				cur.index1 = pos
+			}
+
+		case "PREGAP":
+			if len(fields) != 2 || cur == nil {
+				return nil, lineErr("expected PREGAP mm:ss:ff after a TRACK")
+			}
+
+			cur.pregap, err = parseCueMSF(fields[1])
+			if err != nil {
+				return nil, lineErr("%v", err)
+			}
+
+		case "REM", "TITLE", "PERFORMER", "SONGWRITER", "CATALOG", "CDTEXTFILE", "FLAGS", "ISRC", "POSTGAP":
+			// nothing we need
+
+		default:
+			log.Warnf("%s:%d: ignoring unknown command %s", filepath.Base(path), lineNum, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read cue sheet: %v", err)
+	}
+
+	if len(sheetTracks) == 0 {
+		return nil, fmt.Errorf("%s: no tracks", filepath.Base(path))
+	}
+
+	disc := &CueDisc{}
+	for _, name := range fileNames {
+		file, err := os.Open(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			disc.Close()
+			return nil, fmt.Errorf("Failed to open bin file: %v", err)
+		}
+
+		disc.files = append(disc.files, file)
+	}
+
+	if err := disc.layout(path, sheetTracks); err != nil {
+		disc.Close()
+		return nil, err
+	}
+
+	return disc, nil
+}
+
+// layout work out where every track is on the disc
+func (d *CueDisc) layout(path string, sheetTracks []cueSheetTrack) error {
+	// positions here are in sectors from 00:00:00. Track 1 always
+	// starts at 00:02:00 whatever the sheet says its pregap is
+	fileStart := PREGAP_SECTORS - sheetTracks[0].index1
+	gaps := int64(0) // PREGAP sectors so far, they're not in any file
+
+	for i, st := range sheetTracks {
+		if st.index1 < 0 {
+			return fmt.Errorf("%s:%d: track %d has no INDEX 01", filepath.Base(path), st.line, st.number)
+		}
+
+		if st.index0 > st.index1 {
+			return fmt.Errorf("%s:%d: track %d INDEX 00 is after INDEX 01", filepath.Base(path), st.line, st.number)
+		}
+
+		if i > 0 && st.fileIdx != sheetTracks[i-1].fileIdx {
+			// the last file ended, this one carries on after it
+			prev := sheetTracks[i-1]
+			fileStart += d.fileSectors(prev.fileIdx, prev.size)
+		}
+
+		if i > 0 {
+			gaps += st.pregap
+		}
+
+		filePregap := int64(0)
+		if st.index0 >= 0 {
+			filePregap = st.index1 - st.index0
+		}
+
+		// the track runs until the next one's pregap or the end of the file
+		end := d.fileSectors(st.fileIdx, st.size)
+		if i+1 < len(sheetTracks) && sheetTracks[i+1].fileIdx == st.fileIdx {
+			next := sheetTracks[i+1]
+			end = next.index1
+			if next.index0 >= 0 {
+				end = next.index0
+			}
+		}
+
+		if end <= st.index1 {
+			return fmt.Errorf("%s:%d: track %d is empty or past the end of its file", filepath.Base(path), st.line, st.number)
+		}
+
+		start := fileStart + gaps + st.index1 - PREGAP_SECTORS
+		pregap := filePregap
+		if i > 0 {
+			pregap += st.pregap
+		}
+
+		d.tracks = append(d.tracks, Track{
+			Number: st.number,
+			Type:   st.ttype,
+			Start:  uint32(start),
+			Pregap: uint32(pregap),
+			Length: uint32(end - st.index1),
+		})
+
+		d.sources = append(d.sources, cueTrackSource{
+			file:       d.files[st.fileIdx],
+			sectorSize: st.size,
+			fileSector: st.index1,
+			filePregap: uint32(filePregap),
+		})
+	}
+
+	d.leadOut = d.tracks[len(d.tracks)-1].End()
+
+	return nil
+}
+
+// fileSectors number of whole sectors of sectorSize in a BIN file
+func (d *CueDisc) fileSectors(fileIdx int, sectorSize int64) int64 {
+	info, err := d.files[fileIdx].Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size() / sectorSize
+}
+
+// Tracks return the tracks in order
+func (d *CueDisc) Tracks() []Track {
+	return d.tracks
+}
+
+// LeadOut return the LBA the lead out starts at
+func (d *CueDisc) LeadOut() uint32 {
+	return d.leadOut
+}
+
+// ReadSector read the raw sector at lba into buf. Pregaps that aren't
+// in the BIN files read as zeroes
+func (d *CueDisc) ReadSector(lba uint32, buf []byte) error {
+	for i := range d.tracks {
+		t := &d.tracks[i]
+		if lba+t.Pregap < t.Start || lba >= t.End() {
+			continue
+		}
+
+		src := &d.sources[i]
+		offset := int64(lba) - int64(t.Start)
+		if offset < -int64(src.filePregap) {
+			clear(buf[:SECTOR_SIZE])
+			return nil
+		}
+
+		return readImageSector(src.file, src.fileSector+offset, src.sectorSize, t.Type, lba, buf)
+	}
+
+	return fmt.Errorf("Sector %d isn't on the disc", lba)
+}
+
+// Close close the BIN files
+func (d *CueDisc) Close() error {
+	var firstErr error
+	for _, file := range d.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	d.files = nil
+	return firstErr
+}
+
+// parseCueTrackMode parse the mode of a TRACK line into the track type
+// and the size of its sectors in the file
+func parseCueTrackMode(mode string) (TrackType, int64, error) {
+	switch strings.ToUpper(mode) {
+	case "MODE1/2352":
+		return TrackMode1, SECTOR_SIZE, nil
+	case "MODE2/2352":
+		return TrackMode2, SECTOR_SIZE, nil
+	case "MODE1/2048":
+		return TrackMode1, DATA_SIZE, nil
+	case "MODE2/2048":
+		return TrackMode2, DATA_SIZE, nil
+	case "AUDIO":
+		return TrackAudio, SECTOR_SIZE, nil
+	default:
+		return TrackMode1, 0, fmt.Errorf("unsupported track mode %s", mode)
+	}
+}
+
+// parseCueMSF parse an mm:ss:ff position into a number of sectors
+func parseCueMSF(s string) (int64, error) {
+	var m, sec, f int64
+	if n, err := fmt.Sscanf(s, "%d:%d:%d", &m, &sec, &f); err != nil || n != 3 || sec >= 60 || f >= SECTORS_PER_SECOND {
+		return 0, fmt.Errorf("bad position %q", s)
+	}
+
+	return (m*60+sec)*SECTORS_PER_SECOND + f, nil
+}
+
+// splitCueLine split a CUE sheet line into fields, quoted strings are
+// one field without the quotes
+func splitCueLine(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+
+	for line != "" {
+		var field string
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				field, line = line[1:], ""
+			} else {
+				field, line = line[1:end+1], line[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				field, line = line, ""
+			} else {
+				field, line = line[:end], line[end:]
+			}
+		}
+
+		fields = append(fields, field)
+		line = strings.TrimLeft(line, " \t")
+	}
+
+	return fields
+}
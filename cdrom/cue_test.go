@@ -0,0 +1,111 @@
+package cdrom
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Tests for laying out the tracks of a CUE sheet, the BIN files are
+// empty sparse files of the right size
+
+// writeCue write sheet and the BIN files it uses to a temporary
+// directory and open it. files is the size of each BIN in bytes
+func writeCue(t *testing.T, sheet string, files map[string]int64) *CueDisc {
+	t.Helper()
+	dir := t.TempDir()
+
+	for name, size := range files {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if err := file.Truncate(size); err != nil {
+			t.Fatalf("Failed to size %s: %v", name, err)
+		}
+		file.Close()
+	}
+
+	path := filepath.Join(dir, "game.cue")
+	if err := os.WriteFile(path, []byte(sheet), 0o644); err != nil {
+		t.Fatalf("Failed to write cue sheet: %v", err)
+	}
+
+	disc, err := OpenCue(path)
+	if err != nil {
+		t.Fatalf("OpenCue: %v", err)
+	}
+	t.Cleanup(func() { disc.Close() })
+
+	return disc
+}
+
+// checkTracks compare the tracks and lead out of disc
+func checkTracks(t *testing.T, disc *CueDisc, want []Track, leadOut uint32) {
+	t.Helper()
+
+	if got := disc.Tracks(); !slices.Equal(got, want) {
+		t.Errorf("tracks\n got %+v\nwant %+v", got, want)
+	}
+
+	if got := disc.LeadOut(); got != leadOut {
+		t.Errorf("lead out = %d, want %d", got, leadOut)
+	}
+}
+
+func TestCueSharedFile(t *testing.T) {
+	// an audio track with its two second pregap stored in the same file
+	// as the data track before it
+	disc := writeCue(t, `FILE "game.bin" BINARY
+  TRACK 01 MODE2/2352
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:10:00
+    INDEX 01 00:12:00
+`, map[string]int64{"game.bin": 1000 * SECTOR_SIZE})
+
+	checkTracks(t, disc, []Track{
+		{Number: 1, Type: TrackMode2, Start: 0, Pregap: 0, Length: 750},
+		{Number: 2, Type: TrackAudio, Start: 900, Pregap: 150, Length: 100},
+	}, 1000)
+}
+
+func TestCueMultipleFiles(t *testing.T) {
+	// a 2048 byte data track, an audio track with a PREGAP that isn't in
+	// its file and one with an INDEX 00 that is
+	disc := writeCue(t, `FILE "track1.bin" BINARY
+  TRACK 01 MODE1/2048
+    INDEX 01 00:00:00
+FILE "track2.bin" BINARY
+  TRACK 02 AUDIO
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+FILE "track3.bin" BINARY
+  TRACK 03 AUDIO
+    INDEX 00 00:00:00
+    INDEX 01 00:01:00
+`, map[string]int64{
+		"track1.bin": 300 * DATA_SIZE,
+		"track2.bin": 200 * SECTOR_SIZE,
+		"track3.bin": 100 * SECTOR_SIZE,
+	})
+
+	checkTracks(t, disc, []Track{
+		{Number: 1, Type: TrackMode1, Start: 0, Pregap: 0, Length: 300},
+		{Number: 2, Type: TrackAudio, Start: 450, Pregap: 150, Length: 200},
+		{Number: 3, Type: TrackAudio, Start: 725, Pregap: 75, Length: 25},
+	}, 750)
+
+	// both kinds of pregap can be read, the lead out can't
+	buf := make([]byte, SECTOR_SIZE)
+	for _, lba := range []uint32{300, 449, 650, 724} {
+		if err := disc.ReadSector(lba, buf); err != nil {
+			t.Errorf("ReadSector(%d): %v", lba, err)
+		}
+	}
+
+	if err := disc.ReadSector(750, buf); err == nil {
+		t.Errorf("ReadSector of the lead out didn't fail")
+	}
+}
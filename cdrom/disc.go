@@ -0,0 +1,81 @@
+package cdrom
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Disc images. Whatever the format, a disc is a list of tracks and
+// raw 2352 byte sectors read by LBA (LBA 0 being 00:02:00). Images
+// don't get loaded into memory, sectors are read as they're needed
+
+// A track on a disc
+type Track struct {
+	Number uint8     // track number, starting at 1
+	Type   TrackType // what kind of sectors it has
+	Start  uint32    // LBA of index 01, where the track really starts
+	Pregap uint32    // sectors of index 00 before Start
+	Length uint32    // sectors from Start to the next track's pregap or the lead out
+}
+
+// End LBA just after the last sector of the track
+func (t *Track) End() uint32 {
+	return t.Start + t.Length
+}
+
+// position return the index lba is in and the position relative to the
+// track. In the pregap it's index 00 and counts down to 0
+func (t *Track) position(lba uint32) (index uint8, relative MSF) {
+	if lba < t.Start {
+		return 0x00, MSFFromSectors(t.Start - lba)
+	}
+
+	return 0x01, MSFFromSectors(lba - t.Start)
+}
+
+// A disc image
+type Disc interface {
+	// Tracks return the tracks in order
+	Tracks() []Track
+
+	// LeadOut return the LBA the lead out starts at, the end of the
+	// last track
+	LeadOut() uint32
+
+	// ReadSector read the raw 2352 byte sector at lba into buf
+	ReadSector(lba uint32, buf []byte) error
+
+	// Close close the image's files
+	Close() error
+}
+
+// OpenDisc open a disc image, the format is picked by the extension
+func OpenDisc(path string) (Disc, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".cue":
+		return OpenCue(path)
+	case ".iso":
+		return OpenISO(path)
+	case ".bin", ".img":
+		return OpenBin(path)
+	case ".chd":
+		return OpenChd(path)
+	default:
+		return nil, fmt.Errorf("Unknown disc image format %q", filepath.Ext(path))
+	}
+}
+
+// TrackAt return the track lba is in, pregap included. ok is false if
+// it's outside of every track
+func TrackAt(d Disc, lba uint32) (track *Track, ok bool) {
+	tracks := d.Tracks()
+	for i := range tracks {
+		t := &tracks[i]
+		if lba+t.Pregap >= t.Start && lba < t.End() {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
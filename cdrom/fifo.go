@@ -0,0 +1,65 @@
+package cdrom
+
+const FIFO_LEN = 16 // bytes the parameter and response FIFOs hold
+
+// The parameter and response FIFOs
+type ByteFIFO struct {
+	buffer [FIFO_LEN]uint8 // the bytes
+	read   uint8           // index of the next byte to pop
+	length uint8           // number of bytes in the FIFO
+}
+
+// clear empty the FIFO
+func (f *ByteFIFO) clear() {
+	f.read = 0
+	f.length = 0
+}
+
+// empty whether the FIFO is empty
+func (f *ByteFIFO) empty() bool {
+	return f.length == 0
+}
+
+// full whether the FIFO is full
+func (f *ByteFIFO) full() bool {
+	return f.length == FIFO_LEN
+}
+
+// len number of bytes in the FIFO
+func (f *ByteFIFO) len() int {
+	return int(f.length)
+}
+
+// push push val to the back of the FIFO, it gets dropped if the FIFO
+// is full like the hardware does
+func (f *ByteFIFO) push(val uint8) {
+	if f.full() {
+		return
+	}
+
+	f.buffer[(f.read+f.length)%FIFO_LEN] = val
+	f.length += 1
+}
+
+// pop pop the byte at the front of the FIFO, 0 if it's empty
+func (f *ByteFIFO) pop() uint8 {
+	if f.empty() {
+		return 0
+	}
+
+	val := f.buffer[f.read]
+	f.read = (f.read + 1) % FIFO_LEN
+	f.length -= 1
+
+	return val
+}
+
+// drain pop everything in the FIFO
+func (f *ByteFIFO) drain() []uint8 {
+	vals := make([]uint8, 0, f.length)
+	for !f.empty() {
+		vals = append(vals, f.pop())
+	}
+
+	return vals
+}
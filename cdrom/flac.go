@@ -0,0 +1,330 @@
+package cdrom
+
+import "fmt"
+
+// A FLAC frame decoder for the audio in CHDs. CHD stores bare FLAC
+// frames without the stream header, always 16 bit stereo at 44.1 kHz,
+// so that's all that's handled here. Each frame is a header, a
+// subframe per channel and a CRC16. A subframe is a constant, raw
+// samples, or a fixed or LPC predictor plus Rice coded residuals
+
+const (
+	FLAC_SYNC         = 0x3ffe // the first 14 bits of a frame
+	FLAC_CHANNELS     = 2      // stereo
+	FLAC_SAMPLE_BYTES = 2      // 16 bit samples
+)
+
+// Channel assignments past the independent ones
+const (
+	FLAC_LEFT_SIDE  = 8
+	FLAC_SIDE_RIGHT = 9
+	FLAC_MID_SIDE   = 10
+)
+
+// decodeFlac decode frames from src into dst as interleaved 16 bit
+// stereo samples until it's full. Returns how many bytes of src the
+// frames took up
+func decodeFlac(src []byte, dst []byte, bigEndian bool) (int, error) {
+	bits := &bitReader{data: src}
+	out := 0
+
+	for out < len(dst) {
+		channels, err := decodeFlacFrame(bits)
+		if err != nil {
+			return 0, err
+		}
+
+		for i := range channels[0] {
+			for _, channel := range channels {
+				if out+FLAC_SAMPLE_BYTES > len(dst) {
+					break
+				}
+
+				sample := uint16(int16(channel[i]))
+				if bigEndian {
+					dst[out], dst[out+1] = uint8(sample>>8), uint8(sample)
+				} else {
+					dst[out], dst[out+1] = uint8(sample), uint8(sample>>8)
+				}
+				out += FLAC_SAMPLE_BYTES
+			}
+		}
+	}
+
+	return bits.offset(), nil
+}
+
+// decodeFlacFrame decode one frame into samples for each channel
+func decodeFlacFrame(bits *bitReader) ([][]int32, error) {
+	if bits.read(14) != FLAC_SYNC {
+		return nil, fmt.Errorf("FLAC frame sync not found")
+	}
+	bits.read(2) // reserved, blocking strategy
+
+	blockSizeCode := bits.read(4)
+	sampleRateCode := bits.read(4)
+	assignment := bits.read(4)
+	sampleSizeCode := bits.read(3)
+	bits.read(1) // reserved
+
+	// frame or sample number, UTF-8 style
+	first := bits.read(8)
+	for mask := uint32(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+		bits.read(8)
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		blockSize = int(bits.read(8)) + 1
+	case blockSizeCode == 7:
+		blockSize = int(bits.read(16)) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return nil, fmt.Errorf("Bad FLAC block size code %d", blockSizeCode)
+	}
+
+	switch sampleRateCode {
+	case 12:
+		bits.read(8)
+	case 13, 14:
+		bits.read(16)
+	}
+
+	var sampleBits uint
+	switch sampleSizeCode {
+	case 0, 4:
+		sampleBits = 16
+	case 1:
+		sampleBits = 8
+	case 2:
+		sampleBits = 12
+	case 5:
+		sampleBits = 20
+	case 6:
+		sampleBits = 24
+	default:
+		return nil, fmt.Errorf("Bad FLAC sample size code %d", sampleSizeCode)
+	}
+
+	channelCount := int(assignment) + 1
+	if assignment >= FLAC_LEFT_SIDE {
+		channelCount = 2
+	}
+
+	if channelCount != FLAC_CHANNELS || assignment > FLAC_MID_SIDE {
+		return nil, fmt.Errorf("Unsupported FLAC channel assignment %d", assignment)
+	}
+
+	bits.read(8) // header CRC8
+
+	channels := make([][]int32, channelCount)
+	for ch := range channels {
+		// the side channel has one more bit
+		chBits := sampleBits
+		if (assignment == FLAC_LEFT_SIDE || assignment == FLAC_MID_SIDE) && ch == 1 ||
+			assignment == FLAC_SIDE_RIGHT && ch == 0 {
+			chBits++
+		}
+
+		samples, err := decodeFlacSubframe(bits, blockSize, chBits)
+		if err != nil {
+			return nil, err
+		}
+		channels[ch] = samples
+	}
+
+	bits.align()
+	bits.read(16) // frame CRC16
+
+	if bits.overrun {
+		return nil, fmt.Errorf("FLAC frame is cut short")
+	}
+
+	left, right := channels[0], channels[1]
+	switch assignment {
+	case FLAC_LEFT_SIDE:
+		for i := range right {
+			right[i] = left[i] - right[i]
+		}
+	case FLAC_SIDE_RIGHT:
+		for i := range left {
+			left[i] += right[i]
+		}
+	case FLAC_MID_SIDE:
+		for i := range left {
+			side := right[i]
+			mid := left[i]<<1 | side&1
+			left[i] = (mid + side) >> 1
+			right[i] = (mid - side) >> 1
+		}
+	}
+
+	return channels, nil
+}
+
+// decodeFlacSubframe decode one channel of a frame
+func decodeFlacSubframe(bits *bitReader, blockSize int, sampleBits uint) ([]int32, error) {
+	bits.read(1) // padding
+	kind := bits.read(6)
+
+	// low bits that are zero in every sample aren't stored
+	wasted := uint(0)
+	if bits.read(1) != 0 {
+		wasted = uint(bits.readUnary()) + 1
+		if wasted >= sampleBits {
+			return nil, fmt.Errorf("Bad FLAC wasted bits %d", wasted)
+		}
+		sampleBits -= wasted
+	}
+
+	samples := make([]int32, blockSize)
+
+	switch {
+	case kind == 0:
+		val := bits.readSigned(sampleBits)
+		for i := range samples {
+			samples[i] = val
+		}
+
+	case kind == 1:
+		for i := range samples {
+			samples[i] = bits.readSigned(sampleBits)
+		}
+
+	case kind >= 8 && kind <= 12:
+		order := int(kind - 8)
+		if order > blockSize {
+			return nil, fmt.Errorf("FLAC predictor order %d is more than the block size", order)
+		}
+
+		for i := range order {
+			samples[i] = bits.readSigned(sampleBits)
+		}
+
+		if err := decodeFlacResidual(bits, samples, order); err != nil {
+			return nil, err
+		}
+
+		for i := order; i < blockSize; i++ {
+			switch order {
+			case 1:
+				samples[i] += samples[i-1]
+			case 2:
+				samples[i] += 2*samples[i-1] - samples[i-2]
+			case 3:
+				samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+			case 4:
+				samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+			}
+		}
+
+	case kind >= 32:
+		order := int(kind-32) + 1
+		if order > blockSize {
+			return nil, fmt.Errorf("FLAC predictor order %d is more than the block size", order)
+		}
+
+		for i := range order {
+			samples[i] = bits.readSigned(sampleBits)
+		}
+
+		precision := uint(bits.read(4)) + 1
+		if precision == 16 {
+			return nil, fmt.Errorf("Bad FLAC coefficient precision")
+		}
+
+		shift := bits.readSigned(5)
+		if shift < 0 {
+			return nil, fmt.Errorf("Negative FLAC LPC shift")
+		}
+
+		coefs := make([]int64, order)
+		for i := range coefs {
+			coefs[i] = int64(bits.readSigned(precision))
+		}
+
+		if err := decodeFlacResidual(bits, samples, order); err != nil {
+			return nil, err
+		}
+
+		for i := order; i < blockSize; i++ {
+			var sum int64
+			for j, coef := range coefs {
+				sum += coef * int64(samples[i-j-1])
+			}
+			samples[i] += int32(sum >> shift)
+		}
+
+	default:
+		return nil, fmt.Errorf("Bad FLAC subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+
+	return samples, nil
+}
+
+// decodeFlacResidual read the Rice coded residuals into samples after
+// the warm up ones
+func decodeFlacResidual(bits *bitReader, samples []int32, order int) error {
+	var paramBits uint
+	var escape uint32
+	switch method := bits.read(2); method {
+	case 0:
+		paramBits, escape = 4, 0xf
+	case 1:
+		paramBits, escape = 5, 0x1f
+	default:
+		return fmt.Errorf("Bad FLAC residual coding method %d", method)
+	}
+
+	partitionOrder := bits.read(4)
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return fmt.Errorf("Bad FLAC partition order %d", partitionOrder)
+	}
+
+	i := order
+	for p := range partitions {
+		count := partitionSize
+		if p == 0 {
+			count -= order
+		}
+
+		param := bits.read(paramBits)
+		if param == escape {
+			// not Rice coded, just raw numbers this size
+			size := uint(bits.read(5))
+			for range count {
+				samples[i] = bits.readSigned(size)
+				i++
+			}
+			continue
+		}
+
+		for range count {
+			high := bits.readUnary()
+			val := high<<param | bits.read(uint(param))
+			samples[i] = int32(val>>1) ^ -int32(val&1)
+			i++
+		}
+
+		if bits.overrun {
+			return fmt.Errorf("FLAC residual is cut short")
+		}
+	}
+
+	return nil
+}
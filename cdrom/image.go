@@ -0,0 +1,129 @@
+package cdrom
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Single track images without a CUE sheet. A .iso only has the 2048
+// bytes of user data of every sector, the rest of the raw sector gets
+// built when it's read. A .bin has whole 2352 byte sectors
+
+var syncPattern = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+// A disc image that's one data track in one file
+type ImageDisc struct {
+	file       *os.File // the image
+	sectorSize int64    // DATA_SIZE or SECTOR_SIZE
+	track      Track    // the only track
+}
+
+// OpenISO open a .iso image of 2048 byte sectors. It's taken as mode 2
+// form 1 like a PSX disc
+func OpenISO(path string) (*ImageDisc, error) {
+	return openImage(path, DATA_SIZE, TrackMode2)
+}
+
+// OpenBin open a .bin image of raw sectors without a CUE sheet. The
+// track type comes from the first sector's header
+func OpenBin(path string) (*ImageDisc, error) {
+	disc, err := openImage(path, SECTOR_SIZE, TrackMode2)
+	if err != nil {
+		return nil, err
+	}
+
+	var sector [SECTOR_SIZE]byte
+	if err := disc.ReadSector(0, sector[:]); err != nil {
+		disc.Close()
+		return nil, err
+	}
+
+	if !bytes.Equal(sector[:len(syncPattern)], syncPattern) {
+		disc.track.Type = TrackAudio
+	} else if sector[HEADER_OFFSET+3] == 1 {
+		disc.track.Type = TrackMode1
+	}
+
+	return disc, nil
+}
+
+// openImage open a single track image
+func openImage(path string, sectorSize int64, ttype TrackType) (*ImageDisc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open disc image: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to open disc image: %v", err)
+	}
+
+	if info.Size()%sectorSize != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s isn't a whole number of %d byte sectors", path, sectorSize)
+	}
+
+	sectors := info.Size() / sectorSize
+	if sectors == 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+
+	return &ImageDisc{
+		file:       file,
+		sectorSize: sectorSize,
+		track:      Track{Number: 1, Type: ttype, Start: 0, Length: uint32(sectors)},
+	}, nil
+}
+
+// Tracks return the one track
+func (d *ImageDisc) Tracks() []Track {
+	return []Track{d.track}
+}
+
+// LeadOut return the LBA the lead out starts at
+func (d *ImageDisc) LeadOut() uint32 {
+	return d.track.End()
+}
+
+// ReadSector read the raw sector at lba into buf, building the parts
+// the image doesn't have
+func (d *ImageDisc) ReadSector(lba uint32, buf []byte) error {
+	if lba >= d.track.End() {
+		return fmt.Errorf("Sector %d isn't on the disc", lba)
+	}
+
+	return readImageSector(d.file, int64(lba), d.sectorSize, d.track.Type, lba, buf)
+}
+
+// Close close the image
+func (d *ImageDisc) Close() error {
+	return d.file.Close()
+}
+
+// readImageSector read sector index from a file of sectorSize sectors
+// as the raw sector at lba
+func readImageSector(file *os.File, index, sectorSize int64, ttype TrackType, lba uint32, buf []byte) error {
+	if sectorSize == SECTOR_SIZE {
+		if _, err := file.ReadAt(buf[:SECTOR_SIZE], index*SECTOR_SIZE); err != nil {
+			return fmt.Errorf("Failed to read sector %d: %v", lba, err)
+		}
+
+		return nil
+	}
+
+	offset := MODE2_DATA
+	if ttype == TrackMode1 {
+		offset = MODE1_DATA
+	}
+
+	if _, err := file.ReadAt(buf[offset:offset+DATA_SIZE], index*DATA_SIZE); err != nil {
+		return fmt.Errorf("Failed to read sector %d: %v", lba, err)
+	}
+
+	buildSector(buf, lba, ttype)
+	return nil
+}
@@ -0,0 +1,367 @@
+package cdrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The ISO9660 filesystem on the first data track, for tooling outside
+// the emulated drive: listing and extracting files, and finding the
+// boot executable and serial in SYSTEM.CNF. The primary volume
+// descriptor is at LBA 16 and points at the root directory, and a
+// directory is a run of records that never cross a sector
+//
+//	0x00  record length, 0 pads out the rest of the sector
+//	0x02  LBA of the extent, little endian (big endian at 0x06)
+//	0x0a  size in bytes, little endian (big endian at 0x0e)
+//	0x19  flags, bit 1 is a directory
+//	0x20  name length, the name follows
+//
+// Names are upper case with a ;1 version on the end, paths here can use
+// / or \ and any case
+
+const (
+	ISO_PVD_LBA       = 16     // where the primary volume descriptor is
+	ISO_ROOT_RECORD   = 0x9c   // offset of the root directory record in the PVD
+	ISO_RECORD_MIN    = 0x21   // size of a directory record with no name
+	ISO_FLAG_DIR      = 1 << 1 // directory record flag for a directory
+	ISO_SYSTEM_CNF    = "SYSTEM.CNF"
+	ISO_DEFAULT_BOOT  = "PSX.EXE" // what the BIOS boots without a SYSTEM.CNF
+	ISO_MAX_DIR_DEPTH = 64        // give up walking past this, the disc is probably broken
+)
+
+// isoMagic the standard identifier of a volume descriptor
+var isoMagic = []byte("CD001")
+
+// ErrNotFound what Stat and everything using it return, wrapped, when
+// a path isn't on the disc
+var ErrNotFound = errors.New("not found on the disc")
+
+// serialPattern the executable name most discs boot, like SLUS_007.54
+var serialPattern = regexp.MustCompile(`^([A-Z]{4})[_-]?([0-9]{3})\.?([0-9]{2})$`)
+
+// A file or directory on the disc
+type FileInfo struct {
+	Name string // name without the version
+	LBA  uint32 // first sector
+	Size uint32 // size in bytes
+	Dir  bool   // it's a directory
+}
+
+// What the BIOS boots
+type BootInfo struct {
+	Exe    string // path of the executable on the disc
+	Serial string // game serial like SLUS-00754, empty if the name doesn't look like one
+}
+
+// The ISO9660 filesystem of a disc
+type Filesystem struct {
+	disc   Disc
+	root   FileInfo
+	sector [SECTOR_SIZE]uint8
+}
+
+// OpenFilesystem read the primary volume descriptor of d
+func OpenFilesystem(d Disc) (*Filesystem, error) {
+	fs := &Filesystem{disc: d}
+
+	pvd, err := fs.readData(ISO_PVD_LBA)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the volume descriptor: %v", err)
+	}
+
+	if pvd[0] != 1 || !bytes.Equal(pvd[1:6], isoMagic) {
+		return nil, fmt.Errorf("No ISO9660 filesystem on the disc")
+	}
+
+	root, _, ok := parseDirRecord(pvd[ISO_ROOT_RECORD:])
+	if !ok || !root.Dir {
+		return nil, fmt.Errorf("Bad root directory record")
+	}
+	root.Name = ""
+	fs.root = root
+
+	return fs, nil
+}
+
+// readData read the 2048 bytes of user data of the sector at lba
+//
+// NOTE - form 2 sectors have 2324 bytes but only the first 2048 come
+// back, the same as reading them through the filesystem on a real
+// drive. It's the streamed XA and video files that have them
+func (fs *Filesystem) readData(lba uint32) ([]byte, error) {
+	if err := fs.disc.ReadSector(lba, fs.sector[:]); err != nil {
+		return nil, err
+	}
+
+	switch fs.sector[HEADER_OFFSET+3] {
+	case 1:
+		return fs.sector[MODE1_DATA : MODE1_DATA+DATA_SIZE], nil
+	case 2:
+		return fs.sector[MODE2_DATA : MODE2_DATA+DATA_SIZE], nil
+	default:
+		return nil, fmt.Errorf("Sector %s isn't a data sector", MSFFromLBA(lba))
+	}
+}
+
+// parseDirRecord parse the directory record at the start of buf,
+// returning it and its length. ok is false at the padding at the end of
+// a sector
+func parseDirRecord(buf []byte) (info FileInfo, length int, ok bool) {
+	if len(buf) < ISO_RECORD_MIN || buf[0] < ISO_RECORD_MIN {
+		return FileInfo{}, 0, false
+	}
+
+	length = int(buf[0])
+	nameLen := int(buf[0x20])
+	if length > len(buf) || ISO_RECORD_MIN+nameLen > length {
+		return FileInfo{}, 0, false
+	}
+
+	name := string(buf[ISO_RECORD_MIN : ISO_RECORD_MIN+nameLen])
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+
+	info = FileInfo{
+		Name: strings.TrimSuffix(name, "."), // files without an extension keep the dot
+		LBA:  binary.LittleEndian.Uint32(buf[0x02:]),
+		Size: binary.LittleEndian.Uint32(buf[0x0a:]),
+		Dir:  buf[0x19]&ISO_FLAG_DIR != 0,
+	}
+
+	return info, length, true
+}
+
+// readDir read the entries of the directory dir, without . and ..
+func (fs *Filesystem) readDir(dir FileInfo) ([]FileInfo, error) {
+	var entries []FileInfo
+
+	sectors := (dir.Size + DATA_SIZE - 1) / DATA_SIZE
+	for i := range sectors {
+		data, err := fs.readData(dir.LBA + i)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read directory %q: %v", dir.Name, err)
+		}
+
+		for pos := 0; pos < DATA_SIZE; {
+			info, length, ok := parseDirRecord(data[pos:])
+			if !ok {
+				break // on to the next sector
+			}
+			pos += length
+
+			// . and .. are named 0x00 and 0x01, names that can't be a
+			// path on their own come from broken or nasty discs
+			if info.Name == "\x01" || !safeName(info.Name) {
+				continue
+			}
+			entries = append(entries, info)
+		}
+	}
+
+	return entries, nil
+}
+
+// safeName whether name is fine as one part of a path, not empty, . or
+// .. and without any separators
+func safeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// splitPath split a path into its names, / and \ both work
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+}
+
+// Stat find the file or directory at path, "" or "/" is the root
+func (fs *Filesystem) Stat(path string) (FileInfo, error) {
+	current := fs.root
+
+	for _, name := range splitPath(path) {
+		if !current.Dir {
+			return FileInfo{}, fmt.Errorf("%q is not a directory", current.Name)
+		}
+
+		entries, err := fs.readDir(current)
+		if err != nil {
+			return FileInfo{}, err
+		}
+
+		found := false
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name, name) {
+				current, found = entry, true
+				break
+			}
+		}
+
+		if !found {
+			return FileInfo{}, fmt.Errorf("%q %w", path, ErrNotFound)
+		}
+	}
+
+	return current, nil
+}
+
+// ReadDir list the directory at path
+func (fs *Filesystem) ReadDir(path string) ([]FileInfo, error) {
+	dir, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.Dir {
+		return nil, fmt.Errorf("%q is not a directory", path)
+	}
+
+	return fs.readDir(dir)
+}
+
+// Walk call fn for every file and directory under path with its path
+// from there, directories before what's in them
+func (fs *Filesystem) Walk(path string, fn func(path string, info FileInfo) error) error {
+	dir, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !dir.Dir {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+
+	return fs.walk("", dir, 0, fn)
+}
+
+// walk the recursive part of Walk
+func (fs *Filesystem) walk(prefix string, dir FileInfo, depth int, fn func(string, FileInfo) error) error {
+	if depth > ISO_MAX_DIR_DEPTH {
+		return fmt.Errorf("Directories nested too deep at %q", prefix)
+	}
+
+	entries, err := fs.readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := prefix + entry.Name
+		if err := fn(path, entry); err != nil {
+			return err
+		}
+
+		if entry.Dir {
+			if err := fs.walk(path+"/", entry, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extract write the contents of the file at path to w
+func (fs *Filesystem) Extract(path string, w io.Writer) error {
+	file, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if file.Dir {
+		return fmt.Errorf("%q is a directory", path)
+	}
+
+	for left, lba := file.Size, file.LBA; left > 0; lba++ {
+		data, err := fs.readData(lba)
+		if err != nil {
+			return fmt.Errorf("Failed to read %q: %v", path, err)
+		}
+
+		n := min(left, DATA_SIZE)
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		left -= n
+	}
+
+	return nil
+}
+
+// ReadFile read the whole file at path
+func (fs *Filesystem) ReadFile(path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fs.Extract(path, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Boot find what the BIOS would boot from SYSTEM.CNF's BOOT line, or
+// PSX.EXE if there isn't one. A SYSTEM.CNF that can't be read is an
+// error, the BIOS wouldn't fall back either
+func (fs *Filesystem) Boot() (BootInfo, error) {
+	boot := ISO_DEFAULT_BOOT
+
+	cnf, err := fs.ReadFile(ISO_SYSTEM_CNF)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// no SYSTEM.CNF, PSX.EXE it is
+	case err != nil:
+		return BootInfo{}, fmt.Errorf("Failed to read %s: %v", ISO_SYSTEM_CNF, err)
+	default:
+		var ok bool
+		if boot, ok = parseSystemCnf(string(cnf)); !ok {
+			return BootInfo{}, fmt.Errorf("No BOOT line in %s", ISO_SYSTEM_CNF)
+		}
+	}
+
+	info := BootInfo{Exe: boot}
+
+	parts := splitPath(boot)
+	if len(parts) > 0 {
+		if match := serialPattern.FindStringSubmatch(strings.ToUpper(parts[len(parts)-1])); match != nil {
+			info.Serial = match[1] + "-" + match[2] + match[3]
+		}
+	}
+
+	return info, nil
+}
+
+// parseSystemCnf find the executable path in the BOOT line of a
+// SYSTEM.CNF, lines look like
+//
+//	BOOT = cdrom:\SLUS_007.54;1
+func parseSystemCnf(cnf string) (exe string, ok bool) {
+	for _, line := range strings.Split(cnf, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "BOOT") {
+			continue
+		}
+
+		// some discs have arguments after the path
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return "", false
+		}
+
+		exe = fields[0]
+		if i := strings.IndexByte(exe, ':'); i >= 0 {
+			exe = exe[i+1:] // the cdrom: or cdrom0: device
+		}
+		if i := strings.IndexByte(exe, ';'); i >= 0 {
+			exe = exe[:i]
+		}
+
+		return strings.TrimLeft(exe, `\/`), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,220 @@
+package cdrom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// Tests for the ISO9660 reader, the discs are built in memory a
+// directory record at a time
+
+// memDisc a disc of mode 1 sectors held in memory, sectors that were
+// never written read back as zeroes
+type memDisc struct {
+	data map[uint32][]byte // user data of each sector
+}
+
+func (d *memDisc) Tracks() []Track {
+	return []Track{{Number: 1, Type: TrackMode1, Length: d.LeadOut()}}
+}
+
+func (d *memDisc) LeadOut() uint32 {
+	return 100
+}
+
+func (d *memDisc) ReadSector(lba uint32, buf []byte) error {
+	if lba >= d.LeadOut() {
+		return fmt.Errorf("Sector %d past the end of the disc", lba)
+	}
+
+	clear(buf[:SECTOR_SIZE])
+	putSectorHeader(buf, lba, 1)
+	copy(buf[MODE1_DATA:MODE1_DATA+DATA_SIZE], d.data[lba])
+
+	return nil
+}
+
+func (d *memDisc) Close() error {
+	return nil
+}
+
+// dirRecord build a directory record
+func dirRecord(name string, lba, size uint32, dir bool) []byte {
+	length := ISO_RECORD_MIN + len(name)
+	length += length & 1 // records are padded to an even length
+
+	rec := make([]byte, length)
+	rec[0] = uint8(length)
+	binary.LittleEndian.PutUint32(rec[0x02:], lba)
+	binary.BigEndian.PutUint32(rec[0x06:], lba)
+	binary.LittleEndian.PutUint32(rec[0x0a:], size)
+	binary.BigEndian.PutUint32(rec[0x0e:], size)
+	if dir {
+		rec[0x19] = ISO_FLAG_DIR
+	}
+	rec[0x20] = uint8(len(name))
+	copy(rec[ISO_RECORD_MIN:], name)
+
+	return rec
+}
+
+// newTestFilesystem build a disc with the root directory at LBA 20 made
+// of records, and open its filesystem
+func newTestFilesystem(t *testing.T, files map[uint32][]byte, records ...[]byte) *Filesystem {
+	t.Helper()
+
+	root := slices.Concat(records...)
+	if len(root) > DATA_SIZE {
+		t.Fatalf("Root directory doesn't fit in a sector")
+	}
+
+	pvd := make([]byte, DATA_SIZE)
+	pvd[0] = 1
+	copy(pvd[1:], isoMagic)
+	copy(pvd[ISO_ROOT_RECORD:], dirRecord("\x00", 20, DATA_SIZE, true))
+
+	d := &memDisc{data: map[uint32][]byte{ISO_PVD_LBA: pvd, 20: root}}
+	for lba, data := range files {
+		d.data[lba] = data
+	}
+
+	fs, err := OpenFilesystem(d)
+	if err != nil {
+		t.Fatalf("OpenFilesystem: %v", err)
+	}
+
+	return fs
+}
+
+func TestParseSystemCnf(t *testing.T) {
+	tests := []struct {
+		cnf  string
+		exe  string
+		isOK bool
+	}{
+		{"BOOT = cdrom:\\SLUS_007.54;1 arg\r\nTCB = 4\r\n", "SLUS_007.54", true},
+		{"BOOT=cdrom0:\\X\\Y.EXE;1\n", "X\\Y.EXE", true},
+		{"TCB = 4\nboot = cdrom:SCES_000.01;1\n", "SCES_000.01", true},
+		{"BOOT =\n", "", false},
+		{"TCB = 4\nEVENT = 10\n", "", false},
+	}
+
+	for _, test := range tests {
+		exe, ok := parseSystemCnf(test.cnf)
+		if exe != test.exe || ok != test.isOK {
+			t.Errorf("parseSystemCnf(%q) = %q, %v, want %q, %v", test.cnf, exe, ok, test.exe, test.isOK)
+		}
+	}
+}
+
+func TestSerialPattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		serial string // empty if it shouldn't match
+	}{
+		{"SLUS_007.54", "SLUS-00754"},
+		{"SCES-000.01", "SCES-00001"},
+		{"SLPS_01234", "SLPS-01234"},
+		{"SLUS00754", "SLUS-00754"},
+		{"PSX.EXE", ""},
+		{"SLUS_0075.4", ""},
+		{"XSLUS_007.54", ""},
+		{"SLUS_007.54X", ""},
+	}
+
+	for _, test := range tests {
+		serial := ""
+		if match := serialPattern.FindStringSubmatch(test.name); match != nil {
+			serial = match[1] + "-" + match[2] + match[3]
+		}
+
+		if serial != test.serial {
+			t.Errorf("serial of %q = %q, want %q", test.name, serial, test.serial)
+		}
+	}
+}
+
+func TestParseDirRecord(t *testing.T) {
+	info, length, ok := parseDirRecord(dirRecord("README.TXT;1", 30, 1234, false))
+	want := FileInfo{Name: "README.TXT", LBA: 30, Size: 1234}
+	if !ok || length != 46 || info != want {
+		t.Errorf("parseDirRecord = %+v, %d, %v, want %+v, 46, true", info, length, ok, want)
+	}
+
+	info, _, ok = parseDirRecord(dirRecord("NOEXT.;1", 31, 10, false))
+	if !ok || info.Name != "NOEXT" {
+		t.Errorf("name without extension = %q, want NOEXT", info.Name)
+	}
+
+	// padding at the end of a sector
+	if _, _, ok := parseDirRecord(make([]byte, 64)); ok {
+		t.Errorf("parseDirRecord of zeroes is ok")
+	}
+
+	// name running past the record
+	rec := dirRecord("FILE;1", 30, 1, false)
+	rec[0x20] = 40
+	if _, _, ok := parseDirRecord(rec); ok {
+		t.Errorf("parseDirRecord with a name past the record is ok")
+	}
+}
+
+func TestReadDirSkipsUnsafeNames(t *testing.T) {
+	fs := newTestFilesystem(t, map[uint32][]byte{21: []byte("hello")},
+		dirRecord("\x00", 20, DATA_SIZE, true),
+		dirRecord("\x01", 20, DATA_SIZE, true),
+		dirRecord("GOOD.TXT;1", 21, 5, false),
+		dirRecord("..;1", 21, 5, false),
+		dirRecord(".;1", 21, 5, false),
+		dirRecord("A/B.TXT;1", 21, 5, false),
+		dirRecord("A\\B.TXT;1", 21, 5, false),
+		dirRecord("NUL\x00.TXT;1", 21, 5, false),
+		dirRecord("SUB", 22, DATA_SIZE, true),
+	)
+
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+
+	if want := []string{"GOOD.TXT", "SUB"}; !slices.Equal(names, want) {
+		t.Errorf("ReadDir names = %q, want %q", names, want)
+	}
+
+	data, err := fs.ReadFile("good.txt")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("ReadFile = %q, %v, want \"hello\"", data, err)
+	}
+}
+
+func TestBoot(t *testing.T) {
+	cnf := []byte("BOOT = cdrom:\\SLUS_007.54;1\r\n")
+
+	fs := newTestFilesystem(t, map[uint32][]byte{21: cnf},
+		dirRecord("SYSTEM.CNF;1", 21, uint32(len(cnf)), false),
+	)
+	boot, err := fs.Boot()
+	if want := (BootInfo{Exe: "SLUS_007.54", Serial: "SLUS-00754"}); err != nil || boot != want {
+		t.Errorf("Boot = %+v, %v, want %+v", boot, err, want)
+	}
+
+	// no SYSTEM.CNF boots PSX.EXE
+	fs = newTestFilesystem(t, nil, dirRecord("PSX.EXE;1", 21, 1, false))
+	boot, err = fs.Boot()
+	if want := (BootInfo{Exe: ISO_DEFAULT_BOOT}); err != nil || boot != want {
+		t.Errorf("Boot without SYSTEM.CNF = %+v, %v, want %+v", boot, err, want)
+	}
+
+	// a SYSTEM.CNF that can't be read doesn't
+	fs = newTestFilesystem(t, nil, dirRecord("SYSTEM.CNF;1", 1000, 10, false))
+	if boot, err := fs.Boot(); err == nil {
+		t.Errorf("Boot with an unreadable SYSTEM.CNF = %+v, want an error", boot)
+	}
+}
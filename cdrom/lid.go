@@ -0,0 +1,102 @@
+package cdrom
+
+import "github.com/TheOrnyx/psx-go/log"
+
+// The lid. Opening it stops the motor and takes the disc out, anything
+// being read or played gets an INT5. The shell open stat bit stays set
+// after it's closed again until a GetStat sees it, that's how games
+// notice the disc was changed. Swapping discs from the playlist opens
+// the lid and closes it again by itself a little later
+
+const (
+	LID_SWAP_CYCLES = CPU_CLOCK // lid open time when swapping discs, long enough for games to see it
+
+	ERR_DOOR_OPENED uint8 = 0x08 // error code when the lid opens while reading or playing
+)
+
+// LidOpen whether the lid is open
+func (c *CDROM) LidOpen() bool {
+	return c.lidOpen
+}
+
+// OpenLid open the lid and take the disc out
+func (c *CDROM) OpenLid() {
+	if c.lidOpen {
+		return
+	}
+
+	c.lidOpen = true
+	c.shellOpened = true
+	c.lidCycles = 0
+
+	busy := c.state == DriveReading || c.state == DrivePlaying
+	c.stopReading()
+	c.state = DriveStopped
+	c.haveHeader = false
+	c.xa.reset()
+
+	if busy {
+		c.respond(0, INT5, c.stat()|STAT_ERROR, ERR_DOOR_OPENED)
+	}
+
+	if c.disc != nil {
+		c.disc.Close()
+		c.disc = nil
+	}
+	c.subqPatches = nil
+	c.haveSubQ = false
+
+	log.Info("CDROM lid opened")
+}
+
+// CloseLid put the selected disc from the playlist in and close the
+// lid, the motor spins up if there's a disc
+func (c *CDROM) CloseLid() {
+	if !c.lidOpen {
+		return
+	}
+
+	c.lidOpen = false
+	c.lidCycles = 0
+	c.position = 0
+	c.seekPending = false
+
+	if len(c.playlist) > 0 {
+		if err := c.insertDisc(c.playlist[c.discIndex]); err != nil {
+			log.Warnf("CDROM lid closed without a disc: %v", err)
+		}
+	}
+
+	if c.disc != nil {
+		c.state = DriveIdle
+	}
+
+	log.Info("CDROM lid closed")
+}
+
+// NextDisc swap to the next disc in the playlist, the lid closes again
+// after LID_SWAP_CYCLES
+func (c *CDROM) NextDisc() {
+	if len(c.playlist) < 2 {
+		log.Warn("No other disc in the playlist to swap to")
+		return
+	}
+
+	c.OpenLid()
+	c.discIndex = (c.discIndex + 1) % len(c.playlist)
+	c.lidCycles = LID_SWAP_CYCLES
+
+	log.Infof("Swapping to disc %d of %d, %s", c.discIndex+1, len(c.playlist), c.playlist[c.discIndex])
+}
+
+// tickLid count down to closing the lid after a swap
+func (c *CDROM) tickLid(cpuCycles uint32) {
+	if c.lidCycles <= 0 {
+		return
+	}
+
+	c.lidCycles -= int32(cpuCycles)
+	if c.lidCycles <= 0 {
+		c.CloseLid()
+	}
+}
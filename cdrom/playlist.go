@@ -0,0 +1,59 @@
+package cdrom
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// .m3u playlists for games on more than one disc, one image per line
+// in disc order:
+//
+//	# comments start with #
+//	Game (Disc 1).cue
+//	Game (Disc 2).cue
+//
+// Paths are relative to the playlist
+
+// LoadPlaylist return the disc images in the playlist at path. Anything
+// that isn't a .m3u is a playlist of just itself
+func LoadPlaylist(path string) ([]string, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".m3u") {
+		return []string{path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open playlist: %v", err)
+	}
+	defer file.Close()
+
+	var discs []string
+	dir := filepath.Dir(path)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// some editors start the file with a byte order mark
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		discs = append(discs, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read playlist: %v", err)
+	}
+
+	if len(discs) == 0 {
+		return nil, fmt.Errorf("No discs in playlist %s", path)
+	}
+
+	return discs, nil
+}
@@ -0,0 +1,121 @@
+package cdrom
+
+import "github.com/TheOrnyx/psx-go/log"
+
+// Reading data sectors. While the drive is reading a sector comes off
+// the disc every 1/75th of a second (1/150th at double speed) and lands
+// in the sector buffer with an INT1. Setting BFRD in the request
+// register then loads it into the data FIFO for the CPU or DMA3 to
+// drain. The real controller has a few sector buffers, here there's one
+// and a sector that isn't acknowledged in time gets overwritten by the
+// next one like it would once they're all full
+
+const (
+	DATA_SECTOR_SIZE  = 0x800 // data only, from MODE2_DATA
+	WHOLE_SECTOR_SIZE = 0x924 // everything but the sync, from HEADER_OFFSET
+
+	REQUEST_BFRD uint8 = 1 << 7 // request register bit that loads the data FIFO
+)
+
+// startReading start reading sectors from the current position, the
+// first one shows up after a sector's time plus the seek if there was
+// one
+func (c *CDROM) startReading(seeked bool) {
+	c.state = DriveReading
+	c.xa.reset()
+	c.readCycles = c.sectorCycles()
+	if seeked {
+		c.readCycles += SEEK_CYCLES
+	}
+}
+
+// tickRead count down to the next sector and read it
+func (c *CDROM) tickRead(cpuCycles uint32) {
+	if c.state != DriveReading {
+		return
+	}
+
+	c.readCycles -= int32(cpuCycles)
+	if c.readCycles > 0 {
+		return
+	}
+	c.readCycles += c.sectorCycles()
+
+	if err := c.disc.ReadSector(c.position, c.sector[:]); err != nil {
+		log.Warnf("CDROM read stopped: %v", err)
+		c.state = DriveIdle
+		c.sectorPending = false
+		c.respond(0, INT4, c.stat())
+		return
+	}
+	c.updateSubQ(c.position)
+
+	// streamed audio plays instead of going to the CPU
+	if c.mode&MODE_XA_ADPCM != 0 && c.isXAAudio() {
+		c.playXA()
+		c.position++
+		return
+	}
+
+	if c.sectorPending {
+		log.Warnf("CDROM sector %s overwritten before it was acknowledged", MSFFromLBA(c.position-1))
+	}
+
+	copy(c.lastHeader[:], c.sector[HEADER_OFFSET:HEADER_OFFSET+8])
+	c.haveHeader = true
+	c.sectorPending = true
+	c.position++
+}
+
+// deliverSector raise INT1 for the sector in the buffer once the last
+// interrupt has been acknowledged
+func (c *CDROM) deliverSector() {
+	if !c.sectorPending || c.intFlagReg&7 != 0 {
+		return
+	}
+
+	c.sectorPending = false
+
+	// the size is picked when the sector gets to the buffer
+	start, size := MODE2_DATA, DATA_SECTOR_SIZE
+	if c.mode&MODE_WHOLE_SECTOR != 0 {
+		start, size = HEADER_OFFSET, WHOLE_SECTOR_SIZE
+	}
+	c.dataSize = copy(c.dataBuffer[:], c.sector[start:start+size])
+
+	c.deliver(pendingResponse{interrupt: INT1, data: []uint8{c.stat()}})
+}
+
+// stopReading stop reading and throw away a sector that wasn't
+// delivered yet
+func (c *CDROM) stopReading() {
+	c.sectorPending = false
+}
+
+// loadDataFIFO put the last delivered sector in the data FIFO
+func (c *CDROM) loadDataFIFO() {
+	c.data = c.dataBuffer[:c.dataSize]
+}
+
+// ReadData read a byte from the data FIFO
+func (c *CDROM) ReadData() uint8 {
+	if len(c.data) == 0 {
+		log.Warn("CDROM data FIFO read while it's empty")
+		return 0
+	}
+
+	val := c.data[0]
+	c.data = c.data[1:]
+
+	return val
+}
+
+// ReadDataWord read 4 bytes from the data FIFO, what DMA3 does
+func (c *CDROM) ReadDataWord() uint32 {
+	var word uint32
+	for i := range 4 {
+		word |= uint32(c.ReadData()) << (i * 8)
+	}
+
+	return word
+}
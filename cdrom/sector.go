@@ -0,0 +1,140 @@
+package cdrom
+
+// Raw sector layout and building raw sectors for images that only
+// store the user data. A raw data sector is
+//
+//	0x000  sync, 00 ff*10 00
+//	0x00c  header, minute, second, frame (BCD) and mode
+//	0x010  mode 1: 2048 bytes of data, EDC, 8 zeroes, ECC
+//	       mode 2 form 1: subheader twice, 2048 bytes of data, EDC, ECC
+//
+// The EDC is a CRC over the sector and the ECC is Reed-Solomon parity,
+// nothing on the PSX checks them but they're there so whole sectors
+// look like they came off a real disc
+
+const (
+	DATA_SIZE = 2048 // user data in a mode 1 or mode 2 form 1 sector
+
+	SYNC_OFFSET      = 0x000 // where the sync pattern is
+	HEADER_OFFSET    = 0x00c // where the header is
+	SUBHEADER_OFFSET = 0x010 // where the mode 2 subheader is
+	MODE1_DATA       = 0x010 // where a mode 1 sector's data is
+	MODE2_DATA       = 0x018 // where a mode 2 sector's data is
+)
+
+// Subheader submode bits
+const (
+	SUBMODE_EOR      uint8 = 1 << 0 // end of record
+	SUBMODE_VIDEO    uint8 = 1 << 1 // video sector
+	SUBMODE_AUDIO    uint8 = 1 << 2 // XA-ADPCM sector
+	SUBMODE_DATA     uint8 = 1 << 3 // data sector
+	SUBMODE_FORM2    uint8 = 1 << 5 // form 2, 2324 bytes of data and no ECC
+	SUBMODE_REALTIME uint8 = 1 << 6 // real time sector, streamed audio or video
+	SUBMODE_EOF      uint8 = 1 << 7 // end of file
+)
+
+var (
+	eccFLUT [256]uint8  // multiply by x in GF(2^8)
+	eccBLUT [256]uint8  // divide by x+1 in GF(2^8)
+	edcLUT  [256]uint32 // CRC table for the EDC
+)
+
+func init() {
+	for i := range 256 {
+		j := (i << 1) ^ ((i >> 7) * 0x11d)
+		eccFLUT[i] = uint8(j)
+		eccBLUT[uint8(i^j)] = uint8(i)
+
+		edc := uint32(i)
+		for range 8 {
+			edc = (edc >> 1) ^ ((edc & 1) * 0xd8018001)
+		}
+		edcLUT[i] = edc
+	}
+}
+
+// buildSector fill in the sync, header, EDC and ECC of the raw sector
+// in buf around the user data already at its offset. Mode 2 sectors get
+// a form 1 data subheader
+func buildSector(buf []byte, lba uint32, ttype TrackType) {
+	if ttype == TrackMode1 {
+		putSectorHeader(buf, lba, 1)
+
+		putEDC(buf[MODE1_DATA+DATA_SIZE:], buf[:MODE1_DATA+DATA_SIZE])
+		clear(buf[MODE1_DATA+DATA_SIZE+4 : MODE1_DATA+DATA_SIZE+12])
+		computeECC(buf)
+		return
+	}
+
+	putSectorHeader(buf, lba, 2)
+
+	// file, channel, submode, coding info, twice
+	subheader := [4]uint8{0x00, 0x00, SUBMODE_DATA, 0x00}
+	copy(buf[SUBHEADER_OFFSET:], subheader[:])
+	copy(buf[SUBHEADER_OFFSET+4:], subheader[:])
+
+	putEDC(buf[MODE2_DATA+DATA_SIZE:], buf[SUBHEADER_OFFSET:MODE2_DATA+DATA_SIZE])
+
+	// mode 2 ECC is worked out with the header zeroed
+	header := [4]uint8(buf[HEADER_OFFSET : HEADER_OFFSET+4])
+	clear(buf[HEADER_OFFSET : HEADER_OFFSET+4])
+	computeECC(buf)
+	copy(buf[HEADER_OFFSET:], header[:])
+}
+
+// putSectorHeader write the sync pattern and the header of the raw
+// sector at lba
+func putSectorHeader(buf []byte, lba uint32, mode uint8) {
+	copy(buf[SYNC_OFFSET:], syncPattern)
+
+	m, s, f := MSFFromLBA(lba).BCD()
+	buf[HEADER_OFFSET+0] = m
+	buf[HEADER_OFFSET+1] = s
+	buf[HEADER_OFFSET+2] = f
+	buf[HEADER_OFFSET+3] = mode
+}
+
+// putEDC write the EDC of data to dst, little endian
+func putEDC(dst []byte, data []byte) {
+	var edc uint32
+	for _, b := range data {
+		edc = (edc >> 8) ^ edcLUT[uint8(edc)^b]
+	}
+
+	dst[0] = uint8(edc)
+	dst[1] = uint8(edc >> 8)
+	dst[2] = uint8(edc >> 16)
+	dst[3] = uint8(edc >> 24)
+}
+
+// computeECC write the P and Q parity of a raw sector
+func computeECC(sector []byte) {
+	eccBlock(sector[0xc:], 86, 24, 2, 86, sector[0x81c:])
+	eccBlock(sector[0xc:], 52, 43, 86, 88, sector[0x8c8:])
+}
+
+// eccBlock compute one set of ECC parity bytes
+func eccBlock(src []byte, majorCount, minorCount, majorMult, minorInc int, dst []byte) {
+	size := majorCount * minorCount
+
+	for major := range majorCount {
+		index := (major>>1)*majorMult + (major & 1)
+		var a, b uint8
+
+		for range minorCount {
+			temp := src[index]
+			index += minorInc
+			if index >= size {
+				index -= size
+			}
+
+			a ^= temp
+			b ^= temp
+			a = eccFLUT[a]
+		}
+
+		a = eccBLUT[eccFLUT[a]^b]
+		dst[major] = a
+		dst[major+majorCount] = a ^ b
+	}
+}
@@ -0,0 +1,219 @@
+package cdrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheOrnyx/psx-go/log"
+)
+
+// Subchannel Q, the position data the drive reads alongside every
+// sector. Images don't store it so it's made up from the tracks:
+//
+//	0  control (0x4 data track) and ADR (1, position) nibbles
+//	1  track, BCD, 0xaa in the lead out
+//	2  index, BCD
+//	3  position in the track, BCD mm ss ff
+//	6  zero
+//	7  position on the disc, BCD mm ss ff
+//	10 CRC-16/CCITT of the rest, inverted, big endian
+//
+// The drive ignores Q with a bad CRC and keeps the last good one. That's
+// what LibCrypt checks for, some PAL discs have deliberately broken Q
+// on a few sectors that games look for with GetlocP. Those come from a
+// .sbi or .lsd file next to the image:
+//
+//	.sbi  "SBI\0", then a BCD mm ss ff and a type for each sector
+//	      type 1 is Q bytes 0-9, 2 is just 3-5, 3 is just 7-9
+//	.lsd  a BCD mm ss ff and the whole 12 byte Q for each sector
+
+const (
+	SUBQ_SIZE         = 12   // bytes of Q data
+	SUBQ_CRC_OFFSET   = 10   // where the CRC is
+	SUBQ_ADR_POSITION = 0x01 // ADR for position data
+	SUBQ_CONTROL_DATA = 0x40 // control bit for a data track
+	SUBQ_LEAD_OUT     = 0xaa // track number of the lead out
+
+	SBI_MAGIC       = "SBI\x00"
+	SBI_TYPE_FULL   = 1  // Q bytes 0-9
+	SBI_TYPE_REL    = 2  // Q bytes 3-5
+	SBI_TYPE_ABS    = 3  // Q bytes 7-9
+	LSD_RECORD_SIZE = 15 // MSF and a whole Q
+)
+
+// The subchannel Q of a sector
+type SubQ [SUBQ_SIZE]uint8
+
+// makeSubQ build the subchannel Q of the sector at lba from the tracks
+// of d
+func makeSubQ(d Disc, lba uint32) SubQ {
+	var q SubQ
+	am, as, af := MSFFromLBA(lba).BCD()
+	q[7], q[8], q[9] = am, as, af
+
+	track, ok := TrackAt(d, lba)
+	if ok {
+		index, relative := track.position(lba)
+		q[1], q[2] = toBCD(track.Number), index
+		q[3], q[4], q[5] = relative.BCD()
+	} else {
+		// lead out, it carries on like the last track
+		tracks := d.Tracks()
+		track = &tracks[len(tracks)-1]
+		q[1], q[2] = SUBQ_LEAD_OUT, 0x01
+		q[3], q[4], q[5] = MSFFromSectors(lba - d.LeadOut()).BCD()
+	}
+
+	q[0] = SUBQ_ADR_POSITION
+	if track.Type != TrackAudio {
+		q[0] |= SUBQ_CONTROL_DATA
+	}
+
+	q.setCRC()
+	return q
+}
+
+// crc the CRC Q should have
+func (q *SubQ) crc() uint16 {
+	var crc uint16
+	for _, b := range q[:SUBQ_CRC_OFFSET] {
+		crc = crc<<8 ^ crc16LUT[uint8(crc>>8)^b]
+	}
+
+	return ^crc
+}
+
+// setCRC fill in the CRC
+func (q *SubQ) setCRC() {
+	binary.BigEndian.PutUint16(q[SUBQ_CRC_OFFSET:], q.crc())
+}
+
+// validCRC whether the CRC is right, if it isn't the drive won't use it
+func (q *SubQ) validCRC() bool {
+	return binary.BigEndian.Uint16(q[SUBQ_CRC_OFFSET:]) == q.crc()
+}
+
+// subQ the subchannel Q of the sector at lba with the patches applied
+func (c *CDROM) subQ(lba uint32) SubQ {
+	if q, ok := c.subqPatches[lba]; ok {
+		return q
+	}
+
+	return makeSubQ(c.disc, lba)
+}
+
+// updateSubQ read the subchannel Q of the sector at lba, keeping it for
+// GetlocP if the CRC is good
+func (c *CDROM) updateSubQ(lba uint32) {
+	q := c.subQ(lba)
+	if !q.validCRC() {
+		return
+	}
+
+	c.lastSubQ = q
+	c.haveSubQ = true
+}
+
+// loadSubQPatches load the .sbi or .lsd next to the image at path, nil
+// if there isn't one
+func loadSubQPatches(d Disc, path string) (map[uint32]SubQ, error) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	for _, ext := range []string{".sbi", ".lsd"} {
+		data, err := os.ReadFile(base + ext)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read subchannel patches: %v", err)
+		}
+
+		var patches map[uint32]SubQ
+		if ext == ".sbi" {
+			patches, err = parseSBI(d, data)
+		} else {
+			patches, err = parseLSD(data)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Bad subchannel patches in %s: %v", filepath.Base(base+ext), err)
+		}
+
+		log.Infof("Loaded %d subchannel Q patches from %s", len(patches), filepath.Base(base+ext))
+		return patches, nil
+	}
+
+	return nil, nil
+}
+
+// parseSBI parse a .sbi file. It doesn't have CRCs, the sectors in it are
+// the broken ones so they get a bad one
+func parseSBI(d Disc, data []byte) (map[uint32]SubQ, error) {
+	if !bytes.HasPrefix(data, []byte(SBI_MAGIC)) {
+		return nil, fmt.Errorf("not an SBI file")
+	}
+
+	patches := make(map[uint32]SubQ)
+	for pos := len(SBI_MAGIC); pos < len(data); {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("truncated record at 0x%x", pos)
+		}
+
+		msf, err := MSFFromBCD(data[pos], data[pos+1], data[pos+2])
+		if err != nil {
+			return nil, err
+		}
+		lba := msf.LBA()
+		kind := data[pos+3]
+		pos += 4
+
+		// the partial ones patch what the sector would have
+		q := makeSubQ(d, lba)
+		var length, offset int
+		switch kind {
+		case SBI_TYPE_FULL:
+			length, offset = 10, 0
+		case SBI_TYPE_REL:
+			length, offset = 3, 3
+		case SBI_TYPE_ABS:
+			length, offset = 3, 7
+		default:
+			return nil, fmt.Errorf("unknown record type %d at 0x%x", kind, pos-1)
+		}
+
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("truncated record at 0x%x", pos-4)
+		}
+		copy(q[offset:], data[pos:pos+length])
+		pos += length
+
+		q.setCRC()
+		q[SUBQ_CRC_OFFSET] ^= 0xff
+		q[SUBQ_CRC_OFFSET+1] ^= 0xff
+		patches[lba] = q
+	}
+
+	return patches, nil
+}
+
+// parseLSD parse a .lsd file, it has the whole Q CRC and all
+func parseLSD(data []byte) (map[uint32]SubQ, error) {
+	if len(data)%LSD_RECORD_SIZE != 0 {
+		return nil, fmt.Errorf("size isn't a multiple of %d", LSD_RECORD_SIZE)
+	}
+
+	patches := make(map[uint32]SubQ)
+	for pos := 0; pos < len(data); pos += LSD_RECORD_SIZE {
+		msf, err := MSFFromBCD(data[pos], data[pos+1], data[pos+2])
+		if err != nil {
+			return nil, err
+		}
+
+		patches[msf.LBA()] = SubQ(data[pos+3 : pos+LSD_RECORD_SIZE])
+	}
+
+	return patches, nil
+}
@@ -0,0 +1,67 @@
+package cdrom
+
+import (
+	"slices"
+	"testing"
+)
+
+// Tests for the made up subchannel Q and the LibCrypt patches over it
+
+func TestMakeSubQ(t *testing.T) {
+	d := &memDisc{}
+
+	// data track 1, 16 sectors in so 00:00:16 and 00:02:16 on the disc.
+	// The CRC is CRC-16/XMODEM of the first 10 bytes inverted
+	want := SubQ{0x41, 0x01, 0x01, 0x00, 0x00, 0x16, 0x00, 0x00, 0x02, 0x16, 0x93, 0x1a}
+	if q := makeSubQ(d, 16); q != want {
+		t.Errorf("makeSubQ(16) = % x, want % x", q, want)
+	}
+
+	want = SubQ{0x41, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x28, 0x32}
+	if q := makeSubQ(d, 0); q != want {
+		t.Errorf("makeSubQ(0) = % x, want % x", q, want)
+	}
+}
+
+func TestSBIPatches(t *testing.T) {
+	d := &memDisc{}
+
+	// 00:02:16 (LBA 16) gets its absolute position moved on a sector,
+	// the way LibCrypt discs have it
+	sbi := []byte("SBI\x00")
+	sbi = append(sbi, 0x00, 0x02, 0x16, SBI_TYPE_ABS, 0x00, 0x02, 0x17)
+
+	patches, err := parseSBI(d, sbi)
+	if err != nil {
+		t.Fatalf("parseSBI: %v", err)
+	}
+
+	q, ok := patches[16]
+	if len(patches) != 1 || !ok {
+		t.Fatalf("parseSBI patched %v, want just LBA 16", patches)
+	}
+	if q[9] != 0x17 || q.validCRC() {
+		t.Errorf("patched Q = % x, want 00:02:17 and a bad CRC", q)
+	}
+
+	c := &CDROM{disc: d, subqPatches: patches}
+
+	// the bad Q is there but GetlocP keeps showing the last good one
+	if got := c.subQ(16); got != q {
+		t.Errorf("subQ(16) = % x, want the patch % x", got, q)
+	}
+
+	c.updateSubQ(15)
+	c.updateSubQ(16)
+	c.cmdGetlocP()
+
+	want := []uint8{0x01, 0x01, 0x00, 0x00, 0x15, 0x00, 0x02, 0x15}
+	if len(c.responses) != 1 || !slices.Equal(c.responses[0].data, want) {
+		t.Errorf("GetlocP responses %+v, want % x", c.responses, want)
+	}
+
+	c.updateSubQ(17)
+	if c.lastSubQ[9] != 0x17 || c.lastSubQ[5] != 0x17 {
+		t.Errorf("Q after LBA 17 = % x, want its own position", c.lastSubQ)
+	}
+}
@@ -0,0 +1,110 @@
+package cdrom
+
+// Response timing. Responses don't come back straight away, each one
+// waits in a queue until its delay has passed and the previous
+// interrupt was acknowledged. All the delays are in CPU cycles and
+// roughly what the real controller takes
+
+const (
+	ACK_CYCLES        = 25000         // first response of most commands
+	INIT_ACK_CYCLES   = 80000         // first response of Init
+	INIT_CYCLES       = 70000         // Init's second response after the first
+	GETID_CYCLES      = 33868         // GetID's second response after the first
+	SEEK_CYCLES       = 100000        // seeking anywhere, real seek times depend on the distance
+	PAUSE_IDLE_CYCLES = 7000          // pausing when the drive isn't doing anything
+	STOP_CYCLES       = CPU_CLOCK / 2 // spinning the motor down
+	STOPPED_CYCLES    = 7000          // stopping when the motor is already off
+)
+
+// A response waiting to be delivered
+type pendingResponse struct {
+	delay     int32   // CPU cycles left once it's at the front of the queue
+	interrupt uint8   // INT1-INT5
+	data      []uint8 // goes into the response FIFO
+	ack       bool    // first response of a command, clears the busy bit
+
+	// finish if set runs when the response gets delivered and gives its
+	// data, for commands whose stat should show what they were doing up
+	// until then
+	finish func() []uint8
+}
+
+// Tick run the controller for cpuCycles, returns true if the interrupt
+// line went up and IRQ2 should be raised
+func (c *CDROM) Tick(cpuCycles uint32) bool {
+	if len(c.responses) > 0 {
+		next := &c.responses[0]
+		next.delay -= int32(cpuCycles)
+
+		// the next one waits for the last interrupt to be acknowledged
+		if next.delay <= 0 && c.intFlagReg&7 == 0 {
+			c.deliver(*next)
+			c.responses = c.responses[1:]
+		}
+	}
+
+	c.tickLid(cpuCycles)
+	c.tickRead(cpuCycles)
+	c.tickPlay(cpuCycles)
+	c.deliverSector()
+
+	// the interrupt controller only sees the edge
+	line := c.intFlagReg&c.intEnableReg&0x1f != 0
+	raised := line && !c.irqLine
+	c.irqLine = line
+
+	return raised
+}
+
+// deliver put a response in the response FIFO and raise its interrupt
+func (c *CDROM) deliver(r pendingResponse) {
+	if r.finish != nil {
+		r.data = r.finish()
+	}
+
+	c.response.clear()
+	for _, val := range r.data {
+		c.response.push(val)
+	}
+
+	c.intFlagReg = c.intFlagReg&^7 | r.interrupt
+	if r.ack {
+		c.busy = false
+	}
+}
+
+// queueResponse queue a response delay cycles after the one before it
+func (c *CDROM) queueResponse(delay int32, interrupt uint8, ack bool, data ...uint8) {
+	c.responses = append(c.responses, pendingResponse{delay: delay, interrupt: interrupt, data: data, ack: ack})
+}
+
+// ack queue the INT3 first response of a command
+func (c *CDROM) ack(data ...uint8) {
+	c.queueResponse(ACK_CYCLES, INT3, true, data...)
+}
+
+// respond queue a second response
+func (c *CDROM) respond(delay int32, interrupt uint8, data ...uint8) {
+	c.queueResponse(delay, interrupt, false, data...)
+}
+
+// respondFinish queue a second response whose data comes from finish
+// when it gets delivered
+func (c *CDROM) respondFinish(delay int32, interrupt uint8, finish func() []uint8) {
+	c.responses = append(c.responses, pendingResponse{delay: delay, interrupt: interrupt, finish: finish})
+}
+
+// errorResponse queue an INT5 first response for a command that failed
+func (c *CDROM) errorResponse(code uint8) {
+	c.queueResponse(ACK_CYCLES, INT5, true, c.stat()|STAT_ERROR, code)
+}
+
+// sectorCycles CPU cycles it takes to read one sector at the current
+// speed
+func (c *CDROM) sectorCycles() int32 {
+	if c.mode&MODE_DOUBLE_SPEED != 0 {
+		return CPU_CLOCK / (SECTORS_PER_SECOND * 2)
+	}
+
+	return CPU_CLOCK / SECTORS_PER_SECOND
+}
@@ -0,0 +1,164 @@
+package cdrom
+
+// XA-ADPCM, the compressed audio games stream from mode 2 form 2
+// sectors between their data. With Setmode's XA-ADPCM bit on, real
+// time audio sectors get decoded and played instead of going to the
+// CPU, and with the filter bit on only the ones with the Setfilter file
+// and channel play. The subheader's coding info byte says how they're
+// packed:
+//
+//	bit 0    stereo
+//	bit 2    18.9kHz instead of 37.8kHz
+//	bit 4    8 bit samples instead of 4 bit
+//
+// The 2304 bytes of audio are 18 sound groups of 128 bytes, each one 16
+// bytes of headers then 28 words of samples for 8 sound units (4 with
+// 8 bit samples). Stereo sounds units alternate left and right. The
+// decoded samples get resampled to 44.1kHz for the SPU
+
+const (
+	XA_GROUPS        = 18    // sound groups in a sector
+	XA_GROUP_SIZE    = 128   // bytes in a sound group
+	XA_GROUP_HEADERS = 16    // header bytes at the start of a group
+	XA_UNIT_SAMPLES  = 28    // samples in a sound unit
+	XA_OUTPUT_RATE   = 44100 // what the SPU takes
+	XA_RATE_HIGH     = 37800 // sample rate without the coding info rate bit
+	XA_RATE_LOW      = 18900 // sample rate with it
+
+	XA_CODING_STEREO uint8 = 1 << 0 // coding info stereo bit
+	XA_CODING_LOW    uint8 = 1 << 2 // coding info 18.9kHz bit
+	XA_CODING_8BIT   uint8 = 1 << 4 // coding info 8 bit samples bit
+)
+
+// The ADPCM prediction filters, times 64
+var (
+	xaFilterPos = [4]int32{0, 60, 115, 98}
+	xaFilterNeg = [4]int32{0, 0, -52, -55}
+)
+
+// State carried between XA sectors
+type xaDecoder struct {
+	history [2][2]int32 // last two samples of each channel, newest first
+	phase   uint32      // resampler position between the last sample and the next, in 1/44100ths
+	last    [2]int32    // last sample of each channel, for the resampler
+}
+
+// reset forget the last stream
+func (x *xaDecoder) reset() {
+	*x = xaDecoder{}
+}
+
+// isXAAudio whether the sector in the buffer is a real time XA-ADPCM
+// sector
+func (c *CDROM) isXAAudio() bool {
+	submode := c.sector[SUBHEADER_OFFSET+2]
+	return c.sector[HEADER_OFFSET+3] == 2 && submode&SUBMODE_AUDIO != 0 && submode&SUBMODE_REALTIME != 0
+}
+
+// xaFilterMatch whether the sector in the buffer is on the Setfilter
+// file and channel
+func (c *CDROM) xaFilterMatch() bool {
+	return c.sector[SUBHEADER_OFFSET] == c.filterFile && c.sector[SUBHEADER_OFFSET+1] == c.filterChannel
+}
+
+// playXA decode the XA-ADPCM sector in the buffer and send it to the SPU
+func (c *CDROM) playXA() {
+	if c.mode&MODE_XA_FILTER != 0 && !c.xaFilterMatch() {
+		return
+	}
+
+	coding := c.sector[SUBHEADER_OFFSET+3]
+	left, right := c.xa.decodeSector(c.sector[MODE2_DATA:], coding)
+
+	rate := uint32(XA_RATE_HIGH)
+	if coding&XA_CODING_LOW != 0 {
+		rate = XA_RATE_LOW
+	}
+
+	muted := c.adpcmMuted
+	c.xa.resample(left, right, rate, func(l, r int16) {
+		if muted {
+			l, r = 0, 0
+		}
+		c.outputAudio(l, r)
+	})
+}
+
+// decodeSector decode the sound groups of a sector, mono comes out the
+// same on both sides
+func (x *xaDecoder) decodeSector(data []byte, coding uint8) (left []int16, right []int16) {
+	stereo := coding&XA_CODING_STEREO != 0
+	eightBit := coding&XA_CODING_8BIT != 0
+
+	units := 8
+	if eightBit {
+		units = 4
+	}
+
+	var samples [2][]int16
+	for group := range XA_GROUPS {
+		g := data[group*XA_GROUP_SIZE : (group+1)*XA_GROUP_SIZE]
+
+		for unit := range units {
+			channel := 0
+			if stereo {
+				channel = unit & 1
+			}
+
+			header := g[4+unit]
+			samples[channel] = x.decodeUnit(samples[channel], g, unit, header, eightBit, channel)
+		}
+	}
+
+	if !stereo {
+		return samples[0], samples[0]
+	}
+
+	return samples[0], samples[1]
+}
+
+// decodeUnit decode the 28 samples of one sound unit onto out
+func (x *xaDecoder) decodeUnit(out []int16, group []byte, unit int, header uint8, eightBit bool, channel int) []int16 {
+	shift := header & 0x0f
+	if shift > 12 {
+		shift = 9
+	}
+	filter := (header >> 4) & 3
+
+	hist := &x.history[channel]
+	for i := range XA_UNIT_SAMPLES {
+		var raw int32
+		if eightBit {
+			raw = int32(int16(uint16(group[XA_GROUP_HEADERS+i*4+unit]) << 8))
+		} else {
+			b := group[XA_GROUP_HEADERS+i*4+unit/2] >> ((unit & 1) * 4)
+			raw = int32(int16(uint16(b&0x0f) << 12))
+		}
+
+		sample := raw>>shift + (hist[0]*xaFilterPos[filter]+hist[1]*xaFilterNeg[filter]+32)>>6
+		sample = int32(clampSample(sample))
+
+		hist[1], hist[0] = hist[0], sample
+		out = append(out, int16(sample))
+	}
+
+	return out
+}
+
+// resample linearly interpolate from rate up to 44.1kHz, calling emit
+// for every output sample
+func (x *xaDecoder) resample(left, right []int16, rate uint32, emit func(l, r int16)) {
+	for i := range left {
+		next := [2]int32{int32(left[i]), int32(right[i])}
+
+		for x.phase < XA_OUTPUT_RATE {
+			l := x.last[0] + (next[0]-x.last[0])*int32(x.phase)/XA_OUTPUT_RATE
+			r := x.last[1] + (next[1]-x.last[1])*int32(x.phase)/XA_OUTPUT_RATE
+			emit(int16(l), int16(r))
+			x.phase += rate
+		}
+
+		x.phase -= XA_OUTPUT_RATE
+		x.last = next
+	}
+}
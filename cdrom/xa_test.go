@@ -0,0 +1,73 @@
+package cdrom
+
+import (
+	"slices"
+	"testing"
+)
+
+// Known answer tests for the XA-ADPCM decoder. The expected samples were
+// worked out separately from the sound unit layout and filter formula,
+// not by running this decoder
+
+func TestXADecodeUnit(t *testing.T) {
+	group := make([]byte, XA_GROUP_SIZE)
+	for i := range XA_UNIT_SAMPLES {
+		for k := range 4 {
+			group[XA_GROUP_HEADERS+i*4+k] = uint8(i*37 + k*11 + 5)
+		}
+	}
+
+	// every filter once, shift 13 is out of range and acts like 9. The
+	// history carries on from one unit to the next like it does in mono
+	tests := []struct {
+		header uint8
+		want   []int16
+	}{
+		{0x0c, []int16{
+			5, -6, -1, 4, -7, -2, 3, -8, -3, 2, 7, -4, 1, 6,
+			-5, 0, 5, -6, -1, 4, -7, -2, 3, -8, -3, 2, 7, -4,
+		}},
+		{0x14, []int16{
+			-4, 508, 1500, 3198, 1206, -149, -652, -611, -61, 1223, 2939, 963, -121, -625,
+			-586, 219, 1485, 3184, 1193, 94, -424, -397, 396, 1651, 3340, 1595, 471, -70,
+		}},
+		{0x20, []int16{
+			-508, 19624, 11099, -97, 7192, -15670, -32768, -32768, -32768, -32768, -24064, 12056, 24831, 32767,
+			32767, 11775, -5465, 1093, -18172, -32768, -27731, -32768, -32768, -19968, -32768, -32768, -24064, 12056,
+		}},
+		{0x3d, []int16{
+			32767, 32767, 22055, 5669, -10321, -20708, -22856, -17194, -6662, 4615, 12728, 15476, 12728, 6182,
+			-1464, -7530, -10224, -9248, -5423, -388, 4058, 6555, 6574, 4481, 1148, -2141, -4289, -4736,
+		}},
+	}
+
+	var x xaDecoder
+	for unit, test := range tests {
+		got := x.decodeUnit(nil, group, unit, test.header, false, 0)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("unit %d header 0x%02x\n got %v\nwant %v", unit, test.header, got, test.want)
+		}
+	}
+}
+
+func TestXAResample(t *testing.T) {
+	var x xaDecoder
+	var left, right []int16
+
+	// 18.9kHz to 44.1kHz is 7 samples out for every 3 in, ramping up
+	// from the silence before
+	samples := []int16{1000, 1000, 1000, 1000, 1000, 1000}
+	x.resample(samples, samples, XA_RATE_LOW, func(l, r int16) {
+		left = append(left, l)
+		right = append(right, r)
+	})
+
+	want := []int16{0, 428, 857, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000}
+	if !slices.Equal(left, want) || !slices.Equal(right, want) {
+		t.Errorf("resampled\n got %v %v\nwant %v", left, right, want)
+	}
+
+	if x.phase != 0 {
+		t.Errorf("phase = %d after a whole number of output samples, want 0", x.phase)
+	}
+}
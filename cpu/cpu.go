@@ -96,13 +96,36 @@ func (cpu *CPU) RunNextInstruction() {
 	// reset load to target 0 for next instr
 	cpu.SetLoadReg(0, 0)
 
-	cpu.decodeAndExecuteInstr(instruction)
+	if cpu.interruptPending() {
+		// the fetched instruction doesn't run, EPC points to it so it
+		// gets run after the handler returns
+		cpu.Exception(Interrupt)
+	} else {
+		cpu.decodeAndExecuteInstr(instruction)
+	}
 	
 	// set the regs to the outregs
 	// FIXME - optimize later
 	cpu.regs = cpu.outRegs
 }
 
+// interruptPending update the interrupt pending bit in CAUSE from the
+// interrupt controller and return whether the CPU should take the
+// interrupt
+func (cpu *CPU) interruptPending() bool {
+	regs := &cpu.copZeroRegs
+
+	// the interrupt controller is wired to IP2 (bit 10) of CAUSE
+	if cpu.bus.IRQActive() {
+		regs.cause |= 1 << 10
+	} else {
+		regs.cause &^= 1 << 10
+	}
+
+	// SR bit 0 is the current interrupt enable and bits 8-15 the mask
+	return regs.sr&1 != 0 && (regs.sr&regs.cause)&0xff00 != 0
+}
+
 // decodeAndExecuteInstr decode and execute an instruction
 // TODO - switch from binary to hex cuz nicer
 func (cpu *CPU) decodeAndExecuteInstr(instruction Instruction) {
@@ -233,6 +256,7 @@ func (cpu *CPU) jump(instr Instruction) {
 
 // exception enums
 const (
+	Interrupt = 0x0
 	SysCall  = 0x8
 	Overflow = 0xc
 	LoadAddressError = 0x4
@@ -6,8 +6,16 @@ import (
 	"github.com/TheOrnyx/psx-go/gpu"
 	"github.com/TheOrnyx/psx-go/memory"
 	"github.com/TheOrnyx/psx-go/renderer"
+	"github.com/TheOrnyx/psx-go/spu"
 )
 
+// CYCLES_PER_INSTRUCTION the number of CPU cycles we pretend every
+// instruction takes
+//
+// HACK - we don't count cycles properly yet so just assume every
+// instruction takes 2
+const CYCLES_PER_INSTRUCTION = 2
+
 // Emulator - Basic struct for holding all the components of the emulator
 type Emulator struct {
 	Cpu      *cpu.CPU
@@ -15,14 +23,39 @@ type Emulator struct {
 	Renderer *renderer.Renderer
 	Bus      *memory.Bus
 	Cdrom    *cdrom.CDROM
+	Spu      *spu.SPU
 }
 
-// Step - step emulator once
-func (e *Emulator) Step() {
+// Step - step emulator once, returns true when the GPU started vblank
+func (e *Emulator) Step() bool {
 	e.Cpu.RunNextInstruction()
+
+	// writing to the GPU while its FIFO is full holds up the CPU or DMA
+	cycles := CYCLES_PER_INSTRUCTION + e.Gpu.Stall()
+
+	vblank := e.Gpu.Tick(cycles)
+	if vblank {
+		e.Bus.RequestInterrupt(memory.IRQVBlank)
+	}
+
+	if e.Cdrom.Tick(cycles) {
+		e.Bus.RequestInterrupt(memory.IRQCdrom)
+	}
+
+	e.Spu.Tick(cycles)
+
+	return vblank
+}
+
+// RunFrame - run the emulator until the next vblank, the GPU presents
+// the frame when it gets there
+func (e *Emulator) RunFrame() {
+	for !e.Step() {
+	}
 }
 
 // Quit - Quit the emulator and cleanup it's stuff
 func (e *Emulator) Quit() {
 	e.Gpu.Quit()
+	e.Cdrom.Quit()
 }
@@ -4,6 +4,7 @@ go 1.22.3
 
 require (
 	github.com/rs/zerolog v1.33.0
+	github.com/ulikunitz/xz v0.5.12
 	github.com/veandco/go-sdl2 v0.4.40
 )
 
@@ -0,0 +1,78 @@
+package gpu
+
+import (
+	"image"
+
+	"github.com/TheOrnyx/psx-go/renderer"
+)
+
+// Backend - what the GPU hands its primitives and finished frames to.
+// The GL renderer is the normal one, HeadlessBackend is used when
+// running without a window
+type Backend interface {
+	PushTriangle(positions [3]renderer.VRAMPos, colors [3]renderer.Color)
+	PushTexturedTriangle(positions [3]renderer.VRAMPos, colors [3]renderer.Color, coords [3]renderer.TexCoord, tex renderer.TexInfo)
+	PushQuad(positions [4]renderer.VRAMPos, colors [4]renderer.Color)
+	SetDrawArea(left, top, right, bottom int32)
+	SetBlendMode(mode renderer.BlendMode)
+	SetMaskBit(force, check bool)
+	SetDither(enabled bool)
+	SetTextureWindow(maskX, maskY, offsetX, offsetY uint8)
+	UploadVRAM(x, y, width, height int32, pixels []uint16)     // copy pixels the GPU wrote itself
+	UpdateTextures(x, y, width, height int32, pixels []uint16) // copy VRAM that changed before textures get sampled from it
+	NeedsFrame(area renderer.DisplayArea) bool                 // whether Present wants the frame built from VRAM
+	Present(frame *image.RGBA, area renderer.DisplayArea)      // show a finished frame, nil if NeedsFrame said no
+	Quit()
+}
+
+// HeadlessBackend backend that doesn't draw anything, it just holds on
+// to the last frame the GPU presented
+type HeadlessBackend struct {
+	LastFrame *image.RGBA // The last frame presented
+	Frames    uint64      // Number of frames presented so far
+}
+
+// PushTriangle nothing to draw with
+func (h *HeadlessBackend) PushTriangle(positions [3]renderer.VRAMPos, colors [3]renderer.Color) {}
+
+// PushTexturedTriangle nothing to draw with
+func (h *HeadlessBackend) PushTexturedTriangle(positions [3]renderer.VRAMPos, colors [3]renderer.Color, coords [3]renderer.TexCoord, tex renderer.TexInfo) {
+}
+
+// PushQuad nothing to draw with
+func (h *HeadlessBackend) PushQuad(positions [4]renderer.VRAMPos, colors [4]renderer.Color) {}
+
+// SetDrawArea nothing to do
+func (h *HeadlessBackend) SetDrawArea(left, top, right, bottom int32) {}
+
+// SetBlendMode nothing to do
+func (h *HeadlessBackend) SetBlendMode(mode renderer.BlendMode) {}
+
+// SetMaskBit nothing to do
+func (h *HeadlessBackend) SetMaskBit(force, check bool) {}
+
+// SetDither nothing to do
+func (h *HeadlessBackend) SetDither(enabled bool) {}
+
+// SetTextureWindow nothing to do
+func (h *HeadlessBackend) SetTextureWindow(maskX, maskY, offsetX, offsetY uint8) {}
+
+// UploadVRAM nothing to do, the GPU's VRAM is all there is
+func (h *HeadlessBackend) UploadVRAM(x, y, width, height int32, pixels []uint16) {}
+
+// UpdateTextures nothing to do
+func (h *HeadlessBackend) UpdateTextures(x, y, width, height int32, pixels []uint16) {}
+
+// NeedsFrame always, the frame is all it keeps
+func (h *HeadlessBackend) NeedsFrame(area renderer.DisplayArea) bool {
+	return true
+}
+
+// Present keep the frame around
+func (h *HeadlessBackend) Present(frame *image.RGBA, area renderer.DisplayArea) {
+	h.LastFrame = frame
+	h.Frames += 1
+}
+
+// Quit nothing to clean up
+func (h *HeadlessBackend) Quit() {}
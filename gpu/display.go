@@ -0,0 +1,146 @@
+package gpu
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/TheOrnyx/psx-go/renderer"
+)
+
+// Stuff for working out what's actually on screen and turning that
+// part of VRAM into a frame the backends can show
+
+// width return the number of pixels per line for this horizontal resolution
+func (h HorizontalRes) width() int32 {
+	if h&1 != 0 { // hr2 set
+		return 368
+	}
+
+	switch h >> 1 {
+	case 0:
+		return 256
+	case 1:
+		return 320
+	case 2:
+		return 512
+	default:
+		return 640
+	}
+}
+
+// displayWidth the width of the displayed picture in pixels. Worked
+// out from the horizontal display range in dotclocks, falls back to
+// the full horizontal resolution if the range doesn't make sense
+func (g *Gpu) displayWidth() int32 {
+	hres := g.gpuStat.horizontalRes
+	full := hres.width()
+
+	cycles := int32(g.displayHorizEnd) - int32(g.displayHorizStart)
+	dots := cycles / int32(hres.dotclockDivider())
+
+	// the hardware rounds it to a multiple of 4
+	dots = (dots + 2) &^ 3
+	if dots <= 0 || dots > full {
+		return full
+	}
+
+	return dots
+}
+
+// displayHeight the height of the displayed picture in lines, doubled
+// in 480 line interlaced mode
+func (g *Gpu) displayHeight() int32 {
+	lines := int32(g.displayLineEnd) - int32(g.displayLineStart)
+	if lines <= 0 {
+		return 0
+	}
+
+	if g.gpuStat.verticalRes == Y480Lines && g.gpuStat.verticalInterlace {
+		lines *= 2
+	}
+
+	return min(lines, VRAM_HEIGHT)
+}
+
+// displayArea the part of VRAM the display area covers, for the backends
+func (g *Gpu) displayArea() renderer.DisplayArea {
+	return renderer.DisplayArea{
+		X:        int32(g.displayVramXStart),
+		Y:        int32(g.displayVramYStart),
+		Width:    g.displayWidth(),
+		Height:   g.displayHeight(),
+		Depth24:  g.gpuStat.displayDepth == D24Bit,
+		Disabled: g.gpuStat.displayDisabled,
+	}
+}
+
+// buildFrame build the frame for the current display area from
+// VRAM. Both fields of a 480i picture are in VRAM so they just get
+// woven together
+func (g *Gpu) buildFrame() *image.RGBA {
+	width := int(g.displayWidth())
+	height := int(g.displayHeight())
+	frame := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	stat := &g.gpuStat
+	if stat.displayDisabled {
+		// black screen
+		for i := 3; i < len(frame.Pix); i += 4 {
+			frame.Pix[i] = 0xff
+		}
+
+		return frame
+	}
+
+	startX := int32(g.displayVramXStart)
+	startY := int32(g.displayVramYStart)
+
+	for y := 0; y < height; y++ {
+		line := startY + int32(y)
+
+		for x := 0; x < width; x++ {
+			var c color.RGBA
+			if stat.displayDepth == D24Bit {
+				c = g.pixel24(startX, line, int32(x))
+			} else {
+				c = pixel15ToRGBA(g.vram.get(startX+int32(x), line))
+			}
+
+			frame.SetRGBA(x, y, c)
+		}
+	}
+
+	return frame
+}
+
+// pixel24 decode pixel x of a line in 24-bit mode. The pixels are
+// packed as 3 bytes starting at VRAM column startX so they straddle
+// the 16-bit VRAM pixels
+func (g *Gpu) pixel24(startX, line, x int32) color.RGBA {
+	offset := x * 3
+
+	return color.RGBA{
+		R: g.vramByte(startX, line, offset),
+		G: g.vramByte(startX, line, offset+1),
+		B: g.vramByte(startX, line, offset+2),
+		A: 0xff,
+	}
+}
+
+// vramByte return byte offset of a VRAM line starting at column startX
+func (g *Gpu) vramByte(startX, line, offset int32) uint8 {
+	pixel := g.vram.get(startX+offset/2, line)
+
+	return uint8(pixel >> ((offset & 1) * 8))
+}
+
+// pixel15ToRGBA convert a 15-bit VRAM pixel into a 24-bit color,
+// the mask bit gets dropped
+func pixel15ToRGBA(pixel uint16) color.RGBA {
+	return color.RGBA{
+		R: renderer.Expand5(pixel),
+		G: renderer.Expand5(pixel >> 5),
+		B: renderer.Expand5(pixel >> 10),
+		A: 0xff,
+	}
+}
@@ -0,0 +1,52 @@
+package gpu
+
+import "github.com/TheOrnyx/psx-go/log"
+
+const GP0_FIFO_LEN = 16 // number of words the GP0 FIFO holds
+
+// The GP0 command FIFO. Words written to GP0 sit in here until the GPU
+// gets around to running them
+type CommandFIFO struct {
+	buffer [GP0_FIFO_LEN]uint32 // the words
+	read   uint8                // index of the next word to pop
+	length uint8                // number of words in the FIFO
+}
+
+// clear empty the FIFO
+func (f *CommandFIFO) clear() {
+	f.read = 0
+	f.length = 0
+}
+
+// empty whether the FIFO is empty
+func (f *CommandFIFO) empty() bool {
+	return f.length == 0
+}
+
+// full whether the FIFO is full
+func (f *CommandFIFO) full() bool {
+	return f.length == GP0_FIFO_LEN
+}
+
+// push push word to the back of the FIFO
+func (f *CommandFIFO) push(word uint32) {
+	if f.full() {
+		log.Panicf("GP0 FIFO overflow pushing 0x%08x", word)
+	}
+
+	f.buffer[(f.read+f.length)%GP0_FIFO_LEN] = word
+	f.length += 1
+}
+
+// pop pop the word at the front of the FIFO
+func (f *CommandFIFO) pop() uint32 {
+	if f.empty() {
+		log.Panic("GP0 FIFO underflow")
+	}
+
+	word := f.buffer[f.read]
+	f.read = (f.read + 1) % GP0_FIFO_LEN
+	f.length -= 1
+
+	return word
+}
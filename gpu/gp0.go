@@ -7,19 +7,24 @@ import (
 
 // This is for like commands and shit for GP0 cuz cbf putting it in the same file
 
+// Rough GPU cycles it takes to set up a triangle before drawing any pixels
+const POLYGON_SETUP_CYCLES = 64
+
 // gp0DrawMode GPO(0xE1) command for setting draw mode settings
 func (g *Gpu) gp0DrawMode(val uint32) {
 	stat := &g.gpuStat // can't be bothered typing g.gpuStat each time
 
 	stat.pageBaseX = uint8(val & 0xf)
 	stat.pageBaseY = uint8((val >> 4) & 1)
-	stat.semiTransparency = uint8((val >> 5) & 3)
+	stat.semiTransparency = SemiTransparency((val >> 5) & 3)
 
 	stat.textureDepth = texDepthFromU32((val >> 7) & 3)
 
 	stat.dithering = ((val >> 9) & 1) != 0
 	stat.allowDrawToDisplay = ((val >> 10) & 1) != 0
-	stat.forceSetMaskBit = ((val >> 11) & 1) != 0 // FIXME - the specs says this bit should set base y page 2
+	// NOTE - bit 11 is texture disable, the mask bit settings come
+	// from GP0(E6h). It only works if GP1(09h) allowed it
+	stat.textureDisable = g.allowTextureDisable && ((val>>11)&1) != 0
 
 	g.rectangleTextureXFlip = ((val >> 12) & 1) != 0
 	g.rectangleTextureYFlip = ((val >> 13) & 1) != 0
@@ -45,11 +50,8 @@ func (g *Gpu) gp0SetDrawOffset(val uint32) {
 	// values are 11bit two's complement signed values so shift to force sign extension
 	xOffset := int16(x<<5) >> 5
 	yOffset := int16(y<<5) >> 5
-	g.renderer.SetDrawOffset(xOffset, yOffset)
-
-	// HACK - Force display when changing offset since we don't have proper timings yet
-	log.Info("Running renderer display in gp0SetDrawOffset")
-	g.renderer.Display()
+	g.drawXOffset = xOffset
+	g.drawYOffset = yOffset
 }
 
 // gp0SetTextureWindow GP0(E2h) - Set Texture Window
@@ -76,29 +78,15 @@ func (g *Gpu) gp0ClearCache() {
 	log.Info("Texture cache not implemented yet")
 }
 
-// gp0MonoQuadPolyOpaque GP0(28h) - Monochrome four-point polygon, opaque
-func (g *Gpu) gp0MonoQuadPolyOpaque(val uint32) {
-	positions := [4]renderer.VRAMPos{
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(1)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(2)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(3)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(4)),
-	}
-
-	// one color repeated 4 times
-	color := renderer.ColorFromGP0(g.gp0CmdBuffer.at(0))
-	colors := [4]renderer.Color{color, color, color, color}
-
-	g.renderer.PushQuad(positions, colors)
-}
-
 // gp0ImageLoad GP0(A0h) - Image load
 func (g *Gpu) gp0ImageLoad() {
-	// param 2 contains image res
+	// param 1 contains the destination and param 2 the image res
+	pos := g.gp0CmdBuffer.at(1)
 	res := g.gp0CmdBuffer.at(2)
 
-	width := res & 0xffff
-	height := res >> 16
+	// sizes are wrapped so 0 means the max size
+	width := (((res & 0xffff) - 1) & 0x3ff) + 1
+	height := (((res >> 16) - 1) & 0x1ff) + 1
 	imgSize := width * height
 
 	// If we have off number of pixels we must round up since we
@@ -106,73 +94,260 @@ func (g *Gpu) gp0ImageLoad() {
 	// last word
 	imgSize = (imgSize + 1) & (^uint32(1))
 
+	g.imgLoad = imageTransfer{
+		x:      int32(pos & 0x3ff),
+		y:      int32((pos >> 16) & 0x1ff),
+		width:  int32(width),
+		height: int32(height),
+	}
+
 	// Store number of words expected for image
 	g.gp0WordsRemaining = imgSize / 2
 	g.gp0Mode = GP0ModeImgLoad
 }
 
+// imageLoadPixel write the next pixel of the current image load into
+// VRAM. The mask bit settings apply here too
+func (g *Gpu) imageLoadPixel(pixel uint16) {
+	t := &g.imgLoad
+	if t.done() {
+		return // padding at the end of the last word
+	}
+
+	x := t.x + t.curX
+	y := t.y + t.curY
+	t.advance()
+
+	if g.gpuStat.checkMaskBeforeDraw && g.vram.get(x, y)&0x8000 != 0 {
+		return
+	}
+
+	if g.gpuStat.forceSetMaskBit {
+		pixel |= 0x8000
+	}
+
+	g.vram.set(x, y, pixel)
+}
+
 // gp0ImageStore GP0(C0h) - Image Store
 func (g *Gpu) gp0ImageStore() {
+	pos := g.gp0CmdBuffer.at(1)
 	res := g.gp0CmdBuffer.at(2)
 
-	width := res & 0xffff
-	height := res >> 16
+	g.imgStore = imageTransfer{
+		x:      int32(pos & 0x3ff),
+		y:      int32((pos >> 16) & 0x1ff),
+		width:  int32((((res & 0xffff) - 1) & 0x3ff) + 1),
+		height: int32((((res >> 16) - 1) & 0x1ff) + 1),
+	}
 
-	log.Infof("(Not implemented yet) Unhandled image store: width:%v, height:%v", width, height)
+	// the data gets read through GPUREAD from now on
+	g.gpuStat.readyToSendVram = true
 }
 
-// gp0QuadShadedOpaque GP0(38h) - Shaded opaque Quadrilateral
-func (g *Gpu) gp0QuadShadedOpaque() {
-	positions := [4]renderer.VRAMPos{
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(1)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(3)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(5)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(7)),
+// imageStorePixel read the next pixel of the current image store,
+// ends the transfer once we're past the last one
+func (g *Gpu) imageStorePixel() uint16 {
+	t := &g.imgStore
+	if t.done() {
+		return 0 // padding at the end of the last word
 	}
 
-	colors := [4]renderer.Color{
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(0)),
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(2)),
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(4)),
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(6)),
+	pixel := g.vram.get(t.x+t.curX, t.y+t.curY)
+	t.advance()
+
+	if t.done() {
+		g.gpuStat.readyToSendVram = false
 	}
 
-	g.renderer.PushQuad(positions, colors)
+	return pixel
 }
 
-// gp0TriShadedOpaque GP0(30h) - Shaded three-point polygon, opaque
-func (g *Gpu) gp0TriShadedOpaque() {
-	positions := [3]renderer.VRAMPos{
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(1)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(3)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(5)),
+// gp0Polygon GP0(20h-3Fh) - Render polygon. The opcode bits describe
+// the polygon:
+//
+//	bit 0 - raw texture (texture isn't blended with the color)
+//	bit 1 - semi transparent
+//	bit 2 - textured
+//	bit 3 - four vertices instead of three
+//	bit 4 - gouraud shaded
+func (g *Gpu) gp0Polygon() {
+	opcode := g.gp0CmdBuffer.at(0) >> 24
+
+	numVertices := 3
+	if opcode&0x08 != 0 {
+		numVertices = 4
+	}
+
+	attrs := primAttrs{
+		shaded:          opcode&0x10 != 0,
+		textured:        opcode&0x04 != 0,
+		rawTexture:      opcode&0x01 != 0,
+		semiTransparent: opcode&0x02 != 0,
 	}
 
-	colors := [3]renderer.Color{
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(0)),
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(2)),
-		renderer.ColorFromGP0(g.gp0CmdBuffer.at(4)),
+	var vertices [4]vertex
+
+	color := renderer.ColorFromGP0(g.gp0CmdBuffer.at(0))
+	index := uint8(1)
+
+	for i := range numVertices {
+		// shaded polygons have a color before every vertex except the
+		// first which gets it from the command word
+		if attrs.shaded && i > 0 {
+			color = renderer.ColorFromGP0(g.gp0CmdBuffer.at(index))
+			index += 1
+		}
+
+		pos := g.gp0CmdBuffer.at(index)
+		index += 1
+		vertices[i].x, vertices[i].y = g.vertexPos(pos)
+		vertices[i].color = color
+
+		if attrs.textured {
+			uv := g.gp0CmdBuffer.at(index)
+			index += 1
+			vertices[i].u = uint8(uv)
+			vertices[i].v = uint8(uv >> 8)
+
+			switch i {
+			case 0: // first UV word has the CLUT
+				attrs.clutX = int32((uv>>16)&0x3f) * 16
+				attrs.clutY = int32((uv >> 22) & 0x1ff)
+			case 1: // second one has the texture page
+				g.applyTexPage(uv >> 16)
+			}
+		}
 	}
 
-	g.renderer.PushTriangle(positions, colors)
+	g.texPageAttrs(&attrs)
+	if g.gpuStat.textureDisable {
+		// drawn with the plain vertex colors instead
+		attrs.textured = false
+	}
+
+	// only shaded and texture blended primitives get dithered, flat
+	// colors and raw textures are already 15-bit
+	attrs.dither = g.gpuStat.dithering && (attrs.shaded || (attrs.textured && !attrs.rawTexture))
+
+	g.setRendererState(&attrs)
+	g.inspectPrimitive(vertices[:numVertices], &attrs)
+	defer g.endInspectPrimitive()
+
+	// quads are drawn as two triangles, each one gets culled on its own
+	pixels := g.pushTriangle([3]vertex{vertices[0], vertices[1], vertices[2]}, &attrs)
+	if numVertices == 4 {
+		pixels += g.pushTriangle([3]vertex{vertices[1], vertices[2], vertices[3]}, &attrs)
+	}
+	g.addBusyCycles(POLYGON_SETUP_CYCLES*int32(numVertices-2) + drawCost(pixels, &attrs))
+}
+
+// pushTriangle draw a triangle with the software rasterizer and hand
+// it to the renderer, returns the number of pixels drawn. Triangles
+// that are too big get dropped like on the hardware
+func (g *Gpu) pushTriangle(v [3]vertex, attrs *primAttrs) int32 {
+	if triangleTooBig(v) {
+		return 0
+	}
+
+	// the renderer has to see the texture as it is before this
+	// triangle, it could be drawing into it
+	if attrs.textured && g.texturesDirty(attrs) {
+		g.syncTextures()
+	}
+
+	pixels := g.drawTriangle(v, attrs)
+	if pixels > 0 {
+		left, top, right, bottom := g.drawArea()
+		minX := max(min(v[0].x, v[1].x, v[2].x), left)
+		maxX := min(max(v[0].x, v[1].x, v[2].x), right)
+		minY := max(min(v[0].y, v[1].y, v[2].y), top)
+		maxY := min(max(v[0].y, v[1].y, v[2].y), bottom)
+
+		g.markDirty(minX, minY, maxX-minX+1, maxY-minY+1)
+	}
+
+	var positions [3]renderer.VRAMPos
+	var colors [3]renderer.Color
+	var coords [3]renderer.TexCoord
+
+	for i := range v {
+		positions[i] = renderer.NewVRAMPos(int16(v[i].x), int16(v[i].y))
+		colors[i] = v[i].color
+		coords[i] = renderer.TexCoord{U: v[i].u, V: v[i].v}
+	}
+
+	if attrs.textured {
+		g.renderer.PushTexturedTriangle(positions, colors, coords, attrs.texInfo())
+	} else {
+		g.renderer.PushTriangle(positions, colors)
+	}
+
+	return pixels
+}
+
+// vertexPos decode the signed 11-bit vertex coordinates of a GP0
+// vertex word and apply the drawing offset
+func (g *Gpu) vertexPos(val uint32) (x, y int32) {
+	x = int32(int16(val<<5) >> 5)
+	y = int32(int16((val>>16)<<5) >> 5)
+
+	return x + int32(g.drawXOffset), y + int32(g.drawYOffset)
+}
+
+// applyTexPage apply the texpage attribute from a textured
+// polygon. This updates the draw mode in GPUSTAT same as GP0(E1h)
+func (g *Gpu) applyTexPage(page uint32) {
+	stat := &g.gpuStat
+
+	stat.pageBaseX = uint8(page & 0xf)
+	stat.pageBaseY = uint8((page >> 4) & 1)
+	stat.semiTransparency = SemiTransparency((page >> 5) & 3)
+	stat.textureDepth = texDepthFromU32((page >> 7) & 3)
 }
 
-// gp0QuadBlendedOpaque GP0(2Ch) - Textured four-point polygon, opaque, texture-blending
-func (g *Gpu) gp0QuadBlendedOpaque() {
-	positions := [4]renderer.VRAMPos{
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(1)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(3)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(5)),
-		renderer.PosFromGP0(g.gp0CmdBuffer.at(7)),
+// texPageAttrs fill in the texture page and blending stuff of attrs
+// from the current draw mode
+func (g *Gpu) texPageAttrs(attrs *primAttrs) {
+	stat := &g.gpuStat
+
+	attrs.semiMode = stat.semiTransparency
+	attrs.texPageX = int32(stat.pageBaseX) * 64
+	attrs.texPageY = int32(stat.pageBaseY) * 256
+	attrs.texDepth = stat.textureDepth
+}
+
+// texInfo the texture page and CLUT of attrs for the renderer
+func (a *primAttrs) texInfo() renderer.TexInfo {
+	mode := uint16(a.texDepth) & renderer.TEX_DEPTH_MASK
+	if a.rawTexture {
+		mode |= renderer.TEX_RAW
 	}
 
-	// HACK - we don't support textures yet so use solid color instead
-	color := renderer.Color{R: 129, G: 11, B: 156}
-	colors := [4]renderer.Color{
-		color, color, color, color,
+	return renderer.TexInfo{
+		PageX: uint16(a.texPageX),
+		PageY: uint16(a.texPageY),
+		ClutX: uint16(a.clutX),
+		ClutY: uint16(a.clutY),
+		Mode:  mode,
 	}
+}
+
+// setRendererState set the blending and mask bit state of the renderer
+// for the next primitive
+func (g *Gpu) setRendererState(attrs *primAttrs) {
+	blend := renderer.BlendOpaque
+	if attrs.semiTransparent {
+		blend = attrs.semiMode.blendMode()
+	}
+
+	g.renderer.SetBlendMode(blend)
+	g.renderer.SetMaskBit(g.gpuStat.forceSetMaskBit, g.gpuStat.checkMaskBeforeDraw)
 
-	g.renderer.PushQuad(positions, colors)
+	left, top, right, bottom := g.drawArea()
+	g.renderer.SetDrawArea(left, top, right, bottom)
+	g.renderer.SetDither(attrs.dither)
+	g.renderer.SetTextureWindow(g.texWindowXMask, g.texWindowYMask, g.texWindowXOffset, g.texWindowYOffset)
 }
 
 //////////////////
@@ -190,10 +365,26 @@ type GP0Cmd struct {
 var gp0Commands map[uint32]GP0Cmd = map[uint32]GP0Cmd{
 	0x00: {0x00, 1, "NOP", func(g *Gpu, val uint32) { g.gp0Nop() }},
 	0x01: {0x01, 1, "Clear Cache", func(g *Gpu, val uint32) { g.gp0ClearCache() }},
-	0x28: {0x28, 5, "Monochrome four-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0MonoQuadPolyOpaque(val) }},
-	0x2c: {0x2c, 9, "Textured four-point polygon, opaque, texture-blending", func(g *Gpu, val uint32) { g.gp0QuadBlendedOpaque() }},
-	0x30: {0x30, 6, "Shaded three-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0TriShadedOpaque() }},
-	0x38: {0x38, 8, "Shaded four-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0QuadShadedOpaque() }},
+	0x20: {0x20, 4, "Monochrome three-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x22: {0x22, 4, "Monochrome three-point polygon, semi-transparent", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x24: {0x24, 7, "Textured three-point polygon, opaque, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x25: {0x25, 7, "Textured three-point polygon, opaque, raw-texture", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x26: {0x26, 7, "Textured three-point polygon, semi-transparent, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x27: {0x27, 7, "Textured three-point polygon, semi-transparent, raw-texture", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x28: {0x28, 5, "Monochrome four-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x2a: {0x2a, 5, "Monochrome four-point polygon, semi-transparent", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x2c: {0x2c, 9, "Textured four-point polygon, opaque, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x2d: {0x2d, 9, "Textured four-point polygon, opaque, raw-texture", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x2e: {0x2e, 9, "Textured four-point polygon, semi-transparent, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x2f: {0x2f, 9, "Textured four-point polygon, semi-transparent, raw-texture", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x30: {0x30, 6, "Shaded three-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x32: {0x32, 6, "Shaded three-point polygon, semi-transparent", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x34: {0x34, 9, "Shaded textured three-point polygon, opaque, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x36: {0x36, 9, "Shaded textured three-point polygon, semi-transparent, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x38: {0x38, 8, "Shaded four-point polygon, opaque", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x3a: {0x3a, 8, "Shaded four-point polygon, semi-transparent", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x3c: {0x3c, 12, "Shaded textured four-point polygon, opaque, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
+	0x3e: {0x3e, 12, "Shaded textured four-point polygon, semi-transparent, texture-blending", func(g *Gpu, val uint32) { g.gp0Polygon() }},
 	0xa0: {0xa0, 3, "GP0 Image Load", func(g *Gpu, val uint32) { g.gp0ImageLoad() }},
 	0xc0: {0xc0, 3, "Copy Rectangle (VRAM to CPU)/Image store", func(g *Gpu, val uint32) { g.gp0ImageStore() }},
 	0xe1: {0xe1, 1, "Draw Mode setting", func(g *Gpu, val uint32) { g.gp0DrawMode(val) }},
@@ -1,8 +1,11 @@
 package gpu
 
 import (
+	"image"
+
 	"github.com/TheOrnyx/psx-go/log"
 	"github.com/TheOrnyx/psx-go/renderer"
+	"github.com/TheOrnyx/psx-go/utils"
 )
 
 // The GPU Struct
@@ -22,33 +25,83 @@ type Gpu struct {
 	drawAreaTop           uint16 // Top-most line of drawing area
 	drawAreaRight         uint16 // Right-most column of drawing area
 	drawAreaBottom        uint16 // Bottom-most line of drawing area
-	// drawXOffset           int16  // Horizontal drawing offset applied to all the vertex
-	// drawYOffset           int16  // Vertical drawing offset applied to all the vertex :D
+	drawXOffset           int16  // Horizontal drawing offset applied to all the vertex
+	drawYOffset           int16  // Vertical drawing offset applied to all the vertex :D
 	displayVramXStart     uint16 // First column of the display area in VRAM
 	displayVramYStart     uint16 // First line of the display area in VRAM
 	displayHorizStart     uint16 // Display output horizontal start relative to HSYNC
 	displayHorizEnd       uint16 // Display output horizontal end relative to HSYNC
 	displayLineStart      uint16 // Display output first line relative to VSYNC
 	displayLineEnd        uint16 // Display output last line relative to VSYNC
+	allowTextureDisable   bool   // GP1(09h) allows GP0(E1h) to disable textures
+	vram2MB               bool   // GP1(09h) on the dev boards, 2MB of VRAM instead of 1MB
+	gpuRead               uint32 // Value of the GPUREAD register
 
 	gp0CmdBuffer      CommandBuffer // Buffer containing current GP0 command
 	gp0WordsRemaining uint32        // The remaining words for the current GP0 command
 	gp0Cmd            GP0Cmd        // the GPU command for holding the length, function etc
 	gp0Mode           GP0Mode       // The current mode of the GP0 register
+	gp0FIFO           CommandFIFO   // Words written to GP0 waiting to be run
+	busyCycles        int32         // Video cycles until the GPU is done with the last command
+	stallCycles       int32         // Video cycles GP0 writers have waited on a full FIFO since the last Tick
+	imgLoad           imageTransfer // State of the current GP0(A0h) image load
+	imgStore          imageTransfer // State of the current GP0(C0h) image store
+
+	vram        VRAM                                 // The VRAM the software rasterizer draws into
+	dirtyBlocks [DIRTY_BLOCKS_Y][DIRTY_BLOCKS_X]bool // VRAM blocks changed since the renderer's texture copy was updated
+	timing      videoTiming                          // Scanline timing state
+
+	renderer Backend // Where primitives and frames go, normally the OpenGL Renderer
+
+	recorder *CommandRecorder // Records everything going into the GPU when not nil
 
-	renderer *renderer.Renderer // The OpenGL Renderer
+	capture    *FrameCapture       // Frame being captured by the inspector
+	inspecting *InspectedPrimitive // Primitive being drawn while capturing
+
+	ShowVRAM    bool                // Present the VRAM viewer instead of the display area
+	VRAMView    VRAMViewMode        // How the VRAM viewer shows VRAM
+	LastCapture *FrameCapture       // Last frame captured by the inspector
+	Highlight   *InspectedPrimitive // Primitive highlighted in the VRAM viewer
 }
 
 // NewGPU create and return a new gpu
-func NewGPU(renderer *renderer.Renderer) Gpu {
-	g := Gpu{gpuStat: NewGPUStat(), gp0Mode: GP0ModeCommand, renderer: renderer}
+func NewGPU(backend Backend) Gpu {
+	g := Gpu{gpuStat: NewGPUStat(), gp0Mode: GP0ModeCommand, vram: NewVRAM(), renderer: backend}
+
+	// start with the same display ranges the BIOS gets after a reset
+	// so the timings make sense before it sets them
+	g.gp1Reset(0)
 
 	return g
 }
 
-// Display display
-func (g *Gpu) Display()  {
-	g.renderer.Display()
+// Display build the frame for the display area and present it, or
+// the VRAM viewer if that's turned on
+func (g *Gpu) Display() {
+	if g.recorder != nil {
+		g.recorder.frame()
+	}
+
+	g.finishCapture()
+
+	if g.ShowVRAM {
+		// not a display area so always shown as is
+		if g.Highlight != nil {
+			g.renderer.Present(g.HighlightImage(g.VRAMView, g.Highlight), renderer.DisplayArea{})
+		} else {
+			g.renderer.Present(g.VRAMImage(g.VRAMView), renderer.DisplayArea{})
+		}
+		return
+	}
+
+	// the frame is only built when the backend is going to use it
+	area := g.displayArea()
+	var frame *image.RGBA
+	if g.renderer.NeedsFrame(area) {
+		frame = g.buildFrame()
+	}
+
+	g.renderer.Present(frame, area)
 }
 
 // Status return the status register
@@ -56,8 +109,91 @@ func (g *Gpu) Status() uint32 {
 	return g.gpuStat.Status()
 }
 
-// GP0 handle writes to the GP0 command register
+// GP0 handle writes to the GP0 command register from the CPU
 func (g *Gpu) GP0(val uint32) {
+	if g.recorder != nil {
+		g.recorder.record(RecordGP0, val)
+	}
+
+	g.gp0Write(val)
+}
+
+// GP0DMA handle GP0 words coming from DMA. Same as a CPU write, they're
+// only kept apart so recordings can tell them apart
+func (g *Gpu) GP0DMA(val uint32) {
+	if g.recorder != nil {
+		g.recorder.record(RecordGP0DMA, val)
+	}
+
+	g.gp0Write(val)
+}
+
+// gp0Write put a GP0 word into the command FIFO, it gets run once the
+// GPU isn't busy anymore
+func (g *Gpu) gp0Write(val uint32) {
+	if g.gp0FIFO.full() {
+		// whoever is writing is stuck waiting for the GPU to finish
+		// what it's doing. Charge them for it, the busy time gets paid
+		// off when Tick runs for the stall, then make room
+		if wait := g.busyCycles - g.stallCycles; wait > 0 {
+			g.stallCycles += wait
+		}
+		g.gp0Exec(g.gp0FIFO.pop())
+	}
+
+	g.gp0FIFO.push(val)
+	g.processFIFO()
+}
+
+// processFIFO run words from the FIFO until it's empty or the GPU is busy
+func (g *Gpu) processFIFO() {
+	for g.busyCycles <= 0 && !g.gp0FIFO.empty() {
+		g.gp0Exec(g.gp0FIFO.pop())
+	}
+
+	if g.gp0FIFO.empty() && g.busyCycles < 0 {
+		// can't save up idle time for later
+		g.busyCycles = 0
+	}
+
+	g.updateReadyBits()
+}
+
+// Stall CPU cycles GP0 writers have been held up by a full FIFO since
+// the last Tick, the CPU or DMA should lose that much time
+func (g *Gpu) Stall() uint32 {
+	// video clock is 11/7 the CPU clock, round up
+	return (uint32(g.stallCycles)*7 + 10) / 11
+}
+
+// Sync run everything left in the FIFO straight away
+func (g *Gpu) Sync() {
+	for !g.gp0FIFO.empty() {
+		g.gp0Exec(g.gp0FIFO.pop())
+	}
+
+	g.busyCycles = 0
+	g.updateReadyBits()
+}
+
+// addBusyCycles mark the GPU as busy for cycles more video cycles
+func (g *Gpu) addBusyCycles(cycles int32) {
+	g.busyCycles += cycles
+}
+
+// updateReadyBits update the GPUSTAT ready bits from the FIFO state
+func (g *Gpu) updateReadyBits() {
+	stat := &g.gpuStat
+
+	stat.readyToRecvWord = g.busyCycles <= 0 && g.gp0FIFO.empty()
+	stat.readyToRecvDMA = !g.gp0FIFO.full()
+}
+
+// gp0Exec run a single GP0 word popped from the FIFO
+func (g *Gpu) gp0Exec(val uint32) {
+	// every word takes a cycle to get through
+	g.addBusyCycles(1)
+
 	if g.gp0WordsRemaining == 0 {
 		opcode := (val >> 24) & 0xff // top byte contains opcode
 
@@ -83,9 +219,14 @@ func (g *Gpu) GP0(val uint32) {
 		}
 
 	case GP0ModeImgLoad:
-		// TODO - should copy pixel data to vram
+		// each word holds two pixels
+		g.imageLoadPixel(uint16(val))
+		g.imageLoadPixel(uint16(val >> 16))
+
 		if g.gp0WordsRemaining == 0 {
 			g.gp0Mode = GP0ModeCommand
+			g.uploadVRAM(g.imgLoad.x, g.imgLoad.y, g.imgLoad.width, g.imgLoad.height)
+			g.markDirty(g.imgLoad.x, g.imgLoad.y, g.imgLoad.width, g.imgLoad.height)
 		}
 
 	default:
@@ -95,6 +236,10 @@ func (g *Gpu) GP0(val uint32) {
 
 // GP1 Handle writes to the GP1 command register
 func (g *Gpu) GP1(val uint32) {
+	if g.recorder != nil {
+		g.recorder.record(RecordGP1, val)
+	}
+
 	opcode := (val >> 24) & 0xff
 
 	switch opcode {
@@ -116,14 +261,19 @@ func (g *Gpu) GP1(val uint32) {
 		g.gp1SetVertDisplayRange(val)
 	case 0x08: // GP1 display mode
 		g.gp1DisplayMode(val)
+	case 0x09:
+		g.gp1AllowTextureDisable(val)
+	case 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
+		0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f:
+		g.gp1GetGPUInfo(val)
 	default:
-		log.Panicf("Unhandled GP1 command: 0x%08x, Opcode:0x%02x", val, opcode)
+		// the real GPU just ignores these
+		log.Warnf("Unhandled GP1 command: 0x%08x, Opcode:0x%02x", val, opcode)
 	}
 }
 
 // gp1Reset GP1(0x00): Soft reset of the GPU
 func (g *Gpu) gp1Reset(val uint32) {
-	// TODO - this should clear the command FIFO later
 	// TODO - this should invalidate GPU cache if it ever gets implemented
 	// TODO - check this, kinda winging it based on the stuff
 
@@ -148,10 +298,20 @@ func (g *Gpu) gp1Reset(val uint32) {
 	g.gp1ResetCmdBuffer()
 }
 
-// Read retrieve the value of the read register
+// Read retrieve the value of the read register. During a GP0(C0h)
+// image store every read returns the next two pixels
 func (g *Gpu) Read() uint32 {
-	log.Info("Not implemented GPUREAD yet")
-	return 0
+	if g.gpuStat.readyToSendVram {
+		lo := g.imageStorePixel()
+		hi := g.imageStorePixel()
+		g.gpuRead = uint32(lo) | uint32(hi)<<16
+	}
+
+	if g.recorder != nil {
+		g.recorder.record(RecordRead, g.gpuRead)
+	}
+
+	return g.gpuRead
 }
 
 // gp1DisplayMode GP1(08h) - Display mode
@@ -171,15 +331,53 @@ func (g *Gpu) gp1DisplayMode(val uint32) {
 		stat.videoMode = Pal
 	}
 
-	stat.displayDepth = D24Bit
+	stat.displayDepth = D15Bit
 	if val&0x10 != 0 {
-		stat.displayDepth = D15Bit
+		stat.displayDepth = D24Bit
 	}
 
 	stat.verticalInterlace = val&0x20 != 0
 
-	if val&0x80 != 0 { // flipped
-		log.Panicf("Horizontal flip not implemented yet!")
+	// NOTE - this "flips" the screen on real hardware but what it
+	// really does is distort it, nothing uses it so we just report it
+	stat.reverseFlag = val&0x80 != 0
+}
+
+// gp1AllowTextureDisable GP1(09h) - Allow texture disable. On the
+// retail GPU this lets GP0(E1h) bit 11 disable textures, on the dev
+// boards the same bit is the VRAM size (1MB or 2MB) instead. Both get
+// kept, we only ever have 1MB but the size can be read back with
+// GP1(10h)
+func (g *Gpu) gp1AllowTextureDisable(val uint32) {
+	g.allowTextureDisable = val&1 != 0
+	g.vram2MB = val&1 != 0
+}
+
+// gp1GetGPUInfo GP1(10h-1Fh) - Get GPU info, the result is put into GPUREAD
+func (g *Gpu) gp1GetGPUInfo(val uint32) {
+	// only the low 3 bits matter, 8-F mirror 0-7
+	switch val & 0x7 {
+	case 0: // VRAM size from GP1(09h), 1 for 2MB
+		// NOTE - retail GPUs return nothing here, this is for tools
+		// poking at the dev board setting
+		g.gpuRead = utils.BoolToUint32(g.vram2MB)
+	case 2: // texture window
+		g.gpuRead = uint32(g.texWindowXMask) |
+			uint32(g.texWindowYMask)<<5 |
+			uint32(g.texWindowXOffset)<<10 |
+			uint32(g.texWindowYOffset)<<15
+	case 3: // draw area top left
+		g.gpuRead = uint32(g.drawAreaLeft) | uint32(g.drawAreaTop)<<10
+	case 4: // draw area bottom right
+		g.gpuRead = uint32(g.drawAreaRight) | uint32(g.drawAreaBottom)<<10
+	case 5: // draw offset
+		x := uint32(g.drawXOffset) & 0x7ff
+		y := uint32(g.drawYOffset) & 0x7ff
+		g.gpuRead = x | y<<11
+	case 7: // GPU version
+		g.gpuRead = 2
+	default:
+		// nothing, GPUREAD keeps the old value
 	}
 }
 
@@ -232,10 +430,16 @@ func (g *Gpu) gp1ResetCmdBuffer() {
 	g.gp0CmdBuffer.clear()
 	g.gp0WordsRemaining = 0
 	g.gp0Mode = GP0ModeCommand
-	// TODO - should clear command FIFO when implemented
+	g.gp0FIFO.clear()
+	g.busyCycles = 0
+	g.updateReadyBits()
 }
 
 // Quit quit gpu and do cleanup
 func (g *Gpu) Quit()  {
+	if err := g.StopRecording(); err != nil {
+		log.Warnf("Failed to save GPU recording: %v", err)
+	}
+
 	g.renderer.Quit()
 }
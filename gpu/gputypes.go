@@ -1,7 +1,9 @@
 package gpu
 
 import (
-	"github.com/TheOrnyx/psx-go/log"
+	"fmt"
+
+	"github.com/TheOrnyx/psx-go/renderer"
 )
 
 // NOTE - i kinda just made this it's own file cuz I can't be bothered
@@ -16,20 +18,29 @@ const (
 	T15Bit TextureDepth = 2
 )
 
-// texDepthFromU32 get texturedepth value from uint32
+// String return the name of the texture depth
+func (t TextureDepth) String() string {
+	switch t {
+	case T4Bit:
+		return "4bpp"
+	case T8Bit:
+		return "8bpp"
+	default:
+		return "15-bit"
+	}
+}
+
+// texDepthFromU32 get texturedepth value from uint32, 3 is reserved
+// but the hardware treats it as 15-bit
 func texDepthFromU32(val uint32) TextureDepth {
 	switch val {
 	case 0:
 		return T4Bit
 	case 1:
 		return T8Bit
-	case 2:
-		return T15Bit
 	default:
-		log.Panicf("Failed to decode textureDepth from uint32, val:%v", val)
+		return T15Bit
 	}
-
-	return 0 // shouldn't happen
 }
 
 type DisplayDepth uint8
@@ -89,3 +100,123 @@ const (
 	GP0ModeCommand GP0Mode = 0 // Default mode: Handling commands
 	GP0ModeImgLoad GP0Mode = 1 // Loading image into VRAM
 )
+
+// Semi-transparency mode, B is the pixel already in VRAM and F is the
+// one being drawn
+type SemiTransparency uint8
+
+const (
+	SemiHalf    SemiTransparency = 0 // B/2 + F/2
+	SemiAdd     SemiTransparency = 1 // B + F
+	SemiSub     SemiTransparency = 2 // B - F
+	SemiQuarter SemiTransparency = 3 // B + F/4
+)
+
+// blend blend the 15-bit front pixel onto the back one. The mask bit
+// isn't touched so the caller has to sort that out
+func (s SemiTransparency) blend(back, front uint16) uint16 {
+	var res uint16
+
+	for shift := 0; shift < 15; shift += 5 {
+		b := int32(back>>shift) & 0x1f
+		f := int32(front>>shift) & 0x1f
+
+		var c int32
+		switch s {
+		case SemiHalf:
+			c = (b + f) >> 1
+		case SemiAdd:
+			c = b + f
+		case SemiSub:
+			c = b - f
+		case SemiQuarter:
+			c = b + (f >> 2)
+		}
+
+		c = min(max(c, 0), 0x1f)
+		res |= uint16(c) << shift
+	}
+
+	return res
+}
+
+// String return the formula of the semi transparency mode
+func (s SemiTransparency) String() string {
+	switch s {
+	case SemiHalf:
+		return "B/2+F/2"
+	case SemiAdd:
+		return "B+F"
+	case SemiSub:
+		return "B-F"
+	default:
+		return "B+F/4"
+	}
+}
+
+// blendMode return the renderer blend mode for this semi transparency mode
+func (s SemiTransparency) blendMode() renderer.BlendMode {
+	switch s {
+	case SemiHalf:
+		return renderer.BlendHalf
+	case SemiAdd:
+		return renderer.BlendAdd
+	case SemiSub:
+		return renderer.BlendSub
+	default:
+		return renderer.BlendQuarter
+	}
+}
+
+// How the VRAM viewer interprets VRAM
+type VRAMViewMode uint8
+
+// VRAM viewer mode constants
+const (
+	View15Bit VRAMViewMode = 0 // 15-bit color pixels
+	View4Bit  VRAMViewMode = 1 // 4 bit CLUT indices, 4 per VRAM pixel
+	View8Bit  VRAMViewMode = 2 // 8 bit CLUT indices, 2 per VRAM pixel
+)
+
+// String return the name of the view mode
+func (m VRAMViewMode) String() string {
+	switch m {
+	case View4Bit:
+		return "4bpp"
+	case View8Bit:
+		return "8bpp"
+	default:
+		return "15-bit"
+	}
+}
+
+// ParseVRAMViewMode parse a view mode from its bits per pixel, "15", "4" or "8"
+func ParseVRAMViewMode(s string) (VRAMViewMode, error) {
+	switch s {
+	case "15":
+		return View15Bit, nil
+	case "4":
+		return View4Bit, nil
+	case "8":
+		return View8Bit, nil
+	default:
+		return View15Bit, fmt.Errorf("Unknown VRAM view mode %q, expected 15, 4 or 8", s)
+	}
+}
+
+// Next return the view mode after this one, wrapping back to 15-bit
+func (m VRAMViewMode) Next() VRAMViewMode {
+	return (m + 1) % 3
+}
+
+// texelsPerPixel the number of texels one VRAM pixel holds in this mode
+func (m VRAMViewMode) texelsPerPixel() int32 {
+	switch m {
+	case View4Bit:
+		return 4
+	case View8Bit:
+		return 2
+	default:
+		return 1
+	}
+}
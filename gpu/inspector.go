@@ -0,0 +1,188 @@
+package gpu
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/TheOrnyx/psx-go/renderer"
+)
+
+// Frame inspector, captures every primitive drawn during a frame along
+// with the state it was drawn with and which pixels it touched
+
+var highlightColor = color.RGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff} // pixels of the selected primitive
+
+// A vertex of an inspected primitive
+type InspectedVertex struct {
+	X, Y  int32          // position in VRAM, draw offset applied
+	Color renderer.Color // vertex color
+	U, V  uint8          // texture coordinates
+}
+
+// The draw state a primitive was drawn with
+type DrawState struct {
+	DrawArea       [4]int32 // left, top, right, bottom (inclusive)
+	DrawXOffset    int16    // horizontal drawing offset
+	DrawYOffset    int16    // vertical drawing offset
+	TexWindow      [4]uint8 // x mask, y mask, x offset, y offset (8 pixel steps)
+	Dithering      bool     // GPUSTAT dither bit
+	ForceMaskBit   bool     // set the mask bit of drawn pixels
+	CheckMaskBit   bool     // don't draw over masked pixels
+	TextureDisable bool     // textures disabled by GP0(E1h)
+}
+
+// A primitive captured by the inspector
+type InspectedPrimitive struct {
+	Index           int               // position in the frame
+	Opcode          uint32            // GP0 opcode
+	Name            string            // name of the command from gp0Commands
+	Words           []uint32          // the command words
+	Vertices        []InspectedVertex // vertices in the order they were sent
+	Shaded          bool              // gouraud shaded
+	Textured        bool              // texture mapped
+	RawTexture      bool              // texture isn't blended with the color
+	SemiTransparent bool              // semi transparent
+	Blend           SemiTransparency  // semi transparency mode
+	TexPageX        int32             // texture page x in VRAM pixels
+	TexPageY        int32             // texture page y in VRAM lines
+	TexDepth        TextureDepth      // texture page color depth
+	ClutX           int32             // CLUT x in VRAM pixels
+	ClutY           int32             // CLUT y in VRAM lines
+	Dithered        bool              // dither matrix was applied
+	State           DrawState         // draw state at the time
+	Pixels          []image.Point     // VRAM pixels it was drawn to
+}
+
+// All the primitives drawn during one frame
+type FrameCapture struct {
+	Primitives []InspectedPrimitive
+}
+
+// CaptureFrame capture every primitive drawn from now until the next
+// frame gets presented, it ends up in LastCapture after that. Call it
+// right after a frame for a full one
+func (g *Gpu) CaptureFrame() {
+	g.capture = &FrameCapture{}
+}
+
+// finishCapture called every frame, finishes the capture if there's
+// one running
+func (g *Gpu) finishCapture() {
+	if g.capture != nil {
+		g.LastCapture = g.capture
+		g.capture = nil
+	}
+}
+
+// inspectPrimitive start capturing a primitive that's about to be drawn
+func (g *Gpu) inspectPrimitive(vertices []vertex, attrs *primAttrs) {
+	if g.capture == nil {
+		return
+	}
+
+	opcode := g.gp0CmdBuffer.at(0) >> 24
+	left, top, right, bottom := g.drawArea()
+
+	p := InspectedPrimitive{
+		Index:           len(g.capture.Primitives),
+		Opcode:          opcode,
+		Name:            g.gp0Cmd.name,
+		Words:           make([]uint32, g.gp0CmdBuffer.length),
+		Shaded:          attrs.shaded,
+		Textured:        attrs.textured,
+		RawTexture:      attrs.rawTexture,
+		SemiTransparent: attrs.semiTransparent,
+		Blend:           attrs.semiMode,
+		TexPageX:        attrs.texPageX,
+		TexPageY:        attrs.texPageY,
+		TexDepth:        attrs.texDepth,
+		ClutX:           attrs.clutX,
+		ClutY:           attrs.clutY,
+		Dithered:        attrs.dither,
+		State: DrawState{
+			DrawArea:       [4]int32{left, top, right, bottom},
+			DrawXOffset:    g.drawXOffset,
+			DrawYOffset:    g.drawYOffset,
+			TexWindow:      [4]uint8{g.texWindowXMask, g.texWindowYMask, g.texWindowXOffset, g.texWindowYOffset},
+			Dithering:      g.gpuStat.dithering,
+			ForceMaskBit:   g.gpuStat.forceSetMaskBit,
+			CheckMaskBit:   g.gpuStat.checkMaskBeforeDraw,
+			TextureDisable: g.gpuStat.textureDisable,
+		},
+	}
+
+	for i := range p.Words {
+		p.Words[i] = g.gp0CmdBuffer.at(uint8(i))
+	}
+
+	for _, v := range vertices {
+		p.Vertices = append(p.Vertices, InspectedVertex{X: v.x, Y: v.y, Color: v.color, U: v.u, V: v.v})
+	}
+
+	g.capture.Primitives = append(g.capture.Primitives, p)
+	g.inspecting = &g.capture.Primitives[len(g.capture.Primitives)-1]
+}
+
+// endInspectPrimitive done drawing the primitive being captured
+func (g *Gpu) endInspectPrimitive() {
+	g.inspecting = nil
+}
+
+// String describe the primitive over a few lines
+func (p *InspectedPrimitive) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "#%d GP0(%02xh) %s, %d pixels\n", p.Index, p.Opcode, p.Name, len(p.Pixels))
+	for i, v := range p.Vertices {
+		fmt.Fprintf(&b, "  v%d: pos %d,%d color %d,%d,%d", i, v.X, v.Y, v.Color.R, v.Color.G, v.Color.B)
+		if p.Textured {
+			fmt.Fprintf(&b, " uv %d,%d", v.U, v.V)
+		}
+		b.WriteString("\n")
+	}
+
+	if p.Textured {
+		fmt.Fprintf(&b, "  texpage %d,%d depth %v clut %d,%d raw %v\n",
+			p.TexPageX, p.TexPageY, p.TexDepth, p.ClutX, p.ClutY, p.RawTexture)
+	}
+
+	blend := "opaque"
+	if p.SemiTransparent {
+		blend = p.Blend.String()
+	}
+	fmt.Fprintf(&b, "  blend %s dithered %v\n", blend, p.Dithered)
+
+	s := &p.State
+	fmt.Fprintf(&b, "  draw area %d,%d-%d,%d offset %d,%d texwindow mask %d,%d offset %d,%d\n",
+		s.DrawArea[0], s.DrawArea[1], s.DrawArea[2], s.DrawArea[3], s.DrawXOffset, s.DrawYOffset,
+		s.TexWindow[0], s.TexWindow[1], s.TexWindow[2], s.TexWindow[3])
+	fmt.Fprintf(&b, "  dither %v mask force %v check %v texture disable %v\n",
+		s.Dithering, s.ForceMaskBit, s.CheckMaskBit, s.TextureDisable)
+
+	return b.String()
+}
+
+// HighlightImage return a VRAMImage with the pixels of p highlighted
+func (g *Gpu) HighlightImage(mode VRAMViewMode, p *InspectedPrimitive) *image.RGBA {
+	img := g.VRAMImage(mode)
+	scale := int(mode.texelsPerPixel())
+
+	for _, pt := range p.Pixels {
+		for i := range scale {
+			x := pt.X*scale + i
+			c := img.RGBAAt(x, pt.Y)
+
+			// mix with the highlight so the pixel can still be seen
+			img.SetRGBA(x, pt.Y, color.RGBA{
+				R: uint8((uint16(c.R) + uint16(highlightColor.R)) / 2),
+				G: uint8((uint16(c.G) + uint16(highlightColor.G)) / 2),
+				B: uint8((uint16(c.B) + uint16(highlightColor.B)) / 2),
+				A: 0xff,
+			})
+		}
+	}
+
+	return img
+}
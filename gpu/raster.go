@@ -0,0 +1,269 @@
+package gpu
+
+import (
+	"image"
+
+	"github.com/TheOrnyx/psx-go/renderer"
+)
+
+// Software rasterizer that draws straight into VRAM. Every primitive
+// goes through here as well as the GL renderer, this is what GPUREAD
+// reads and what the renderer's textures get copied from since we
+// can't really get pixels back out of the GL renderer
+
+// A vertex as the rasterizer sees it
+type vertex struct {
+	x, y  int32          // position in VRAM (draw offset already applied)
+	color renderer.Color // vertex color
+	u, v  uint8          // texture coordinates
+}
+
+// Attributes that apply to a whole primitive
+type primAttrs struct {
+	shaded          bool             // gouraud shaded, otherwise the first vertex color is used
+	textured        bool             // texture mapped
+	rawTexture      bool             // use texture as is without blending with the vertex color
+	semiTransparent bool             // primitive is semi transparent
+	semiMode        SemiTransparency // blending mode used when semi transparent
+	texPageX        int32            // texture page x base in VRAM pixels
+	texPageY        int32            // texture page y base in VRAM lines
+	texDepth        TextureDepth     // texture page color depth
+	clutX           int32            // CLUT x position in VRAM pixels
+	clutY           int32            // CLUT y position in VRAM lines
+	dither          bool             // apply the dither matrix when reducing to 15-bit
+}
+
+// The 4x4 ordered dither matrix, indexed by the low 2 bits of the
+// pixel's y then x. Added to the 8-bit color components before they get
+// truncated to 5 bits
+var ditherMatrix = [4][4]int32{
+	{-4, +0, -3, +1},
+	{+2, -2, +3, -1},
+	{-3, +1, -4, +0},
+	{+3, -1, +2, -2},
+}
+
+// edge edge function for the edge a->b at point x, y. Positive when the
+// point is on the inside of an edge of a triangle with positive area
+func edge(a, b vertex, x, y int32) int64 {
+	return int64(b.x-a.x)*int64(y-a.y) - int64(b.y-a.y)*int64(x-a.x)
+}
+
+// edgeBias bias for the top-left fill rule, pixels lying exactly on a
+// right or bottom edge don't get drawn
+func edgeBias(a, b vertex) int64 {
+	dx := b.x - a.x
+	dy := b.y - a.y
+
+	if dy < 0 || (dy == 0 && dx > 0) {
+		return 0 // top or left edge
+	}
+
+	return -1
+}
+
+// drawArea return the drawing area as inclusive VRAM coordinates,
+// nothing gets drawn outside of it
+func (g *Gpu) drawArea() (left, top, right, bottom int32) {
+	left = int32(g.drawAreaLeft)
+	top = int32(g.drawAreaTop)
+	right = min(int32(g.drawAreaRight), VRAM_WIDTH-1)
+	bottom = min(int32(g.drawAreaBottom), VRAM_HEIGHT-1)
+
+	return left, top, right, bottom
+}
+
+// triangleTooBig whether a triangle is too big for the GPU to draw. The
+// hardware skips any primitive spanning more than 1023 pixels across or
+// 511 lines down
+func triangleTooBig(v [3]vertex) bool {
+	width := max(v[0].x, v[1].x, v[2].x) - min(v[0].x, v[1].x, v[2].x)
+	height := max(v[0].y, v[1].y, v[2].y) - min(v[0].y, v[1].y, v[2].y)
+
+	return width > 1023 || height > 511
+}
+
+// drawTriangle rasterize a triangle into VRAM clipped to the drawing
+// area, returns the number of pixels it covered
+func (g *Gpu) drawTriangle(v [3]vertex, attrs *primAttrs) int32 {
+	area := edge(v[0], v[1], v[2].x, v[2].y)
+	if area == 0 {
+		return 0 // degenerate, nothing to draw
+	}
+
+	if area < 0 {
+		// keep the winding the same for every triangle so the edge
+		// tests always have the same sign
+		v[1], v[2] = v[2], v[1]
+		area = -area
+	}
+
+	left, top, right, bottom := g.drawArea()
+
+	minX := max(min(v[0].x, v[1].x, v[2].x), left)
+	maxX := min(max(v[0].x, v[1].x, v[2].x), right)
+	minY := max(min(v[0].y, v[1].y, v[2].y), top)
+	maxY := min(max(v[0].y, v[1].y, v[2].y), bottom)
+
+	bias0 := edgeBias(v[1], v[2])
+	bias1 := edgeBias(v[2], v[0])
+	bias2 := edgeBias(v[0], v[1])
+	pixels := int32(0)
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			w0 := edge(v[1], v[2], x, y)
+			w1 := edge(v[2], v[0], x, y)
+			w2 := edge(v[0], v[1], x, y)
+
+			if w0+bias0 < 0 || w1+bias1 < 0 || w2+bias2 < 0 {
+				continue // outside
+			}
+
+			pixels += 1
+
+			color := v[0].color
+			if attrs.shaded {
+				color = renderer.Color{
+					R: uint8(interpolate(w0, w1, w2, area, int64(v[0].color.R), int64(v[1].color.R), int64(v[2].color.R))),
+					G: uint8(interpolate(w0, w1, w2, area, int64(v[0].color.G), int64(v[1].color.G), int64(v[2].color.G))),
+					B: uint8(interpolate(w0, w1, w2, area, int64(v[0].color.B), int64(v[1].color.B), int64(v[2].color.B))),
+				}
+			}
+
+			dither := int32(0)
+			if attrs.dither {
+				dither = ditherMatrix[y&3][x&3]
+			}
+
+			if !attrs.textured {
+				g.plotPixel(x, y, ditherTo15(color, dither), attrs.semiTransparent, attrs.semiMode)
+				continue
+			}
+
+			u := uint8(interpolate(w0, w1, w2, area, int64(v[0].u), int64(v[1].u), int64(v[2].u)))
+			tv := uint8(interpolate(w0, w1, w2, area, int64(v[0].v), int64(v[1].v), int64(v[2].v)))
+
+			texel := g.sampleTexture(u, tv, attrs)
+			if texel == 0 {
+				continue // fully transparent texel
+			}
+
+			if !attrs.rawTexture {
+				texel = modulateTexel(texel, color, dither)
+			}
+
+			// only texels with the mask bit set are semi transparent
+			semi := attrs.semiTransparent && texel&0x8000 != 0
+			g.plotPixel(x, y, texel, semi, attrs.semiMode)
+		}
+	}
+
+	return pixels
+}
+
+// drawCost rough number of GPU cycles it takes to draw pixels pixels
+// of a primitive. Texturing and blending both need extra VRAM reads
+func drawCost(pixels int32, attrs *primAttrs) int32 {
+	cost := pixels
+	if attrs.textured {
+		cost += pixels
+	}
+
+	if attrs.semiTransparent || attrs.shaded {
+		cost += pixels / 2
+	}
+
+	return cost
+}
+
+// interpolate interpolate a vertex attribute using the barycentric
+// weights of the pixel
+func interpolate(w0, w1, w2, area, a0, a1, a2 int64) int64 {
+	return (w0*a0 + w1*a1 + w2*a2) / area
+}
+
+// sampleTexture fetch the texel at u, v from the primitive's texture
+// page with the texture window applied
+func (g *Gpu) sampleTexture(u, v uint8, attrs *primAttrs) uint16 {
+	u = (u &^ (g.texWindowXMask * 8)) | ((g.texWindowXOffset & g.texWindowXMask) * 8)
+	v = (v &^ (g.texWindowYMask * 8)) | ((g.texWindowYOffset & g.texWindowYMask) * 8)
+
+	y := attrs.texPageY + int32(v)
+
+	switch attrs.texDepth {
+	case T4Bit:
+		word := g.vram.get(attrs.texPageX+int32(u/4), y)
+		index := (word >> ((u & 3) * 4)) & 0xf
+		return g.vram.get(attrs.clutX+int32(index), attrs.clutY)
+
+	case T8Bit:
+		word := g.vram.get(attrs.texPageX+int32(u/2), y)
+		index := (word >> ((u & 1) * 8)) & 0xff
+		return g.vram.get(attrs.clutX+int32(index), attrs.clutY)
+
+	default:
+		return g.vram.get(attrs.texPageX+int32(u), y)
+	}
+}
+
+// modulateTexel blend texel with the vertex color. A color component
+// of 0x80 leaves the texel untouched. The product is worked out at
+// 8-bit precision so it can be dithered before going back to 5 bits
+func modulateTexel(texel uint16, c renderer.Color, dither int32) uint16 {
+	res := texel & 0x8000
+	comps := [3]uint8{c.R, c.G, c.B}
+
+	for i, shift := range [3]int{0, 5, 10} {
+		t := int32(texel>>shift) & 0x1f
+		m := (t << 3) * int32(comps[i]) >> 7
+		res |= reduceComponent(m+dither) << shift
+	}
+
+	return res
+}
+
+// ditherTo15 convert a 24-bit color to a 15-bit VRAM pixel, adding
+// dither to each component first
+func ditherTo15(c renderer.Color, dither int32) uint16 {
+	if dither == 0 {
+		return colorTo15(c)
+	}
+
+	r := reduceComponent(int32(c.R) + dither)
+	g := reduceComponent(int32(c.G) + dither)
+	b := reduceComponent(int32(c.B) + dither)
+
+	return r | (g << 5) | (b << 10)
+}
+
+// reduceComponent clamp an 8-bit color component and truncate it to
+// 5 bits like the hardware does
+func reduceComponent(c int32) uint16 {
+	return uint16(min(max(c, 0), 0xff) >> 3)
+}
+
+// plotPixel write a single pixel into VRAM. Takes care of the
+// semi transparency and the mask bit settings
+func (g *Gpu) plotPixel(x, y int32, pixel uint16, semi bool, mode SemiTransparency) {
+	stat := &g.gpuStat
+	back := g.vram.get(x, y)
+
+	if stat.checkMaskBeforeDraw && back&0x8000 != 0 {
+		return // masked pixel, leave it alone
+	}
+
+	if semi {
+		pixel = mode.blend(back, pixel) | (pixel & 0x8000)
+	}
+
+	if stat.forceSetMaskBit {
+		pixel |= 0x8000
+	}
+
+	if g.inspecting != nil {
+		g.inspecting.Pixels = append(g.inspecting.Pixels, image.Pt(int(x), int(y)))
+	}
+
+	g.vram.set(x, y, pixel)
+}
@@ -0,0 +1,154 @@
+package gpu
+
+import (
+	"testing"
+
+	"github.com/TheOrnyx/psx-go/renderer"
+)
+
+// Tests for the per pixel parts of the software rasterizer, these are
+// what GPUREAD and the renderer's textures end up seeing
+
+// rgb15 build a 15-bit VRAM pixel out of 5-bit components
+func rgb15(r, g, b uint16) uint16 {
+	return r | (g << 5) | (b << 10)
+}
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        SemiTransparency
+		back, front uint16
+		want        uint16
+	}{
+		{"half white over black", SemiHalf, 0, 0x7fff, rgb15(0xf, 0xf, 0xf)},
+		{"half", SemiHalf, rgb15(10, 20, 30), rgb15(20, 10, 0), rgb15(15, 15, 15)},
+		{"add", SemiAdd, rgb15(1, 2, 3), rgb15(4, 5, 6), rgb15(5, 7, 9)},
+		{"add clamps at 31", SemiAdd, rgb15(20, 31, 16), rgb15(20, 1, 15), rgb15(31, 31, 31)},
+		{"sub", SemiSub, rgb15(10, 20, 30), rgb15(5, 5, 5), rgb15(5, 15, 25)},
+		{"sub clamps at 0", SemiSub, rgb15(5, 0, 31), rgb15(10, 31, 0), rgb15(0, 0, 31)},
+		{"quarter", SemiQuarter, rgb15(4, 4, 4), rgb15(8, 31, 3), rgb15(6, 11, 4)},
+		{"quarter clamps at 31", SemiQuarter, rgb15(30, 0, 0), rgb15(31, 0, 0), rgb15(31, 0, 0)},
+		{"mask bits ignored", SemiAdd, 0x8000, 0x8000, 0},
+	}
+
+	for _, test := range tests {
+		if got := test.mode.blend(test.back, test.front); got != test.want {
+			t.Errorf("%s: %v blend(0x%04x, 0x%04x) = 0x%04x, want 0x%04x",
+				test.name, test.mode, test.back, test.front, got, test.want)
+		}
+	}
+}
+
+func TestPlotPixel(t *testing.T) {
+	tests := []struct {
+		name      string
+		forceMask bool
+		checkMask bool
+		semi      bool
+		back      uint16
+		pixel     uint16
+		want      uint16
+	}{
+		{"plain", false, false, false, 0x1234, 0x0421, 0x0421},
+		{"masked pixel drawn over without check", false, false, false, 0x9234, 0x0421, 0x0421},
+		{"masked pixel kept with check", false, true, false, 0x9234, 0x0421, 0x9234},
+		{"unmasked pixel drawn with check", false, true, false, 0x1234, 0x0421, 0x0421},
+		{"force sets the mask bit", true, false, false, 0x1234, 0x0421, 0x8421},
+		{"force still respects check", true, true, false, 0x8000, 0x0421, 0x8000},
+		{"front mask bit kept", false, false, false, 0, 0x8421, 0x8421},
+		{"semi keeps front mask bit", false, false, true, 0, 0xffff, 0x8000 | rgb15(0xf, 0xf, 0xf)},
+		{"semi drops back mask bit", false, false, true, 0x8000, 0x7fff, rgb15(0xf, 0xf, 0xf)},
+	}
+
+	for _, test := range tests {
+		g := &Gpu{vram: NewVRAM()}
+		g.gpuStat.forceSetMaskBit = test.forceMask
+		g.gpuStat.checkMaskBeforeDraw = test.checkMask
+		g.vram.set(3, 4, test.back)
+
+		g.plotPixel(3, 4, test.pixel, test.semi, SemiHalf)
+
+		if got := g.vram.get(3, 4); got != test.want {
+			t.Errorf("%s: got 0x%04x, want 0x%04x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestModulateTexel(t *testing.T) {
+	tests := []struct {
+		name   string
+		texel  uint16
+		color  renderer.Color
+		dither int32
+		want   uint16
+	}{
+		{"0x80 is identity", rgb15(1, 17, 31), renderer.Color{R: 0x80, G: 0x80, B: 0x80}, 0, rgb15(1, 17, 31)},
+		{"0x80 keeps mask bit", 0x8000 | rgb15(5, 6, 7), renderer.Color{R: 0x80, G: 0x80, B: 0x80}, 0, 0x8000 | rgb15(5, 6, 7)},
+		{"0x40 halves", rgb15(8, 16, 30), renderer.Color{R: 0x40, G: 0x40, B: 0x40}, 0, rgb15(4, 8, 15)},
+		{"0xff doubles and clamps", rgb15(4, 16, 31), renderer.Color{R: 0xff, G: 0xff, B: 0xff}, 0, rgb15(7, 31, 31)},
+		{"black", rgb15(31, 31, 31), renderer.Color{}, 0, 0},
+		{"dither pushes down", rgb15(2, 2, 2), renderer.Color{R: 0x80, G: 0x80, B: 0x80}, -4, rgb15(1, 1, 1)},
+		{"dither clamps at 0", rgb15(0, 0, 0), renderer.Color{R: 0x80, G: 0x80, B: 0x80}, -4, 0},
+	}
+
+	for _, test := range tests {
+		if got := modulateTexel(test.texel, test.color, test.dither); got != test.want {
+			t.Errorf("%s: modulateTexel(0x%04x, %v, %d) = 0x%04x, want 0x%04x",
+				test.name, test.texel, test.color, test.dither, got, test.want)
+		}
+	}
+}
+
+func TestDitherTo15(t *testing.T) {
+	tests := []struct {
+		name   string
+		color  renderer.Color
+		dither int32
+		want   uint16
+	}{
+		{"no dither truncates", renderer.Color{R: 0x17, G: 0x0f, B: 0xff}, 0, rgb15(2, 1, 31)},
+		{"positive", renderer.Color{R: 0x15, G: 0x15, B: 0x15}, 3, rgb15(3, 3, 3)},
+		{"negative", renderer.Color{R: 0x10, G: 0x10, B: 0x10}, -4, rgb15(1, 1, 1)},
+		{"clamps at 0", renderer.Color{R: 0x02, G: 0x00, B: 0x03}, -4, 0},
+		{"clamps at 0xff", renderer.Color{R: 0xff, G: 0xfe, B: 0xfd}, 3, rgb15(31, 31, 31)},
+	}
+
+	for _, test := range tests {
+		if got := ditherTo15(test.color, test.dither); got != test.want {
+			t.Errorf("%s: ditherTo15(%v, %d) = 0x%04x, want 0x%04x",
+				test.name, test.color, test.dither, got, test.want)
+		}
+	}
+}
+
+func TestDitherMatrix(t *testing.T) {
+	// the offsets for each pixel of a 4x4 block, rows are y
+	want := [4][4]int32{
+		{-4, +0, -3, +1},
+		{+2, -2, +3, -1},
+		{-3, +1, -4, +0},
+		{+3, -1, +2, -2},
+	}
+
+	// 0x17 is one below a 5-bit step so the positive offsets take it
+	// up to 3 and the rest leave it at 2
+	c := renderer.Color{R: 0x17, G: 0x17, B: 0x17}
+
+	for y := int32(0); y < 8; y++ {
+		for x := int32(0); x < 8; x++ {
+			d := ditherMatrix[y&3][x&3]
+			if d != want[y&3][x&3] {
+				t.Fatalf("ditherMatrix at %d,%d = %d, want %d", x, y, d, want[y&3][x&3])
+			}
+
+			wantComp := uint16(2)
+			if d > 0 {
+				wantComp = 3
+			}
+			if got := ditherTo15(c, d); got != rgb15(wantComp, wantComp, wantComp) {
+				t.Errorf("ditherTo15 at %d,%d = 0x%04x, want component %d", x, y, got, wantComp)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package gpu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Recording of everything that goes into the GPU so it can be played
+// back into a GPU on its own, no CPU or BIOS needed. Handy for
+// reproducing rendering bugs.
+//
+// A recording starts with RECORDING_MAGIC followed by 5 byte entries,
+// a RecordKind byte then a little endian uint32 value
+
+const RECORDING_MAGIC = "PSXGPUR1"
+
+// Most cycles a single tick entry holds, keeps replayed ticks from
+// overflowing the timing maths
+const MAX_TICK_CYCLES = 0x100000
+
+// What a recording entry is
+type RecordKind uint8
+
+// Recording entry kinds
+const (
+	RecordGP0    RecordKind = 0 // word written to GP0 by the CPU
+	RecordGP0DMA RecordKind = 1 // word fed to GP0 by DMA
+	RecordGP1    RecordKind = 2 // word written to GP1
+	RecordRead   RecordKind = 3 // GPUREAD read, value is what was read
+	RecordTick   RecordKind = 4 // CPU cycles that passed since the last entry
+	RecordFrame  RecordKind = 5 // a frame was presented, value is the frame number
+)
+
+// String return the name of the entry kind
+func (k RecordKind) String() string {
+	switch k {
+	case RecordGP0:
+		return "GP0"
+	case RecordGP0DMA:
+		return "GP0 (DMA)"
+	case RecordGP1:
+		return "GP1"
+	case RecordRead:
+		return "GPUREAD"
+	case RecordTick:
+		return "Tick"
+	case RecordFrame:
+		return "Frame"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(k))
+	}
+}
+
+// CommandRecorder writes a recording of the GPU's inputs to a file
+type CommandRecorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	cycles uint32 // cycles ticked that haven't been written yet
+	frames uint32 // number of frames recorded
+	err    error  // first error hit while writing
+}
+
+// NewCommandRecorder create a recorder writing to a new file at path
+func NewCommandRecorder(path string) (*CommandRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create recording file: %v", err)
+	}
+
+	r := &CommandRecorder{file: file, writer: bufio.NewWriter(file)}
+	if _, err := r.writer.WriteString(RECORDING_MAGIC); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to write recording header: %v", err)
+	}
+
+	return r, nil
+}
+
+// record write an entry, any ticked cycles go first so the
+// entries stay in order
+func (r *CommandRecorder) record(kind RecordKind, val uint32) {
+	if kind != RecordTick && r.cycles > 0 {
+		cycles := r.cycles
+		r.cycles = 0
+		r.record(RecordTick, cycles)
+	}
+
+	if r.err != nil {
+		return
+	}
+
+	var entry [5]byte
+	entry[0] = uint8(kind)
+	binary.LittleEndian.PutUint32(entry[1:], val)
+
+	_, r.err = r.writer.Write(entry[:])
+}
+
+// tick count cycles that passed
+func (r *CommandRecorder) tick(cycles uint32) {
+	// too many for one entry, fill up entries until the rest fit
+	for cycles > MAX_TICK_CYCLES-r.cycles {
+		cycles -= MAX_TICK_CYCLES - r.cycles
+		r.record(RecordTick, MAX_TICK_CYCLES)
+		r.cycles = 0
+	}
+
+	r.cycles += cycles
+}
+
+// frame mark the end of a frame
+func (r *CommandRecorder) frame() {
+	r.record(RecordFrame, r.frames)
+	r.frames += 1
+}
+
+// Close flush and close the recording file. Returns the first error
+// hit while recording if there was one
+func (r *CommandRecorder) Close() error {
+	if r.err == nil {
+		r.err = r.writer.Flush()
+	}
+
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+
+	return r.err
+}
+
+// StartRecording start recording everything going into the GPU to a
+// new file at path
+func (g *Gpu) StartRecording(path string) error {
+	if g.recorder != nil {
+		return errors.New("GPU is already recording")
+	}
+
+	recorder, err := NewCommandRecorder(path)
+	if err != nil {
+		return err
+	}
+
+	g.recorder = recorder
+	return nil
+}
+
+// StopRecording stop recording and close the recording file
+func (g *Gpu) StopRecording() error {
+	if g.recorder == nil {
+		return nil
+	}
+
+	err := g.recorder.Close()
+	g.recorder = nil
+
+	return err
+}
+
+// Replay feeds a recording into a GPU. The GPU doesn't need anything
+// else hooked up to it
+type Replay struct {
+	reader *bufio.Reader
+	gpu    *Gpu
+}
+
+// NewReplay create a replay of the recording in reader into g
+func NewReplay(reader io.Reader, g *Gpu) (*Replay, error) {
+	r := &Replay{reader: bufio.NewReader(reader), gpu: g}
+
+	magic := make([]byte, len(RECORDING_MAGIC))
+	if _, err := io.ReadFull(r.reader, magic); err != nil {
+		return nil, fmt.Errorf("Failed to read recording header: %v", err)
+	}
+
+	if string(magic) != RECORDING_MAGIC {
+		return nil, errors.New("Not a GPU recording")
+	}
+
+	return r, nil
+}
+
+// Next read the next entry and apply it to the GPU. Returns io.EOF
+// once the recording is over
+func (r *Replay) Next() (RecordKind, uint32, error) {
+	var entry [5]byte
+	if _, err := io.ReadFull(r.reader, entry[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, 0, errors.New("Recording ends in the middle of an entry")
+		}
+
+		return 0, 0, err
+	}
+
+	kind := RecordKind(entry[0])
+	val := binary.LittleEndian.Uint32(entry[1:])
+
+	switch kind {
+	case RecordGP0, RecordGP0DMA:
+		r.gpu.gp0Write(val)
+	case RecordGP1:
+		r.gpu.GP1(val)
+	case RecordRead:
+		r.gpu.Read()
+	case RecordTick:
+		r.gpu.Tick(val)
+	case RecordFrame:
+		// nothing to do, ticking already presented the frame
+	default:
+		return kind, val, fmt.Errorf("Unknown recording entry kind %d", uint8(kind))
+	}
+
+	return kind, val, nil
+}
+
+// NextFrame replay entries up to and including the next frame marker
+func (r *Replay) NextFrame() error {
+	for {
+		kind, _, err := r.Next()
+		if err != nil {
+			return err
+		}
+
+		if kind == RecordFrame {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package gpu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// recordedEntry an entry as it ends up in the recording
+type recordedEntry struct {
+	kind RecordKind
+	val  uint32
+}
+
+func TestRecorderTickChunks(t *testing.T) {
+	var buf bytes.Buffer
+	r := &CommandRecorder{writer: bufio.NewWriter(&buf)}
+
+	r.tick(MAX_TICK_CYCLES/2 + 1)
+	r.tick(2*MAX_TICK_CYCLES + 5) // more than fits in two entries
+	r.record(RecordGP1, 0x03000001)
+	r.tick(MAX_TICK_CYCLES) // exactly one entry's worth
+	r.frame()
+	r.record(RecordGP1, 0x03000000) // nothing ticked, no empty entry
+
+	if err := r.writer.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	var got []recordedEntry
+	data := buf.Bytes()
+	for pos := 0; pos+5 <= len(data); pos += 5 {
+		got = append(got, recordedEntry{RecordKind(data[pos]), binary.LittleEndian.Uint32(data[pos+1:])})
+	}
+
+	want := []recordedEntry{
+		{RecordTick, MAX_TICK_CYCLES},
+		{RecordTick, MAX_TICK_CYCLES},
+		{RecordTick, MAX_TICK_CYCLES/2 + 6},
+		{RecordGP1, 0x03000001},
+		{RecordTick, MAX_TICK_CYCLES},
+		{RecordFrame, 0},
+		{RecordGP1, 0x03000000},
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("entries\n got %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package gpu
+
+// Video timing stuff. The GPU runs off its own video clock which is
+// 11/7 of the CPU clock, everything in here is counted in video clock
+// cycles
+
+const (
+	NTSC_CYCLES_PER_LINE = 3413 // video cycles per scanline in NTSC mode
+	NTSC_LINES_PER_FRAME = 263  // scanlines per frame in NTSC mode
+	PAL_CYCLES_PER_LINE  = 3406 // video cycles per scanline in PAL mode
+	PAL_LINES_PER_FRAME  = 314  // scanlines per frame in PAL mode
+)
+
+// State of the video timings
+type videoTiming struct {
+	cycleFrac  uint32 // leftover CPU cycles*11 that didn't make a full video cycle yet
+	lineCycles uint32 // video cycles into the current scanline
+	line       uint32 // current scanline
+	inHBlank   bool   // currently in horizontal blanking
+	inVBlank   bool   // currently in vertical blanking
+	dotclocks  uint32 // dotclocks elapsed, for whoever wants to count them
+	dotFrac    uint32 // leftover video cycles that didn't make a full dotclock
+}
+
+// cyclesPerLine return the number of video cycles per scanline for the video mode
+func (v VideoMode) cyclesPerLine() uint32 {
+	if v == Pal {
+		return PAL_CYCLES_PER_LINE
+	}
+
+	return NTSC_CYCLES_PER_LINE
+}
+
+// linesPerFrame return the number of scanlines per frame for the video mode
+func (v VideoMode) linesPerFrame() uint32 {
+	if v == Pal {
+		return PAL_LINES_PER_FRAME
+	}
+
+	return NTSC_LINES_PER_FRAME
+}
+
+// dotclockDivider return the number of video cycles per dot for this
+// horizontal resolution
+func (h HorizontalRes) dotclockDivider() uint32 {
+	if h&1 != 0 { // hr2 set, 368 pixels
+		return 7
+	}
+
+	switch h >> 1 {
+	case 0: // 256 pixels
+		return 10
+	case 1: // 320 pixels
+		return 8
+	case 2: // 512 pixels
+		return 5
+	default: // 640 pixels
+		return 4
+	}
+}
+
+// Tick advance the GPU timings by cpuCycles CPU cycles. Returns true
+// when vblank started, that's when IRQ0 should be raised
+func (g *Gpu) Tick(cpuCycles uint32) bool {
+	if g.recorder != nil {
+		g.recorder.tick(cpuCycles)
+	}
+
+	t := &g.timing
+	stat := &g.gpuStat
+
+	// video clock is 11/7 the CPU clock
+	t.cycleFrac += cpuCycles * 11
+	videoCycles := t.cycleFrac / 7
+	t.cycleFrac %= 7
+
+	divider := stat.horizontalRes.dotclockDivider()
+	t.dotFrac += videoCycles
+	t.dotclocks += t.dotFrac / divider
+	t.dotFrac %= divider
+
+	// let the GPU get through whatever is waiting in the FIFO, any stall
+	// is part of these cycles now
+	g.busyCycles -= int32(videoCycles)
+	g.stallCycles = 0
+	g.processFIFO()
+
+	vblankStarted := false
+	t.lineCycles += videoCycles
+
+	cyclesPerLine := stat.videoMode.cyclesPerLine()
+	for t.lineCycles >= cyclesPerLine {
+		t.lineCycles -= cyclesPerLine
+		if g.nextLine() {
+			vblankStarted = true
+		}
+	}
+
+	t.inHBlank = t.lineCycles < uint32(g.displayHorizStart) || t.lineCycles >= uint32(g.displayHorizEnd)
+	g.updateOddLine()
+
+	return vblankStarted
+}
+
+// nextLine move on to the next scanline, returns true if we just
+// entered vblank
+func (g *Gpu) nextLine() bool {
+	t := &g.timing
+	stat := &g.gpuStat
+
+	t.line += 1
+	if t.line >= stat.videoMode.linesPerFrame() {
+		t.line = 0
+	}
+
+	vblank := t.line < uint32(g.displayLineStart) || t.line >= uint32(g.displayLineEnd)
+	started := vblank && !t.inVBlank
+	if g.displayLineEnd <= g.displayLineStart {
+		// empty display range so we never leave vblank, still want a
+		// frame every field though
+		started = t.line == 0
+	}
+	t.inVBlank = vblank
+
+	if started {
+		if stat.verticalInterlace {
+			stat.interlaceField = !stat.interlaceField
+		}
+
+		g.Display()
+	}
+
+	return started
+}
+
+// updateOddLine update GPUSTAT bit 31. In 480 line interlaced mode it
+// says which field is being drawn, otherwise it just toggles every
+// line. It's always 0 during vblank
+func (g *Gpu) updateOddLine() {
+	t := &g.timing
+	stat := &g.gpuStat
+
+	switch {
+	case t.inVBlank:
+		stat.drawingOddLine = false
+	case stat.verticalInterlace && stat.verticalRes == Y480Lines:
+		stat.drawingOddLine = !stat.interlaceField
+	default:
+		stat.drawingOddLine = t.line&1 != 0
+	}
+}
+
+// InHBlank whether the GPU is currently in horizontal blanking
+func (g *Gpu) InHBlank() bool {
+	return g.timing.inHBlank
+}
+
+// InVBlank whether the GPU is currently in vertical blanking
+func (g *Gpu) InVBlank() bool {
+	return g.timing.inVBlank
+}
+
+// TakeDotclocks return the number of dotclocks since the last call and
+// reset the count
+func (g *Gpu) TakeDotclocks() uint32 {
+	dots := g.timing.dotclocks
+	g.timing.dotclocks = 0
+
+	return dots
+}
@@ -0,0 +1,174 @@
+package gpu
+
+import "github.com/TheOrnyx/psx-go/renderer"
+
+const (
+	VRAM_WIDTH  = 1024 // VRAM width in 16-bit pixels
+	VRAM_HEIGHT = 512  // VRAM height in lines
+
+	DIRTY_BLOCK_SIZE = 64                             // size of the blocks changes to VRAM are tracked in
+	DIRTY_BLOCKS_X   = VRAM_WIDTH / DIRTY_BLOCK_SIZE  // dirty blocks across VRAM
+	DIRTY_BLOCKS_Y   = VRAM_HEIGHT / DIRTY_BLOCK_SIZE // dirty blocks down VRAM
+)
+
+// The 1MB of GPU VRAM stored as 1024x512 16-bit pixels. Pixels are
+// 5:5:5 BGR with bit 15 being the mask bit
+//
+// NOTE - this is what the software side of the GPU draws into and it
+// gets everything, so it's the real VRAM. GPUREAD reads it and the GL
+// renderer samples textures from a copy of it. The renderer also draws
+// every primitive into its own upscaled framebuffer but that's only
+// for showing, image loads get copied into it so it doesn't drift
+type VRAM struct {
+	pixels []uint16
+}
+
+// NewVRAM create and return a new zeroed VRAM
+func NewVRAM() VRAM {
+	return VRAM{pixels: make([]uint16, VRAM_WIDTH*VRAM_HEIGHT)}
+}
+
+// get return the pixel at x, y. Coordinates wrap around like on the
+// real thing
+func (v *VRAM) get(x, y int32) uint16 {
+	return v.pixels[(y&(VRAM_HEIGHT-1))*VRAM_WIDTH+(x&(VRAM_WIDTH-1))]
+}
+
+// set set the pixel at x, y to val. Coordinates wrap around
+func (v *VRAM) set(x, y int32, val uint16) {
+	v.pixels[(y&(VRAM_HEIGHT-1))*VRAM_WIDTH+(x&(VRAM_WIDTH-1))] = val
+}
+
+// colorTo15 convert a 24-bit color to a 15-bit VRAM pixel by dropping
+// the low bits of each component
+func colorTo15(c renderer.Color) uint16 {
+	r := uint16(c.R >> 3)
+	g := uint16(c.G >> 3)
+	b := uint16(c.B >> 3)
+
+	return r | (g << 5) | (b << 10)
+}
+
+// uploadVRAM send a rectangle of VRAM the GPU wrote itself to the
+// backend. Rectangles going off the edge of VRAM wrap around so they
+// get split up
+func (g *Gpu) uploadVRAM(x, y, width, height int32) {
+	x &= VRAM_WIDTH - 1
+	y &= VRAM_HEIGHT - 1
+	width = min(width, VRAM_WIDTH)
+	height = min(height, VRAM_HEIGHT)
+
+	if x+width > VRAM_WIDTH {
+		g.uploadVRAM(0, y, x+width-VRAM_WIDTH, height)
+		width = VRAM_WIDTH - x
+	}
+
+	if y+height > VRAM_HEIGHT {
+		g.uploadVRAM(x, 0, width, y+height-VRAM_HEIGHT)
+		height = VRAM_HEIGHT - y
+	}
+
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	g.renderer.UploadVRAM(x, y, width, height, g.vram.rect(x, y, width, height))
+}
+
+// rect copy out a rectangle of pixels, it has to fit in VRAM
+func (v *VRAM) rect(x, y, width, height int32) []uint16 {
+	pixels := make([]uint16, 0, width*height)
+	for row := y; row < y+height; row++ {
+		start := row*VRAM_WIDTH + x
+		pixels = append(pixels, v.pixels[start:start+width]...)
+	}
+
+	return pixels
+}
+
+// markDirty mark the blocks a rectangle of VRAM touches as changed
+// since the renderer's texture copy was last updated. Wraps around
+func (g *Gpu) markDirty(x, y, width, height int32) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	for by := y / DIRTY_BLOCK_SIZE; by <= (y+height-1)/DIRTY_BLOCK_SIZE; by++ {
+		for bx := x / DIRTY_BLOCK_SIZE; bx <= (x+width-1)/DIRTY_BLOCK_SIZE; bx++ {
+			g.dirtyBlocks[by&(DIRTY_BLOCKS_Y-1)][bx&(DIRTY_BLOCKS_X-1)] = true
+		}
+	}
+}
+
+// dirty whether any of a rectangle of VRAM changed since the renderer's
+// texture copy was last updated. Wraps around
+func (g *Gpu) dirty(x, y, width, height int32) bool {
+	for by := y / DIRTY_BLOCK_SIZE; by <= (y+height-1)/DIRTY_BLOCK_SIZE; by++ {
+		for bx := x / DIRTY_BLOCK_SIZE; bx <= (x+width-1)/DIRTY_BLOCK_SIZE; bx++ {
+			if g.dirtyBlocks[by&(DIRTY_BLOCKS_Y-1)][bx&(DIRTY_BLOCKS_X-1)] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// texturesDirty whether the texture page or CLUT a primitive samples
+// from changed since the renderer's texture copy was last updated
+func (g *Gpu) texturesDirty(attrs *primAttrs) bool {
+	switch attrs.texDepth {
+	case T4Bit:
+		return g.dirty(attrs.texPageX, attrs.texPageY, 64, 256) || g.dirty(attrs.clutX, attrs.clutY, 16, 1)
+	case T8Bit:
+		return g.dirty(attrs.texPageX, attrs.texPageY, 128, 256) || g.dirty(attrs.clutX, attrs.clutY, 256, 1)
+	default:
+		return g.dirty(attrs.texPageX, attrs.texPageY, 256, 256)
+	}
+}
+
+// syncTextures send every changed block to the renderer's texture copy.
+// Runs of blocks on the same row go together
+func (g *Gpu) syncTextures() {
+	for by := range int32(DIRTY_BLOCKS_Y) {
+		for bx := int32(0); bx < DIRTY_BLOCKS_X; {
+			if !g.dirtyBlocks[by][bx] {
+				bx++
+				continue
+			}
+
+			start := bx
+			for bx < DIRTY_BLOCKS_X && g.dirtyBlocks[by][bx] {
+				g.dirtyBlocks[by][bx] = false
+				bx++
+			}
+
+			x := start * DIRTY_BLOCK_SIZE
+			y := by * DIRTY_BLOCK_SIZE
+			width := (bx - start) * DIRTY_BLOCK_SIZE
+
+			g.renderer.UpdateTextures(x, y, width, DIRTY_BLOCK_SIZE, g.vram.rect(x, y, width, DIRTY_BLOCK_SIZE))
+		}
+	}
+}
+
+// State for a GP0(A0h) CPU to VRAM image transfer
+type imageTransfer struct {
+	x, y          int32 // top left corner of the destination in VRAM
+	width, height int32 // size of the image in pixels
+	curX, curY    int32 // position of the next pixel relative to x, y
+}
+
+// done whether all the pixels of the transfer have been received
+func (t *imageTransfer) done() bool {
+	return t.curY >= t.height
+}
+
+// advance move to the next pixel position
+func (t *imageTransfer) advance() {
+	t.curX += 1
+	if t.curX == t.width {
+		t.curX = 0
+		t.curY += 1
+	}
+}
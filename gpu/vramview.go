@@ -0,0 +1,122 @@
+package gpu
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// Debug view of the whole VRAM. Used for checking what's in the
+// texture pages and CLUTs when something renders wrong
+
+var (
+	displayAreaColor = color.RGBA{R: 0x00, G: 0xff, B: 0x00, A: 0xff} // outline of the display area
+	drawAreaColor    = color.RGBA{R: 0xff, G: 0x00, B: 0x00, A: 0xff} // outline of the drawing area
+)
+
+// VRAMImage return an image of all of VRAM interpreted using mode with
+// the display and drawing areas outlined. The 4bpp and 8bpp views are
+// 4 and 2 times wider since every VRAM pixel holds that many texels,
+// the indices are shown as grays
+func (g *Gpu) VRAMImage(mode VRAMViewMode) *image.RGBA {
+	scale := mode.texelsPerPixel()
+	img := image.NewRGBA(image.Rect(0, 0, int(VRAM_WIDTH*scale), VRAM_HEIGHT))
+
+	for y := int32(0); y < VRAM_HEIGHT; y++ {
+		for x := int32(0); x < VRAM_WIDTH*scale; x++ {
+			pixel := g.vram.get(x/scale, y)
+
+			var c color.RGBA
+			switch mode {
+			case View4Bit:
+				index := uint8(pixel>>((x&3)*4)) & 0xf
+				c = color.RGBA{R: index * 17, G: index * 17, B: index * 17, A: 0xff}
+			case View8Bit:
+				index := uint8(pixel >> ((x & 1) * 8))
+				c = color.RGBA{R: index, G: index, B: index, A: 0xff}
+			default:
+				c = pixel15ToRGBA(pixel)
+			}
+
+			img.SetRGBA(int(x), int(y), c)
+		}
+	}
+
+	// the display area width is in output pixels, 24-bit ones take up
+	// 1.5 VRAM pixels each
+	width := g.displayWidth()
+	if g.gpuStat.displayDepth == D24Bit {
+		width = width * 3 / 2
+	}
+
+	left, top, right, bottom := g.drawArea()
+	outlineRect(img, int32(g.displayVramXStart)*scale, int32(g.displayVramYStart), width*scale, g.displayHeight(), displayAreaColor)
+	outlineRect(img, left*scale, top, (right-left+1)*scale, bottom-top+1, drawAreaColor)
+
+	return img
+}
+
+// outlineRect draw a one pixel outline of a rectangle onto img
+func outlineRect(img *image.RGBA, x, y, width, height int32, c color.RGBA) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	for i := x; i < x+width; i++ {
+		img.SetRGBA(int(i), int(y), c)
+		img.SetRGBA(int(i), int(y+height-1), c)
+	}
+
+	for j := y; j < y+height; j++ {
+		img.SetRGBA(int(x), int(j), c)
+		img.SetRGBA(int(x+width-1), int(j), c)
+	}
+}
+
+// VRAMPixelInfo describe the VRAM under position x, y of an image made
+// by VRAMImage with the same mode. Used for the hover readout
+func (g *Gpu) VRAMPixelInfo(mode VRAMViewMode, x, y int32) string {
+	scale := mode.texelsPerPixel()
+	if x < 0 || y < 0 || x >= VRAM_WIDTH*scale || y >= VRAM_HEIGHT {
+		return ""
+	}
+
+	vramX := x / scale
+	pixel := g.vram.get(vramX, y)
+	info := fmt.Sprintf("VRAM %d,%d raw 0x%04x", vramX, y, pixel)
+
+	switch mode {
+	case View4Bit:
+		index := (pixel >> ((x & 3) * 4)) & 0xf
+		return fmt.Sprintf("%s | 4bpp u=%d index %d", info, x%256, index)
+	case View8Bit:
+		index := (pixel >> ((x & 1) * 8)) & 0xff
+		return fmt.Sprintf("%s | 8bpp u=%d index %d", info, x%256, index)
+	default:
+		return fmt.Sprintf("%s | r=%d g=%d b=%d mask=%d",
+			info, pixel&0x1f, (pixel>>5)&0x1f, (pixel>>10)&0x1f, pixel>>15)
+	}
+}
+
+// DumpVRAM write a VRAMImage to a PNG file at path, with the
+// highlighted primitive if there is one
+func (g *Gpu) DumpVRAM(path string, mode VRAMViewMode) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Failed to create VRAM dump file: %v", err)
+	}
+	defer file.Close()
+
+	img := g.VRAMImage(mode)
+	if g.Highlight != nil {
+		img = g.HighlightImage(mode, g.Highlight)
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("Failed to encode VRAM dump: %v", err)
+	}
+
+	return nil
+}
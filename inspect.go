@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/TheOrnyx/psx-go/gpu"
+	"github.com/TheOrnyx/psx-go/log"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Keys for the frame inspector in the window:
+//
+//	F3 - capture the next frame and list its primitives
+//	F4 - select the next primitive and highlight it in the VRAM viewer
+//	F5 - select the previous primitive
+type inspector struct {
+	capture  *gpu.FrameCapture // capture the selection is from
+	selected int               // index of the selected primitive
+}
+
+// handleKey handle an inspector key, returns false if it wasn't one
+func (in *inspector) handleKey(g *gpu.Gpu, key sdl.Keycode) bool {
+	switch key {
+	case sdl.K_F3:
+		log.Info("Capturing the next frame")
+		g.CaptureFrame()
+	case sdl.K_F4:
+		in.selectPrimitive(g, in.selected+1)
+	case sdl.K_F5:
+		in.selectPrimitive(g, in.selected-1)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// update check for a new capture, lists it when there is one
+func (in *inspector) update(g *gpu.Gpu) {
+	if g.LastCapture == nil || g.LastCapture == in.capture {
+		return
+	}
+
+	in.capture = g.LastCapture
+	in.selected = -1
+	g.Highlight = nil
+
+	listCapture(in.capture)
+}
+
+// selectPrimitive select primitive index of the capture, shows the
+// VRAM viewer with it highlighted
+func (in *inspector) selectPrimitive(g *gpu.Gpu, index int) {
+	if in.capture == nil || len(in.capture.Primitives) == 0 {
+		log.Warn("No captured frame to inspect, press F3 first")
+		return
+	}
+
+	count := len(in.capture.Primitives)
+	in.selected = (index + count) % count
+
+	p := &in.capture.Primitives[in.selected]
+	g.Highlight = p
+	g.ShowVRAM = true
+
+	log.Infof("Selected primitive:\n%v", p)
+}
+
+// listCapture log a one line summary of every primitive in capture
+func listCapture(capture *gpu.FrameCapture) {
+	log.Infof("Captured %d primitives", len(capture.Primitives))
+
+	for i := range capture.Primitives {
+		p := &capture.Primitives[i]
+		log.Infof("#%d GP0(%02xh) %s, %d vertices, %d pixels", p.Index, p.Opcode, p.Name, len(p.Vertices), len(p.Pixels))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/TheOrnyx/psx-go/cdrom"
+	"github.com/TheOrnyx/psx-go/log"
+)
+
+// runISO the iso subcommand, looks around the filesystem of a disc
+// image without running anything:
+//
+//	psx-go iso info image               - boot executable and serial
+//	psx-go iso ls [-r] image [dir]      - list a directory
+//	psx-go iso extract image path [out] - copy a file or directory out
+func runISO(args []string) {
+	usage := "Usage: iso info image | iso ls [-r] image [dir] | iso extract image path [out]"
+	if len(args) < 1 {
+		log.Panic(usage)
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("iso", flag.ExitOnError)
+	recursive := flags.Bool("r", false, "list subdirectories too")
+	flags.Parse(args[1:])
+
+	if flags.NArg() < 1 {
+		log.Panic(usage)
+	}
+
+	disc, err := cdrom.OpenDisc(flags.Arg(0))
+	if err != nil {
+		log.Panicf("Failed to open disc: %v", err)
+	}
+	defer disc.Close()
+
+	fs, err := cdrom.OpenFilesystem(disc)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+
+	switch action {
+	case "info":
+		boot, err := fs.Boot()
+		if err != nil {
+			log.Panicf("%v", err)
+		}
+
+		serial := boot.Serial
+		if serial == "" {
+			serial = "unknown"
+		}
+		fmt.Printf("Boot:   %s\nSerial: %s\n", boot.Exe, serial)
+
+	case "ls":
+		err = listDir(fs, flags.Arg(1), *recursive)
+
+	case "extract":
+		if flags.NArg() < 2 {
+			log.Panic(usage)
+		}
+
+		out := flags.Arg(2)
+		if out == "" {
+			out = filepath.Base(filepath.FromSlash(flags.Arg(1)))
+		}
+		err = extract(fs, flags.Arg(1), out)
+
+	default:
+		log.Panic(usage)
+	}
+
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+}
+
+// listDir print the files in dir with their sizes and LBAs, directories
+// end in /
+func listDir(fs *cdrom.Filesystem, dir string, recursive bool) error {
+	show := func(path string, info cdrom.FileInfo) {
+		if info.Dir {
+			path += "/"
+		}
+		fmt.Printf("%8d %10d  %s\n", info.LBA, info.Size, path)
+	}
+
+	if recursive {
+		return fs.Walk(dir, func(path string, info cdrom.FileInfo) error {
+			show(path, info)
+			return nil
+		})
+	}
+
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		show(entry.Name, entry)
+	}
+
+	return nil
+}
+
+// extract copy the file at path on the disc to out, a directory gets
+// copied with everything in it
+func extract(fs *cdrom.Filesystem, path string, out string) error {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.Dir {
+		return extractFile(fs, path, out)
+	}
+
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+
+	return fs.Walk(path, func(sub string, info cdrom.FileInfo) error {
+		// names come off the disc, don't let them go outside out
+		if !filepath.IsLocal(filepath.FromSlash(sub)) {
+			return fmt.Errorf("Refusing to extract %q outside of %s", sub, out)
+		}
+
+		dest := filepath.Join(out, filepath.FromSlash(sub))
+		if info.Dir {
+			return os.MkdirAll(dest, 0755)
+		}
+
+		return extractFile(fs, path+"/"+sub, dest)
+	})
+}
+
+// extractFile copy one file off the disc
+func extractFile(fs *cdrom.Filesystem, path string, out string) error {
+	file, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %v", out, err)
+	}
+	defer file.Close()
+
+	if err := fs.Extract(path, file); err != nil {
+		return err
+	}
+
+	log.Infof("Extracted %s to %s", path, out)
+	return nil
+}
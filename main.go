@@ -1,60 +1,193 @@
 package main
 
 import (
-	"github.com/TheOrnyx/psx-go/emulator"
+	"flag"
+	"fmt"
+	"os"
 	"runtime"
 
+	"github.com/TheOrnyx/psx-go/emulator"
+
 	"github.com/TheOrnyx/psx-go/cdrom"
 	"github.com/TheOrnyx/psx-go/cpu"
 	"github.com/TheOrnyx/psx-go/gpu"
 	"github.com/TheOrnyx/psx-go/log"
 	"github.com/TheOrnyx/psx-go/memory"
 	"github.com/TheOrnyx/psx-go/renderer"
+	"github.com/TheOrnyx/psx-go/spu"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
 func main() {
 	runtime.LockOSThread()
-	bios, err := memory.NewBios("./data/SCPH1001.BIN")
-	if err != nil {
-		log.Panicf("Failed to create Bios: %v", err)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "iso":
+			runISO(os.Args[2:])
+			return
+		}
 	}
 
-	err = sdl.Init(sdl.INIT_VIDEO)
+	biosPath := flag.String("bios", "./data/SCPH1001.BIN", "path to the BIOS image")
+	headless := flag.Bool("headless", false, "run without a window")
+	frames := flag.Uint64("frames", 0, "quit after this many frames (0 runs forever)")
+	dumpVRAM := flag.String("dump-vram", "", "write a PNG of VRAM to this path when quitting")
+	vramView := flag.String("vram-view", "15", "how to show VRAM in the viewer and dumps: 15, 4 or 8 (bpp)")
+	record := flag.String("record", "", "record everything going into the GPU to this file")
+	scale := flag.Int("scale", 1, "internal resolution multiplier of the GL renderer (1-8)")
+	aspect := flag.String("aspect", "4:3", "aspect ratio of the picture: 4:3, native or stretch")
+	integerScale := flag.Bool("integer-scale", false, "only scale the picture by whole numbers")
+	filter := flag.String("filter", "nearest", "filtering when scaling the picture: nearest or linear")
+	fullscreen := flag.Bool("fullscreen", false, "start in fullscreen")
+	post := flag.String("post", "none", "post processing chain: none, scanlines, crt, sharpen or a config directory")
+	shaderDir := flag.String("shader-dir", "", "directory of shaders to use instead of the built in ones, F12 reloads them")
+	flag.Parse()
+
+	viewMode, err := gpu.ParseVRAMViewMode(*vramView)
 	if err != nil {
-		log.Panicf("Failed to initialize SDL: %v", err)
+		log.Panicf("%v", err)
 	}
 
-	renderer, err := renderer.NewRenderer()
+	discPath := "" // no disc
+	if flag.NArg() > 0 {
+		discPath = flag.Arg(0)
+	}
+
+	bios, err := memory.NewBios(*biosPath)
 	if err != nil {
-		sdl.Quit()
-		log.Panicf("Failed to initialize renderer: %v", err)
+		log.Panicf("Failed to create Bios: %v", err)
 	}
 
-	gpu := gpu.NewGPU(renderer)
+	var backend gpu.Backend
+	var glRenderer *renderer.Renderer
+	if *headless {
+		backend = &gpu.HeadlessBackend{}
+	} else {
+		err = sdl.Init(sdl.INIT_VIDEO)
+		if err != nil {
+			log.Panicf("Failed to initialize SDL: %v", err)
+		}
+
+		renderer.SetShaderDir(*shaderDir)
+		glRenderer, err = renderer.NewRenderer()
+		if err != nil {
+			sdl.Quit()
+			log.Panicf("Failed to initialize renderer: %v", err)
+		}
+
+		if err := glRenderer.SetInternalScale(int32(*scale)); err != nil {
+			glRenderer.Quit()
+			log.Panicf("%v", err)
+		}
+
+		aspectMode, err := renderer.ParseAspectMode(*aspect)
+		if err != nil {
+			glRenderer.Quit()
+			log.Panicf("%v", err)
+		}
+
+		filterMode, err := renderer.ParseFilter(*filter)
+		if err != nil {
+			glRenderer.Quit()
+			log.Panicf("%v", err)
+		}
+
+		glRenderer.SetOutputSettings(renderer.OutputSettings{
+			Aspect:       aspectMode,
+			IntegerScale: *integerScale,
+			Filter:       filterMode,
+		})
+
+		postChain, err := renderer.LoadPostChain(*post)
+		if err != nil {
+			glRenderer.Quit()
+			log.Panicf("%v", err)
+		}
+
+		if err := glRenderer.SetPostChain(postChain); err != nil {
+			glRenderer.Quit()
+			log.Panicf("%v", err)
+		}
+
+		if *fullscreen {
+			if err := glRenderer.ToggleFullscreen(); err != nil {
+				log.Warnf("Failed to go fullscreen: %v", err)
+			}
+		}
+		backend = glRenderer
+	}
+
+	gpu := gpu.NewGPU(backend)
+	gpu.VRAMView = viewMode
 	defer gpu.Quit()
 
-	cdrom, err := cdrom.NewCDROM("./data/Roms/tests/PeterLemon/HelloWorld/16BPP/HelloWorld16BPP.exe")
+	if *record != "" {
+		if err := gpu.StartRecording(*record); err != nil {
+			log.Panicf("%v", err)
+		}
+	}
+
+	cdrom, err := cdrom.NewCDROM(discPath)
 	if err != nil {
 		gpu.Quit()
 		log.Panicf("Failed to create CDROM: %v", err)
 	}
 
-	bus := memory.NewBus(bios, &gpu, &cdrom)
+	spu := spu.NewSPU()
+	cdrom.ConnectAudio(&spu)
+
+	bus := memory.NewBus(bios, &gpu, &cdrom, &spu)
 	cpu := cpu.NewCPU(bus)
 
 	emu := emulator.Emulator{
 		Cpu:      cpu,
 		Gpu:      &gpu,
-		Renderer: renderer,
+		Renderer: glRenderer,
 		Bus:      bus,
 		Cdrom:    &cdrom,
+		Spu:      &spu,
 	}
 	defer emu.Quit()
 
-	for {
-		for i := 0; i < 1000000; i++ {
-			emu.Step()
+	var audio *audioOutput
+	if !*headless {
+		audio, err = openAudio()
+		if err != nil {
+			log.Warnf("%v, running without sound", err)
+		} else {
+			defer audio.close()
+		}
+	}
+
+	if *dumpVRAM != "" {
+		defer func() {
+			if err := gpu.DumpVRAM(*dumpVRAM, gpu.VRAMView); err != nil {
+				log.Warnf("%v", err)
+			}
+		}()
+	}
+
+	var frameInspector inspector
+
+	for frame := uint64(1); ; frame++ {
+		emu.RunFrame()
+		frameInspector.update(&gpu)
+
+		samples := spu.TakeOutput()
+		if audio != nil {
+			audio.queue(samples)
+		}
+
+		if *frames != 0 && frame >= *frames {
+			return
+		}
+
+		if *headless {
+			continue
 		}
 
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
@@ -66,11 +199,90 @@ func main() {
 				if t.Type == sdl.KEYDOWN {
 					keyCode := t.Keysym.Sym
 
-					if keyCode == sdl.K_ESCAPE {
+					if frameInspector.handleKey(&gpu, keyCode) {
+						continue
+					}
+
+					switch keyCode {
+					case sdl.K_ESCAPE:
 						return
+					case sdl.K_F1: // toggle the VRAM viewer
+						gpu.ShowVRAM = !gpu.ShowVRAM
+						glRenderer.Window.SetTitle("PSX-GO")
+					case sdl.K_F2: // next VRAM viewer mode
+						gpu.VRAMView = gpu.VRAMView.Next()
+					case sdl.K_F6: // next internal resolution
+						nextScale := glRenderer.InternalScale()%renderer.MAX_INTERNAL_SCALE + 1
+						if err := glRenderer.SetInternalScale(nextScale); err != nil {
+							log.Warnf("%v", err)
+						} else {
+							log.Infof("Internal resolution %dx", nextScale)
+						}
+					case sdl.K_F7: // swap to the next disc, with shift just open or close the lid
+						changeDisc(&cdrom, t.Keysym.Mod&sdl.KMOD_SHIFT != 0)
+					case sdl.K_F8, sdl.K_F9, sdl.K_F10:
+						changeOutputSettings(glRenderer, keyCode)
+					case sdl.K_F11:
+						if err := glRenderer.ToggleFullscreen(); err != nil {
+							log.Warnf("Failed to toggle fullscreen: %v", err)
+						}
+					case sdl.K_F12: // reload the shaders
+						if err := glRenderer.ReloadShaders(); err != nil {
+							log.Warnf("%v", err)
+						}
 					}
 				}
+
+			case *sdl.MouseMotionEvent:
+				if gpu.ShowVRAM {
+					showVRAMHover(&gpu, glRenderer, t.X, t.Y)
+				}
 			}
 		}
 	}
 }
+
+// showVRAMHover put the VRAM viewer readout for the pixel under the
+// mouse in the window title
+func showVRAMHover(g *gpu.Gpu, r *renderer.Renderer, mouseX, mouseY int32) {
+	x, y, ok := r.WindowToPicture(mouseX, mouseY)
+	if !ok {
+		return
+	}
+
+	r.Window.SetTitle(fmt.Sprintf("PSX-GO VRAM (%v) - %s", g.VRAMView, g.VRAMPixelInfo(g.VRAMView, x, y)))
+}
+
+// changeDisc swap to the next disc in the playlist, or open or close
+// the lid when justLid is set
+func changeDisc(c *cdrom.CDROM, justLid bool) {
+	switch {
+	case !justLid:
+		c.NextDisc()
+	case c.LidOpen():
+		c.CloseLid()
+	default:
+		c.OpenLid()
+	}
+}
+
+// changeOutputSettings handle the keys for how the picture is shown:
+//
+//	F8  - next aspect mode
+//	F9  - toggle integer scaling
+//	F10 - toggle linear filtering
+func changeOutputSettings(r *renderer.Renderer, key sdl.Keycode) {
+	settings := r.OutputSettings()
+
+	switch key {
+	case sdl.K_F8:
+		settings.Aspect = settings.Aspect.Next()
+	case sdl.K_F9:
+		settings.IntegerScale = !settings.IntegerScale
+	case sdl.K_F10:
+		settings.Filter = (settings.Filter + 1) % 2
+	}
+
+	r.SetOutputSettings(settings)
+	log.Infof("Output: aspect %v, integer scaling %v, filter %v", settings.Aspect, settings.IntegerScale, settings.Filter)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/TheOrnyx/psx-go/cdrom"
 	"github.com/TheOrnyx/psx-go/gpu"
 	"github.com/TheOrnyx/psx-go/log"
+	"github.com/TheOrnyx/psx-go/spu"
 )
 
 // Bus the memory bus
@@ -19,13 +20,31 @@ type Bus struct {
 	dma   Dma // the DMA registers
 	gpu   *gpu.Gpu
 	cdRom *cdrom.CDROM // the CDROM
+	spu   *spu.SPU     // the SPU
 	iStat uint32       // 1F801070h I_STAT - Interrupt status register (R=Status, W=Acknowledge)
 	iMask uint32       // 1F801074h I_MASK - Interrupt mask register (R/W)
 }
 
+// Interrupt the interrupt request lines going into I_STAT
+type Interrupt uint32
+
+const (
+	IRQVBlank Interrupt = 0  // GPU vblank
+	IRQGpu    Interrupt = 1  // GPU GP0(1Fh)
+	IRQCdrom  Interrupt = 2  // CDROM controller
+	IRQDma    Interrupt = 3  // DMA
+	IRQTimer0 Interrupt = 4  // Timer 0
+	IRQTimer1 Interrupt = 5  // Timer 1
+	IRQTimer2 Interrupt = 6  // Timer 2
+	IRQPad    Interrupt = 7  // Controller and memory card
+	IRQSio    Interrupt = 8  // Serial port
+	IRQSpu    Interrupt = 9  // SPU
+	IRQLight  Interrupt = 10 // Lightpen and PIO
+)
+
 // NewBus create and return a new bus object
-func NewBus(bios *Bios, gpu *gpu.Gpu, cdRom *cdrom.CDROM) *Bus {
-	return &Bus{bios: bios, ram: NewRam(), dma: NewDMA(), gpu: gpu, cdRom: cdRom}
+func NewBus(bios *Bios, gpu *gpu.Gpu, cdRom *cdrom.CDROM, spu *spu.SPU) *Bus {
+	return &Bus{bios: bios, ram: NewRam(), dma: NewDMA(), gpu: gpu, cdRom: cdRom, spu: spu}
 }
 
 // ReadDMAReg read the dma register
@@ -129,12 +148,7 @@ func (b *Bus) Load32(addr uint32) (uint32, error) {
 	}
 
 	if offset, contains := IRQ_CONTROL.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) IRQ Control 32bit read at: 0x%08x", absAddr)
-		if offset == 0 {
-			return b.iStat, nil
-		}
-
-		return b.iMask, nil
+		return b.readIRQControl(offset), nil
 	}
 
 	if offset, contains := DMA_RANGE.Contains(absAddr); contains {
@@ -143,9 +157,9 @@ func (b *Bus) Load32(addr uint32) (uint32, error) {
 
 	if offset, contains := GPU_RANGE.Contains(absAddr); contains {
 		switch offset {
+		case 0: // GPUREAD
+			return b.gpu.Read(), nil
 		case 4: // gpustat
-			// set bit 26, 27 and 28 to signal that gpu is ready for
-			// DMA and CPU access
 			return b.gpu.Status(), nil
 		default:
 			log.Infof("(Not fully implemented yet) GPU 32bit read at: 0x%08x", absAddr)
@@ -165,18 +179,16 @@ func (b *Bus) Load32(addr uint32) (uint32, error) {
 func (b *Bus) Load16(addr uint32) (uint16, error) {
 	absAddr := MaskRegion(addr)
 
-	if _, contains := SPU_RANGE.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) SPU register 16bit read at: 0x%08x", absAddr)
-		return 0, nil
+	if offset, contains := SPU_RANGE.Contains(absAddr); contains {
+		return b.spu.Read16(offset), nil
 	}
 
 	if offset, contains := RAM_RANGE.Contains(absAddr); contains {
 		return b.ram.load16(offset), nil
 	}
 
-	if _, contains := IRQ_CONTROL.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) IRQ control 16bit read at: 0x%08x", absAddr)
-		return 0, nil
+	if offset, contains := IRQ_CONTROL.Contains(absAddr); contains {
+		return uint16(b.readIRQControl(offset)), nil
 	}
 
 	return 0, fmt.Errorf("Unkown Load16 at address 0x%08x", absAddr)
@@ -253,12 +265,7 @@ func (b *Bus) Store32(addr, val uint32) error {
 	}
 
 	if offset, contains := IRQ_CONTROL.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) IRQ Control 32bit write 0x%08x to 0x%08x", val, absAddr)
-		if offset == 0 {
-			b.iStat = val
-		} else {
-			b.iMask = val
-		}
+		b.writeIRQControl(offset, val)
 		return nil
 	}
 
@@ -296,8 +303,8 @@ func (b *Bus) Store16(addr uint32, val uint16) error {
 
 	absAddr := MaskRegion(addr)
 
-	if _, contains := SPU_RANGE.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) SPU register 16bit write 0x%04x to 0x%08x", val, absAddr)
+	if offset, contains := SPU_RANGE.Contains(absAddr); contains {
+		b.spu.Write16(offset, val)
 		return nil
 	}
 
@@ -311,8 +318,8 @@ func (b *Bus) Store16(addr uint32, val uint16) error {
 		return nil
 	}
 
-	if _, contains := IRQ_CONTROL.Contains(absAddr); contains {
-		log.Infof("(Not implemented yet) IRQ Control 16-bit write 0x%04x to 0x%08x", val, absAddr)
+	if offset, contains := IRQ_CONTROL.Contains(absAddr); contains {
+		b.writeIRQControl(offset, uint32(val))
 		return nil
 	}
 
@@ -341,6 +348,40 @@ func (b *Bus) Store8(addr uint32, val uint8) error {
 	return fmt.Errorf("Haven't implemented store8 into address 0x%08x with val 0x%02x", addr, val)
 }
 
+///////////////////////////
+// Interrupt control stuff //
+///////////////////////////
+
+// RequestInterrupt raise interrupt irq in I_STAT
+func (b *Bus) RequestInterrupt(irq Interrupt) {
+	b.iStat |= 1 << irq
+}
+
+// IRQActive whether there's an unmasked interrupt pending, this is
+// what goes to the CPU
+func (b *Bus) IRQActive() bool {
+	return b.iStat&b.iMask != 0
+}
+
+// readIRQControl read I_STAT or I_MASK
+func (b *Bus) readIRQControl(offset uint32) uint32 {
+	if offset < 4 {
+		return b.iStat
+	}
+
+	return b.iMask
+}
+
+// writeIRQControl write to I_STAT or I_MASK. Writing 0 to an I_STAT
+// bit acknowledges it, writing 1 leaves it alone
+func (b *Bus) writeIRQControl(offset, val uint32) {
+	if offset < 4 {
+		b.iStat &= val
+	} else {
+		b.iMask = val & 0x7ff
+	}
+}
+
 //////////////////////////////////
 // Perform DMA Transfer methods //
 //////////////////////////////////
@@ -382,7 +423,7 @@ func (b *Bus) doDMABlock(port Port) {
 		case dirFromRam:
 			srcWord := b.ram.load32(currentAddr)
 			if port == PortGpu {
-				b.gpu.GP0(srcWord)
+				b.gpu.GP0DMA(srcWord)
 			} else {
 				log.Panicf("Unhandled DMA destination port %v", port)
 			}
@@ -413,6 +454,12 @@ func (b *Bus) getDMASrcWord(port Port, addr, remainingSize uint32) uint32 {
 			srcWord = (addr - 4) & 0x1fffff
 		}
 
+	case PortGpu: // VRAM to CPU
+		srcWord = b.gpu.Read()
+
+	case PortCdRom: // drain the CDROM data FIFO
+		srcWord = b.cdRom.ReadDataWord()
+
 	default:
 		log.Panicf("Unhandled DMA source port: %v", port)
 	}
@@ -449,7 +496,7 @@ func (b *Bus) doDMALinkedList(port Port) {
 			command := b.ram.load32(addr)
 
 			// send command to the GPU
-			b.gpu.GP0(command)
+			b.gpu.GP0DMA(command)
 
 			remainingSize -= 1
 		}
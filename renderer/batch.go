@@ -0,0 +1,183 @@
+package renderer
+
+import (
+	"github.com/TheOrnyx/psx-go/log"
+	"github.com/TheOrnyx/psx-go/utils"
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Batching of primitives. Everything pushed lands in the vertex
+// buffers in the order it was sent, runs of primitives with the same
+// draw state make up a batch. Nothing gets drawn until Draw, so
+// changing state is cheap.
+//
+// Every primitive also gets a depth, later ones are closer. That lets
+// opaque primitives with the same state get drawn together with one
+// call no matter what was sent in between, the depth test keeps
+// whatever came last on top. Semi transparent and mask checking
+// primitives depend on what's already in VRAM so those get drawn after
+// all the opaque ones in the order they were sent
+//
+// Semi transparent textured primitives only blend the texels with the
+// mask bit set, so those batches get drawn twice: once with blending
+// off for the other texels and once with it on. The depth test keeps
+// the order right between the two
+
+// The GL state a primitive gets drawn with
+type drawState struct {
+	blendMode    BlendMode // blending mode
+	forceMaskBit bool      // set the mask bit of drawn pixels
+	checkMaskBit bool      // don't draw over pixels with the mask bit set
+	dither       bool      // apply the dither matrix
+	texWindow    [4]uint8  // texture window x mask, y mask, x offset, y offset
+	drawArea     [4]int32  // left, top, right, bottom (inclusive)
+}
+
+// ordered return true if primitives with this state have to be drawn
+// in the order they were sent
+func (s drawState) ordered() bool {
+	return s.blendMode != BlendOpaque || s.checkMaskBit
+}
+
+// A run of vertices in the buffers sharing the same draw state
+type batch struct {
+	state drawState
+	first int32 // first vertex
+	count int32 // number of vertices
+}
+
+// Opaque batches with the same state, drawn with one call
+type batchGroup struct {
+	state  drawState
+	firsts []int32
+	counts []int32
+}
+
+// pushVertices add count vertices to the buffers as one primitive,
+// drawing what's pending first if they don't fit
+func (r *Renderer) pushVertices(positions []VRAMPos, colors []Color, coords []TexCoord, tex TexInfo) {
+	count := uint32(len(positions))
+	if r.numVertices+count > VERTEX_BUFFER_LEN {
+		log.Info("Vertex attribute buffers full, forcing draw")
+		r.Draw()
+	}
+
+	r.primDepth += 1
+	first := r.numVertices
+
+	for i := range positions {
+		r.positions.Set(r.numVertices, positions[i])
+		r.colors.Set(r.numVertices, colors[i])
+		r.depths.Set(r.numVertices, r.primDepth)
+		r.texCoords.Set(r.numVertices, coords[i])
+		r.texInfos.Set(r.numVertices, tex)
+		r.numVertices += 1
+	}
+
+	if n := len(r.batches); n > 0 && r.batches[n-1].state == r.state {
+		r.batches[n-1].count += int32(count)
+		return
+	}
+
+	r.batches = append(r.batches, batch{state: r.state, first: int32(first), count: int32(count)})
+}
+
+// Draw draw the pending batches and reset the buffers
+//
+// TODO - improve later by using double buffering as this stalls the emulator
+func (r *Renderer) Draw() {
+	if r.numVertices == 0 {
+		return
+	}
+
+	// Make sure all the data from persisent mappings is flushed to
+	// the buffer
+	gl.MemoryBarrier(gl.CLIENT_MAPPED_BUFFER_BARRIER_BIT)
+
+	// depths only mean anything within one Draw
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.DepthMask(true)
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LESS)
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, r.vramSource)
+
+	var groups []batchGroup
+	for _, b := range r.batches {
+		if b.state.ordered() {
+			continue
+		}
+
+		found := false
+		for i := range groups {
+			if groups[i].state == b.state {
+				groups[i].firsts = append(groups[i].firsts, b.first)
+				groups[i].counts = append(groups[i].counts, b.count)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			groups = append(groups, batchGroup{state: b.state, firsts: []int32{b.first}, counts: []int32{b.count}})
+		}
+	}
+
+	for _, group := range groups {
+		r.applyDrawState(group.state)
+		gl.MultiDrawArrays(gl.TRIANGLES, &group.firsts[0], &group.counts[0], int32(len(group.firsts)))
+	}
+
+	for _, b := range r.batches {
+		if !b.state.ordered() {
+			continue
+		}
+
+		r.applyDrawState(b.state)
+		if b.state.blendMode == BlendOpaque {
+			gl.DrawArrays(gl.TRIANGLES, b.first, b.count)
+			continue
+		}
+
+		gl.Disable(gl.BLEND)
+		gl.Uniform1i(r.uniformSemiPass, SEMI_PASS_OPAQUE)
+		gl.DrawArrays(gl.TRIANGLES, b.first, b.count)
+
+		r.applyBlendMode(b.state.blendMode)
+		gl.Uniform1i(r.uniformSemiPass, SEMI_PASS_BLENDED)
+		gl.DrawArrays(gl.TRIANGLES, b.first, b.count)
+
+		gl.Uniform1i(r.uniformSemiPass, SEMI_PASS_ALL)
+	}
+
+	gl.Disable(gl.DEPTH_TEST)
+
+	// Wait for GPU to complete
+	sync := gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0)
+
+	for {
+		r := gl.ClientWaitSync(sync, gl.SYNC_FLUSH_COMMANDS_BIT, 10000000)
+
+		if r == gl.ALREADY_SIGNALED || r == gl.CONDITION_SATISFIED {
+			// drawing is finished
+			break
+		}
+	}
+	gl.DeleteSync(sync)
+
+	// reset buffers
+	r.numVertices = 0
+	r.primDepth = 0
+	r.batches = r.batches[:0]
+}
+
+// applyDrawState set the GL state for drawing with s
+func (r *Renderer) applyDrawState(s drawState) {
+	r.applyBlendMode(s.blendMode)
+	r.applyMaskBit(s.forceMaskBit, s.checkMaskBit)
+	r.applyDrawArea(s.drawArea)
+	gl.Uniform1i(r.uniformDither, int32(utils.BoolToUint32(s.dither)))
+	gl.Uniform4ui(r.uniformTexWindow, uint32(s.texWindow[0]), uint32(s.texWindow[1]), uint32(s.texWindow[2]), uint32(s.texWindow[3]))
+}
@@ -21,6 +21,11 @@ func PosFromGP0(val uint32) VRAMPos {
 	return VRAMPos{x: x, y: y}
 }
 
+// NewVRAMPos create a vram position from already decoded coordinates
+func NewVRAMPos(x, y int16) VRAMPos {
+	return VRAMPos{x: x, y: y}
+}
+
 // RGB color
 type Color struct {
 	R uint8
@@ -37,6 +42,27 @@ func ColorFromGP0(val uint32) Color {
 	return Color{r, g, b}
 }
 
+// Texture coordinates of a vertex in texels
+type TexCoord struct {
+	U uint8
+	V uint8
+}
+
+// Texture mode flags of a vertex
+const (
+	TEX_DEPTH_MASK uint16 = 0x3 // texture depth, 0 for 4-bit, 1 for 8-bit and 2 for 15-bit
+	TEX_ENABLED uint16 = 0x4 // the primitive is textured
+	TEX_RAW uint16 = 0x8 // texels are used as is without blending with the vertex color
+)
+
+// Where a textured primitive gets its texels from, every vertex of the
+// primitive has the same one
+type TexInfo struct {
+	PageX, PageY uint16 // top left of the texture page in VRAM
+	ClutX, ClutY uint16 // top left of the CLUT in VRAM
+	Mode uint16 // TEX_* flags
+}
+
 const VERTEX_BUFFER_LEN uint32 = 64*1024
 
 type Buffer[T any] struct {
@@ -56,7 +82,7 @@ func NewBuffer[T any]() Buffer[T] {
 	gl.BindBuffer(gl.ARRAY_BUFFER, object)
 
 	// compute size of buffer
-	elemSize := int(unsafe.Sizeof(memory))
+	elemSize := int(unsafe.Sizeof(*memory))
 	bufferSize := elemSize * int(VERTEX_BUFFER_LEN)
 
 	// Write only persisent mapping (NOTE - guide says not coherent)
@@ -0,0 +1,211 @@
+package renderer
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Stuff for fitting the presented picture into the window
+
+// How the picture's aspect ratio is worked out
+type AspectMode uint8
+
+// Aspect mode constants
+const (
+	Aspect4x3     AspectMode = 0 // 4:3 like a TV would show it
+	AspectNative  AspectMode = 1 // square pixels
+	AspectStretch AspectMode = 2 // fill the whole window
+)
+
+// String return the name of the aspect mode
+func (a AspectMode) String() string {
+	switch a {
+	case AspectNative:
+		return "native"
+	case AspectStretch:
+		return "stretch"
+	default:
+		return "4:3"
+	}
+}
+
+// Next return the aspect mode after this one
+func (a AspectMode) Next() AspectMode {
+	return (a + 1) % 3
+}
+
+// ParseAspectMode parse an aspect mode from its name
+func ParseAspectMode(s string) (AspectMode, error) {
+	switch s {
+	case "4:3":
+		return Aspect4x3, nil
+	case "native":
+		return AspectNative, nil
+	case "stretch":
+		return AspectStretch, nil
+	default:
+		return Aspect4x3, fmt.Errorf("Unknown aspect mode %q, expected 4:3, native or stretch", s)
+	}
+}
+
+// Texture filtering used when scaling the picture up to the window
+type Filter uint8
+
+// Filter constants
+const (
+	FilterNearest Filter = 0 // blocky pixels
+	FilterLinear  Filter = 1 // smoothed
+)
+
+// String return the name of the filter
+func (f Filter) String() string {
+	if f == FilterLinear {
+		return "linear"
+	}
+
+	return "nearest"
+}
+
+// ParseFilter parse a filter from its name
+func ParseFilter(s string) (Filter, error) {
+	switch s {
+	case "nearest":
+		return FilterNearest, nil
+	case "linear":
+		return FilterLinear, nil
+	default:
+		return FilterNearest, fmt.Errorf("Unknown filter %q, expected nearest or linear", s)
+	}
+}
+
+// Settings for how the picture gets shown in the window
+type OutputSettings struct {
+	Aspect       AspectMode // aspect ratio of the picture
+	IntegerScale bool       // only scale by whole numbers
+	Filter       Filter     // filtering when scaling
+}
+
+// SetOutputSettings change how the picture gets shown in the window
+func (r *Renderer) SetOutputSettings(settings OutputSettings) {
+	r.output = settings
+}
+
+// OutputSettings return how the picture is being shown in the window
+func (r *Renderer) OutputSettings() OutputSettings {
+	return r.output
+}
+
+// ToggleFullscreen switch between fullscreen and windowed
+func (r *Renderer) ToggleFullscreen() error {
+	var flags uint32
+	if r.Window.GetFlags()&sdl.WINDOW_FULLSCREEN == 0 {
+		flags = sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+
+	return r.Window.SetFullscreen(flags)
+}
+
+// outputRect work out where a width x height picture goes in a
+// windowWidth x windowHeight window. fromVRAM says if it's a display
+// area, other pictures (like the VRAM viewer) always keep square pixels
+func (r *Renderer) outputRect(width, height, windowWidth, windowHeight int32, fromVRAM bool) (x, y, w, h int32) {
+	if width <= 0 || height <= 0 {
+		return 0, 0, windowWidth, windowHeight
+	}
+
+	aspect := r.output.Aspect
+	if !fromVRAM && aspect == Aspect4x3 {
+		aspect = AspectNative
+	}
+
+	switch aspect {
+	case AspectStretch:
+		w, h = windowWidth, windowHeight
+		if r.output.IntegerScale {
+			w = width * max(windowWidth/width, 1)
+			h = height * max(windowHeight/height, 1)
+		}
+
+	case AspectNative:
+		if r.output.IntegerScale {
+			scale := max(min(windowWidth/width, windowHeight/height), 1)
+			w, h = width*scale, height*scale
+		} else {
+			w, h = fitAspect(float64(width)/float64(height), windowWidth, windowHeight)
+		}
+
+	default:
+		if r.output.IntegerScale {
+			// lines get scaled by a whole number, the width just
+			// follows to keep 4:3
+			scale := max(windowHeight/height, 1)
+			for scale > 1 && height*scale*4/3 > windowWidth {
+				scale -= 1
+			}
+
+			h = height * scale
+			w = h * 4 / 3
+		} else {
+			w, h = fitAspect(4.0/3.0, windowWidth, windowHeight)
+		}
+	}
+
+	// centered, anything left over is black bars
+	x = (windowWidth - w) / 2
+	y = (windowHeight - h) / 2
+
+	return x, y, w, h
+}
+
+// fitAspect biggest size with the aspect ratio that fits the window
+func fitAspect(aspect float64, windowWidth, windowHeight int32) (w, h int32) {
+	w = windowWidth
+	h = int32(float64(windowWidth) / aspect)
+	if h > windowHeight {
+		h = windowHeight
+		w = int32(float64(windowHeight) * aspect)
+	}
+
+	return w, h
+}
+
+// applyFilter set the filtering of the bound texture
+func (r *Renderer) applyFilter() {
+	filter := int32(gl.NEAREST)
+	if r.output.Filter == FilterLinear {
+		filter = gl.LINEAR
+	}
+
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter)
+}
+
+// WindowToPicture convert a position in the window to a position in the
+// last presented picture. ok is false if it's outside of it
+func (r *Renderer) WindowToPicture(winX, winY int32) (x, y int32, ok bool) {
+	winWidth, winHeight := r.Window.GetSize()
+	drawWidth, drawHeight := r.Window.GLGetDrawableSize()
+	if winWidth <= 0 || winHeight <= 0 {
+		return 0, 0, false
+	}
+
+	// the output rect is in drawable pixels which can differ from
+	// window coordinates on high DPI screens
+	px := winX * drawWidth / winWidth
+	py := winY * drawHeight / winHeight
+
+	rect := r.lastOutput
+	if rect[2] <= 0 || rect[3] <= 0 {
+		return 0, 0, false
+	}
+
+	px -= rect[0]
+	py -= rect[1]
+	if px < 0 || py < 0 || px >= rect[2] || py >= rect[3] {
+		return 0, 0, false
+	}
+
+	return px * r.lastPicture[0] / rect[2], py * r.lastPicture[1] / rect[3], true
+}
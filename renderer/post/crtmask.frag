@@ -0,0 +1,25 @@
+#version 330 core
+
+in vec2 uv;
+out vec4 frag_color;
+
+uniform sampler2D source;
+uniform vec2 source_size;
+uniform vec2 output_size;
+uniform vec2 original_size;
+
+// how much the colors that aren't lit get dimmed
+const float DARK = 0.7;
+
+void main() {
+  vec3 color = texture(source, uv).rgb;
+
+  // aperture grille, every output column only lets one of red, green
+  // or blue through fully
+  int column = int(gl_FragCoord.x) % 3;
+  vec3 mask = vec3(DARK);
+  mask[column] = 1.0;
+
+  // brighten a bit to make up for the light the mask eats
+  frag_color = vec4(color * mask * 1.2, 1.0);
+}
@@ -0,0 +1,13 @@
+#version 330 core
+
+out vec2 uv;
+
+void main() {
+  // Fullscreen quad from the vertex index like present.vert, but the
+  // textures here come from framebuffers so there's no flipping
+  vec2 pos = vec2(float(gl_VertexID & 1), float(gl_VertexID >> 1));
+
+  uv = pos;
+
+  gl_Position = vec4(pos * 2.0 - 1.0, 0.0, 1.0);
+}
@@ -0,0 +1,22 @@
+#version 330 core
+
+in vec2 uv;
+out vec4 frag_color;
+
+uniform sampler2D source;
+uniform vec2 source_size;
+uniform vec2 output_size;
+uniform vec2 original_size;
+
+// how dark the gaps between lines get
+const float STRENGTH = 0.45;
+
+void main() {
+  vec3 color = texture(source, uv).rgb;
+
+  // position inside the original line, brightest in the middle
+  float line = fract(uv.y * original_size.y);
+  float beam = 1.0 - STRENGTH * pow(abs(line - 0.5) * 2.0, 2.0);
+
+  frag_color = vec4(color * beam, 1.0);
+}
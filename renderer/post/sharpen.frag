@@ -0,0 +1,27 @@
+#version 330 core
+
+in vec2 uv;
+out vec4 frag_color;
+
+uniform sampler2D source;
+uniform vec2 source_size;
+uniform vec2 output_size;
+uniform vec2 original_size;
+
+// how much the edges get pushed
+const float AMOUNT = 0.5;
+
+void main() {
+  vec2 texel = 1.0 / source_size;
+
+  vec3 center = texture(source, uv).rgb;
+  vec3 around = texture(source, uv + vec2(texel.x, 0.0)).rgb +
+    texture(source, uv - vec2(texel.x, 0.0)).rgb +
+    texture(source, uv + vec2(0.0, texel.y)).rgb +
+    texture(source, uv - vec2(0.0, texel.y)).rgb;
+
+  // unsharp mask, the difference from the blurred neighbours
+  vec3 color = center + AMOUNT * (center * 4.0 - around);
+
+  frag_color = vec4(clamp(color, 0.0, 1.0), 1.0);
+}
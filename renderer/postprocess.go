@@ -0,0 +1,287 @@
+package renderer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Post processing, a chain of fragment shaders the presented picture
+// goes through before it hits the window. Each pass draws into its own
+// framebuffer and the next one reads from it, the last pass draws into
+// the window.
+//
+// A chain comes from a config directory with a chain.cfg in it, one
+// pass per line:
+//
+//	# shader  scale  filter
+//	scanlines.frag  0  nearest
+//	crtmask.frag
+//
+// scale is relative to the size of the pass's input, 0 means the size
+// of the picture in the window. filter is how the input gets sampled.
+//
+// Pass shaders get the input as the "source" sampler and can use the
+// "source_size", "output_size" and "original_size" (the picture before
+// any upscaling) uniforms, all in pixels
+
+const (
+	POST_CHAIN_FILE  = "chain.cfg" // name of the chain config in a config directory
+	POST_BUILTIN_DIR = "post/"     // where the built in pass shaders live in the shaders
+)
+
+// Config for a single post processing pass
+type PostPassConfig struct {
+	Name   string  // name for error messages
+	Path   string  // file the source was read from, empty for built in shaders
+	Source []byte  // fragment shader source
+	Scale  float32 // output size relative to the input, 0 is the window picture size
+	Linear bool    // sample the input with linear filtering
+}
+
+// The built in chains, picked by name instead of a config directory
+var builtinPostChains = map[string][]PostPassConfig{
+	"none":      nil,
+	"scanlines": {{Name: "scanlines.frag"}},
+	"crt":       {{Name: "scanlines.frag"}, {Name: "crtmask.frag"}},
+	"sharpen":   {{Name: "sharpen.frag", Linear: true}},
+}
+
+// LoadPostChain load a post processing chain. name is either one of
+// the built in chains or a config directory
+func LoadPostChain(name string) ([]PostPassConfig, error) {
+	if builtin, found := builtinPostChains[name]; found {
+		chain := make([]PostPassConfig, len(builtin))
+		for i, pass := range builtin {
+			source, err := loadShader(POST_BUILTIN_DIR + pass.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			chain[i] = pass
+			chain[i].Source = source
+		}
+
+		return chain, nil
+	}
+
+	return loadPostChainDir(name)
+}
+
+// loadPostChainDir load the chain from the chain.cfg in dir
+func loadPostChainDir(dir string) ([]PostPassConfig, error) {
+	file, err := os.Open(filepath.Join(dir, POST_CHAIN_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open post processing chain: %v", err)
+	}
+	defer file.Close()
+
+	var chain []PostPassConfig
+	scanner := bufio.NewScanner(file)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected shader, scale and filter", POST_CHAIN_FILE, lineNum)
+		}
+
+		pass := PostPassConfig{Name: fields[0]}
+
+		if len(fields) > 1 {
+			scale, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil || scale < 0 {
+				return nil, fmt.Errorf("%s:%d: bad scale %q", POST_CHAIN_FILE, lineNum, fields[1])
+			}
+			pass.Scale = float32(scale)
+		}
+
+		if len(fields) > 2 {
+			filter, err := ParseFilter(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", POST_CHAIN_FILE, lineNum, err)
+			}
+			pass.Linear = filter == FilterLinear
+		}
+
+		pass.Path = filepath.Join(dir, pass.Name)
+		pass.Source, err = os.ReadFile(pass.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open post shader: %v", err)
+		}
+
+		chain = append(chain, pass)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read post processing chain: %v", err)
+	}
+
+	return chain, nil
+}
+
+// reloadPostChain read the sources of the passes in chain again
+func reloadPostChain(chain []PostPassConfig) ([]PostPassConfig, error) {
+	reloaded := make([]PostPassConfig, len(chain))
+	for i, pass := range chain {
+		var err error
+		if pass.Path != "" {
+			pass.Source, err = os.ReadFile(pass.Path)
+		} else {
+			pass.Source, err = loadShader(POST_BUILTIN_DIR + pass.Name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to reload post shader %s: %v", pass.Name, err)
+		}
+
+		reloaded[i] = pass
+	}
+
+	return reloaded, nil
+}
+
+// A post processing pass ready to draw with
+type postPass struct {
+	config PostPassConfig
+
+	program           uint32 // the linked program
+	uniformSourceSize int32  // index of the "source_size" uniform
+	uniformOutputSize int32  // index of the "output_size" uniform
+	uniformOrigSize   int32  // index of the "original_size" uniform
+	framebuffer       uint32 // framebuffer the pass draws into when it's not the last one
+	texture           uint32 // color attachment of framebuffer
+	width, height     int32  // current size of texture
+}
+
+// SetPostChain replace the post processing chain, an empty one shows
+// the picture as is
+func (r *Renderer) SetPostChain(chain []PostPassConfig) error {
+	vertSource, err := loadShader(POST_BUILTIN_DIR + "post.vert")
+	if err != nil {
+		return err
+	}
+
+	passes := make([]*postPass, 0, len(chain))
+	for _, config := range chain {
+		program, err := buildProgramFromSource("post.vert", vertSource, config.Name, config.Source, nil)
+		if err != nil {
+			for _, pass := range passes {
+				gl.DeleteProgram(pass.program)
+			}
+
+			return err
+		}
+
+		pass := &postPass{config: config, program: program}
+
+		gl.UseProgram(pass.program)
+		gl.Uniform1i(gl.GetUniformLocation(pass.program, gl.Str("source\x00")), 0)
+		pass.uniformSourceSize = gl.GetUniformLocation(pass.program, gl.Str("source_size\x00"))
+		pass.uniformOutputSize = gl.GetUniformLocation(pass.program, gl.Str("output_size\x00"))
+		pass.uniformOrigSize = gl.GetUniformLocation(pass.program, gl.Str("original_size\x00"))
+
+		passes = append(passes, pass)
+	}
+
+	r.deletePostChain()
+	r.postPasses = passes
+	r.postConfigs = chain
+
+	r.bindVRAMTarget()
+	return nil
+}
+
+// deletePostChain delete all the GL objects of the post processing chain
+func (r *Renderer) deletePostChain() {
+	for _, pass := range r.postPasses {
+		gl.DeleteProgram(pass.program)
+		gl.DeleteFramebuffers(1, &pass.framebuffer)
+		gl.DeleteTextures(1, &pass.texture)
+	}
+
+	gl.DeleteFramebuffers(1, &r.pictureFramebuffer)
+	gl.DeleteTextures(1, &r.pictureTexture)
+	r.pictureFramebuffer = 0
+	r.pictureTexture = 0
+	r.postPasses = nil
+}
+
+// resizeTarget make sure framebuffer has a texture of width x height
+// attached, (re)creating them if needed
+func resizeTarget(framebuffer, texture *uint32, curWidth, curHeight *int32, width, height int32) {
+	if *framebuffer != 0 && *curWidth == width && *curHeight == height {
+		return
+	}
+
+	if *framebuffer == 0 {
+		gl.GenFramebuffers(1, framebuffer)
+		gl.GenTextures(1, texture)
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, *texture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, *framebuffer)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, *texture, 0)
+
+	*curWidth = width
+	*curHeight = height
+}
+
+// runPostChain run the picture in the picture framebuffer through the
+// passes, the last one draws into the window at x, y, w, h
+func (r *Renderer) runPostChain(x, y, w, h, windowHeight int32) {
+	source := r.pictureTexture
+	sourceWidth, sourceHeight := r.pictureWidth, r.pictureHeight
+
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	for i, pass := range r.postPasses {
+		last := i == len(r.postPasses)-1
+
+		width, height := w, h
+		if !last && pass.config.Scale > 0 {
+			width = max(int32(float32(sourceWidth)*pass.config.Scale), 1)
+			height = max(int32(float32(sourceHeight)*pass.config.Scale), 1)
+		}
+
+		if last {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			gl.Viewport(x, windowHeight-y-h, w, h)
+		} else {
+			resizeTarget(&pass.framebuffer, &pass.texture, &pass.width, &pass.height, width, height)
+			gl.BindFramebuffer(gl.FRAMEBUFFER, pass.framebuffer)
+			gl.Viewport(0, 0, width, height)
+		}
+
+		gl.UseProgram(pass.program)
+		gl.Uniform2f(pass.uniformSourceSize, float32(sourceWidth), float32(sourceHeight))
+		gl.Uniform2f(pass.uniformOutputSize, float32(width), float32(height))
+		gl.Uniform2f(pass.uniformOrigSize, float32(r.lastPicture[0]), float32(r.lastPicture[1]))
+
+		gl.BindTexture(gl.TEXTURE_2D, source)
+		filter := int32(gl.NEAREST)
+		if pass.config.Linear {
+			filter = gl.LINEAR
+		}
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter)
+
+		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+		source = pass.texture
+		sourceWidth, sourceHeight = width, height
+	}
+}
@@ -0,0 +1,16 @@
+#version 330 core
+
+in vec2 frame_uv;
+out vec4 frag_color;
+
+uniform sampler2D frame;
+
+// part of the texture to show as offset and size in texture
+// coordinates, the size is negative to flip it
+uniform vec4 source_rect;
+
+void main() {
+  vec2 uv = source_rect.xy + frame_uv * source_rect.zw;
+
+  frag_color = vec4(texture(frame, uv).rgb, 1.0);
+}
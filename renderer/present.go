@@ -0,0 +1,148 @@
+package renderer
+
+import (
+	"image"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Stuff for presenting finished frames to the window
+
+// The part of VRAM a frame came from
+type DisplayArea struct {
+	X, Y          int32 // top left corner in VRAM
+	Width, Height int32 // size in VRAM pixels, zero if the frame isn't from VRAM
+	Depth24       bool  // 24-bit color, can't be shown straight from VRAM
+	Disabled      bool  // the display is turned off with GP1(03h), nothing gets shown
+}
+
+// setupPresent create the program and texture used for showing frames
+func (r *Renderer) setupPresent() error {
+	program, err := buildProgram("present.vert", "present.frag", nil)
+	if err != nil {
+		return err
+	}
+	r.presentProgram = program
+	r.setupPresentUniforms()
+
+	// core profile won't draw without a VAO bound even if there's no attributes
+	gl.GenVertexArrays(1, &r.presentVAO)
+
+	gl.GenTextures(1, &r.frameTexture)
+	gl.BindTexture(gl.TEXTURE_2D, r.frameTexture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+
+	return nil
+}
+
+// setupPresentUniforms look up the uniforms of the present program
+func (r *Renderer) setupPresentUniforms() {
+	gl.UseProgram(r.presentProgram)
+	gl.Uniform1i(gl.GetUniformLocation(r.presentProgram, gl.Str("frame\x00")), 0)
+	r.uniformSourceRect = gl.GetUniformLocation(r.presentProgram, gl.Str("source_rect\x00"))
+}
+
+// fromVRAM whether the picture for area gets shown straight from the
+// VRAM framebuffer. That's when upscaling so it stays sharp, 24-bit
+// display areas can't be
+func (r *Renderer) fromVRAM(area DisplayArea) bool {
+	return r.scale > 1 && area.Width > 0 && area.Height > 0 && !area.Depth24
+}
+
+// NeedsFrame whether Present needs the frame the GPU builds for area,
+// it doesn't when the display is off or the picture comes from the VRAM
+// framebuffer
+func (r *Renderer) NeedsFrame(area DisplayArea) bool {
+	return !area.Disabled && !r.fromVRAM(area)
+}
+
+// Present draw whatever primitives are pending and show a frame in
+// the window. At native resolution that's frame, the display area the
+// GPU built from its VRAM. When upscaling the display area comes from
+// our VRAM framebuffer instead and frame can be nil, see NeedsFrame. A
+// disabled display is just black
+func (r *Renderer) Present(frame *image.RGBA, area DisplayArea) {
+	r.Draw()
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Disable(gl.BLEND)
+	gl.Disable(gl.STENCIL_TEST)
+	gl.Disable(gl.SCISSOR_TEST)
+
+	width, height := r.Window.GLGetDrawableSize()
+	gl.Viewport(0, 0, width, height)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+
+	if area.Disabled {
+		r.Window.GLSwap()
+		r.bindVRAMTarget()
+		return
+	}
+
+	// size of the picture in VRAM pixels
+	fromVRAM := r.fromVRAM(area)
+	var bounds image.Rectangle
+	if fromVRAM {
+		bounds = image.Rect(0, 0, int(area.Width), int(area.Height))
+	} else {
+		bounds = frame.Bounds()
+	}
+
+	// fit the picture into the window, the rest stays black
+	x, y, w, h := r.outputRect(int32(bounds.Dx()), int32(bounds.Dy()), width, height, area.Width > 0)
+	r.lastOutput = [4]int32{x, y, w, h}
+	r.lastPicture = [2]int32{int32(bounds.Dx()), int32(bounds.Dy())}
+
+	// the picture goes straight to the window unless it needs post
+	// processing, then it goes into its own framebuffer first
+	pictureWidth, pictureHeight := int32(bounds.Dx()), int32(bounds.Dy())
+	if fromVRAM {
+		pictureWidth, pictureHeight = area.Width*r.scale, area.Height*r.scale
+	}
+
+	post := len(r.postPasses) > 0 && pictureWidth > 0 && pictureHeight > 0
+	if post {
+		resizeTarget(&r.pictureFramebuffer, &r.pictureTexture, &r.pictureWidth, &r.pictureHeight, pictureWidth, pictureHeight)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, r.pictureFramebuffer)
+		gl.Viewport(0, 0, pictureWidth, pictureHeight)
+	} else {
+		gl.Viewport(x, height-y-h, w, h)
+	}
+
+	gl.UseProgram(r.presentProgram)
+	gl.BindVertexArray(r.presentVAO)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	if fromVRAM {
+		gl.BindTexture(gl.TEXTURE_2D, r.vramTexture)
+		r.applyFilter()
+
+		// VRAM's first line is at the top of the texture
+		gl.Uniform4f(r.uniformSourceRect,
+			float32(area.X)/VRAM_WIDTH, 1-float32(area.Y)/VRAM_HEIGHT,
+			float32(area.Width)/VRAM_WIDTH, -float32(area.Height)/VRAM_HEIGHT)
+
+		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+	} else if !bounds.Empty() {
+		gl.BindTexture(gl.TEXTURE_2D, r.frameTexture)
+		r.applyFilter()
+
+		gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(frame.Stride/4))
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, int32(bounds.Dx()), int32(bounds.Dy()), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(frame.Pix))
+		gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+
+		gl.Uniform4f(r.uniformSourceRect, 0, 0, 1, 1)
+		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+	}
+
+	if post {
+		r.runPostChain(x, y, w, h, height)
+	}
+
+	r.Window.GLSwap()
+
+	r.bindVRAMTarget()
+}
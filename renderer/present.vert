@@ -0,0 +1,14 @@
+#version 330 core
+
+out vec2 frame_uv;
+
+void main() {
+  // Fullscreen quad drawn as a triangle strip, positions come straight
+  // from the vertex index so no buffers are needed
+  vec2 pos = vec2(float(gl_VertexID & 1), float(gl_VertexID >> 1));
+
+  // the frame's first row is the top of the picture
+  frame_uv = vec2(pos.x, 1.0 - pos.y);
+
+  gl_Position = vec4(pos * 2.0 - 1.0, 0.0, 1.0);
+}
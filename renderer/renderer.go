@@ -2,43 +2,95 @@ package renderer
 
 import (
 	"fmt"
-	"os"
 	"unsafe"
 
 	"github.com/TheOrnyx/psx-go/log"
+	"github.com/TheOrnyx/psx-go/utils"
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
 const (
-	WIN_WIDTH = 1024
-	WIN_HEIGHT = 512
+	WIN_WIDTH = 960 // default window size, 4:3
+	WIN_HEIGHT = 720
+
+	ATTRIB_POSITION = 0 // vertex_position attribute index
+	ATTRIB_COLOR = 1 // vertex_color attribute index
+	ATTRIB_DEPTH = 2 // vertex_depth attribute index
+	ATTRIB_TEXCOORD = 3 // vertex_texcoord attribute index
+	ATTRIB_TEXPAGE = 4 // vertex_texpage attribute index
+	ATTRIB_TEXMODE = 5 // vertex_texmode attribute index
+
+	SEMI_PASS_ALL = 0 // draw every pixel
+	SEMI_PASS_OPAQUE = 1 // only draw the pixels that don't get blended
+	SEMI_PASS_BLENDED = 2 // only draw the pixels that get blended
 )
 
+// attributes of the draw program, in index order
+var drawAttribs = []string{"vertex_position", "vertex_color", "vertex_depth", "vertex_texcoord", "vertex_texpage", "vertex_texmode"}
+
 type Renderer struct {
 	Window *sdl.Window
 	GlContext sdl.GLContext
 
-	vertexShader uint32 // the Vertex shader object
-	fragmentShader uint32 // The Fragment shader object
 	program uint32 // OpenGL Program object
 	vertexArrayObject uint32 // Vertex Array Object VAO
 	positions Buffer[VRAMPos] // Buffer containing vertex positions
 	colors Buffer[Color] // Buffer containing vertex colors
+	depths Buffer[uint16] // Buffer containing the depth of the primitive each vertex belongs to
+	texCoords Buffer[TexCoord] // Buffer containing vertex texture coordinates
+	texInfos Buffer[TexInfo] // Buffer containing the texture page and CLUT of the primitive each vertex belongs to
 	numVertices uint32 // Current number of vertices in the buffers
-	uniformOffset int32 // Index of the "offset" shader uniform
+	primDepth uint16 // Depth of the last primitive pushed
+	batches []batch // Pending primitives grouped by draw state, in the order they were sent
+	uniformDither int32 // Index of the "dither" shader uniform
+	uniformScale int32 // Index of the "scale" shader uniform
+	uniformTexWindow int32 // Index of the "tex_window" shader uniform
+	uniformSemiPass int32 // Index of the "semi_pass" shader uniform
+	scale int32 // Internal resolution multiplier
+	state drawState // Draw state for the next primitives
+
+	vramFramebuffer uint32 // Framebuffer the primitives get drawn into
+	vramTexture uint32 // Color attachment of the VRAM framebuffer
+	vramStencil uint32 // Depth/stencil texture of the VRAM framebuffer, holds the mask bit
+	vramSource uint32 // Native resolution copy of the GPU's VRAM textures get sampled from
+	presentProgram uint32 // Program used for showing frames
+	presentVAO uint32 // Empty VAO used when showing frames
+	uniformSourceRect int32 // Index of the present "source_rect" uniform
+	output OutputSettings // How the picture gets fit into the window
+	lastOutput [4]int32 // Where the last picture went in the window as x, y, width, height
+	lastPicture [2]int32 // Size of the last picture
+	postPasses []*postPass // Post processing chain, empty when there's none
+	postConfigs []PostPassConfig // Config postPasses was built from, for reloading
+	pictureFramebuffer uint32 // Framebuffer the picture goes into before post processing
+	pictureTexture uint32 // Color attachment of pictureFramebuffer
+	pictureWidth, pictureHeight int32 // Current size of pictureTexture
+	frameTexture uint32 // Texture holding the frame being shown
 }
 
+// Blending mode for semi transparent primitives, B is the pixel in
+// the framebuffer and F the one being drawn
+type BlendMode uint8
+
+const (
+	BlendOpaque  BlendMode = 0 // F
+	BlendHalf    BlendMode = 1 // B/2 + F/2
+	BlendAdd     BlendMode = 2 // B + F
+	BlendSub     BlendMode = 3 // B - F
+	BlendQuarter BlendMode = 4 // B + F/4
+)
+
 // NewRenderer create and initialize a new renderer object
 func NewRenderer() (*Renderer, error) {
 	r := new(Renderer)
+	r.scale = 1
 
 	sdl.GLSetAttribute(sdl.GL_CONTEXT_PROFILE_MASK, sdl.GL_CONTEXT_PROFILE_CORE)
 	sdl.GLSetAttribute(sdl.GL_CONTEXT_MAJOR_VERSION, 3)
 	sdl.GLSetAttribute(sdl.GL_CONTEXT_MINOR_VERSION, 3)
 	sdl.GLSetAttribute(sdl.GL_CONTEXT_FLAGS, sdl.GL_CONTEXT_DEBUG_FLAG)
 
-	window, err := sdl.CreateWindow("PSX-GO", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, WIN_WIDTH, WIN_HEIGHT, sdl.WINDOW_OPENGL)
+	window, err := sdl.CreateWindow("PSX-GO", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, WIN_WIDTH, WIN_HEIGHT, sdl.WINDOW_OPENGL|sdl.WINDOW_RESIZABLE|sdl.WINDOW_ALLOW_HIGHDPI)
 	if err != nil {
 		r.Quit()
 		return nil, fmt.Errorf("Failed to create window: %v", err)
@@ -62,26 +114,29 @@ func NewRenderer() (*Renderer, error) {
 	gl.DebugMessageCallback(DebugCallback, nil)
 
 	gl.ClearColor(0, 0, 0, 1.0)
+	gl.ClearStencil(0)
 	gl.Clear(gl.COLOR_BUFFER_BIT)
 	r.Window.GLSwap()
 
-	// Shader stuff
-
-	vertSource, err := os.ReadFile("./renderer/shader.vert")
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open vertex shader src: %v", err)
+	if err := r.setupPresent(); err != nil {
+		r.Quit()
+		return nil, err
 	}
 
-	fragSource, err := os.ReadFile("./renderer/shader.frag")
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open fragment shader src: %v", err)
+	if err := r.setupVRAMTarget(); err != nil {
+		r.Quit()
+		return nil, err
 	}
+	r.setupTextureSource()
 
-	vertShader := compileShader(vertSource, gl.VERTEX_SHADER)
-	fragShader := compileShader(fragSource, gl.FRAGMENT_SHADER)
+	// Shader stuff
 
-	// Link the program and then use it
-	program := linkProgram([]uint32{vertShader, fragShader})
+	program, err := buildProgram("shader.vert", "shader.frag", drawAttribs)
+	if err != nil {
+		r.Quit()
+		return nil, err
+	}
+	r.program = program
 	gl.UseProgram(program)
 
 	// generate vertex attribute object to hold vertex attributes
@@ -94,188 +149,213 @@ func NewRenderer() (*Renderer, error) {
 	// Create buffer holding the positions
 	positions := NewBuffer[VRAMPos]()
 
-	// retrieve index for the attribute in shader and enable it
-	index := findProgramAttrib(program, "vertex_position")
-	gl.EnableVertexAttribArray(index)
+	// the attribute indices are bound before linking, see drawAttribs
+	gl.EnableVertexAttribArray(ATTRIB_POSITION)
 
 	// link buffer and index: 2 non-normalized GLshort attributes
-	gl.VertexAttribIPointer(index, 2, gl.SHORT, 0, nil)
+	gl.VertexAttribIPointer(ATTRIB_POSITION, 2, gl.SHORT, 0, nil)
 
 	// Color stuff
 	// Setup color attribute and bind it
 	colors := NewBuffer[Color]()
 
-	index = findProgramAttrib(program, "vertex_color")
-	gl.EnableVertexAttribArray(index)
+	gl.EnableVertexAttribArray(ATTRIB_COLOR)
 
 	// Link buffer and the index: 3 non-normalized GLByte
 	// attributes. Should send data untouched to vertex shader
-	gl.VertexAttribIPointer(index, 3, gl.UNSIGNED_BYTE, 0, nil)
+	gl.VertexAttribIPointer(ATTRIB_COLOR, 3, gl.UNSIGNED_BYTE, 0, nil)
 
-	uniformOffset := gl.GetUniformLocation(program, gl.Str("offset"+"\x00")) // TODO - check
-	gl.Uniform2i(uniformOffset, 0, 0)
+	// Depth of the primitive for batching, see batch.go
+	depths := NewBuffer[uint16]()
+
+	gl.EnableVertexAttribArray(ATTRIB_DEPTH)
+	gl.VertexAttribIPointer(ATTRIB_DEPTH, 1, gl.UNSIGNED_SHORT, 0, nil)
+
+	// Texture stuff, untextured primitives have zeroes
+	texCoords := NewBuffer[TexCoord]()
+
+	gl.EnableVertexAttribArray(ATTRIB_TEXCOORD)
+	gl.VertexAttribIPointer(ATTRIB_TEXCOORD, 2, gl.UNSIGNED_BYTE, 0, nil)
+
+	// the page and CLUT are 4 GLushorts and the mode the one after
+	texInfos := NewBuffer[TexInfo]()
+	stride := int32(unsafe.Sizeof(TexInfo{}))
+
+	gl.EnableVertexAttribArray(ATTRIB_TEXPAGE)
+	gl.VertexAttribIPointer(ATTRIB_TEXPAGE, 4, gl.UNSIGNED_SHORT, stride, nil)
+
+	gl.EnableVertexAttribArray(ATTRIB_TEXMODE)
+	gl.VertexAttribIPointer(ATTRIB_TEXMODE, 1, gl.UNSIGNED_SHORT, stride, gl.PtrOffset(int(unsafe.Offsetof(TexInfo{}.Mode))))
 
-	r.vertexShader = vertShader
-	r.fragmentShader = fragShader
-	r.program = program
 	r.vertexArrayObject = vao
 	r.positions = positions
 	r.colors = colors
+	r.depths = depths
+	r.texCoords = texCoords
+	r.texInfos = texInfos
 	r.numVertices = 0
-	r.uniformOffset = uniformOffset
+	r.setupDrawUniforms()
+
+	r.bindVRAMTarget()
 	
 	return r, nil
 }
 
-// compileShader compile and return the shader
-func compileShader(source []byte, shaderType uint32) uint32 {
-	shader := gl.CreateShader(shaderType)
+// setupDrawUniforms look up the uniforms of the draw program and give
+// them the current values
+func (r *Renderer) setupDrawUniforms() {
+	gl.UseProgram(r.program)
 
-	// Attempt to compile shader
-	cStr, free := gl.Strs(string(source)+"\x00")
-	defer free()
+	r.uniformDither = gl.GetUniformLocation(r.program, gl.Str("dither"+"\x00"))
+	gl.Uniform1i(r.uniformDither, int32(utils.BoolToUint32(r.state.dither)))
 
-	gl.ShaderSource(shader, 1, cStr, nil)
-	gl.CompileShader(shader)
+	r.uniformScale = gl.GetUniformLocation(r.program, gl.Str("scale"+"\x00"))
+	gl.Uniform1i(r.uniformScale, r.scale)
 
-	// Extra bit of error checking in case we're not using debug
-	// opengl context
-	status := int32(gl.FALSE)
-	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	// the VRAM copy is always on texture unit 0 while drawing
+	gl.Uniform1i(gl.GetUniformLocation(r.program, gl.Str("vram"+"\x00")), 0)
 
-	if status != gl.TRUE {
-		log.Panic("Shader compilation failed!")
-	}
-	
-	return shader
+	r.uniformTexWindow = gl.GetUniformLocation(r.program, gl.Str("tex_window"+"\x00"))
+	r.uniformSemiPass = gl.GetUniformLocation(r.program, gl.Str("semi_pass"+"\x00"))
+	gl.Uniform1i(r.uniformSemiPass, SEMI_PASS_ALL)
 }
 
-// linkProgram Link the shaders to the program
-func linkProgram(shaders []uint32) uint32 {
-	var program uint32
-
-	program = gl.CreateProgram()
-
-	for i := range shaders {
-		gl.AttachShader(program, shaders[i])
-	}
+// PushTriangle Add a triangle to the draw buffer
+func (r *Renderer) PushTriangle(positions [3]VRAMPos, colors [3]Color)  {
+	r.pushVertices(positions[:], colors[:], make([]TexCoord, 3), TexInfo{})
+}
 
-	gl.LinkProgram(program)
+// PushTexturedTriangle Add a textured triangle to the draw buffer, the
+// texels come from the VRAM copy UpdateTextures fills
+func (r *Renderer) PushTexturedTriangle(positions [3]VRAMPos, colors [3]Color, coords [3]TexCoord, tex TexInfo)  {
+	tex.Mode |= TEX_ENABLED
+	r.pushVertices(positions[:], colors[:], coords[:], tex)
+}
 
-	var status int32 = gl.FALSE
-	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+// PushQuad Add a quad to the draw buffer
+func (r *Renderer) PushQuad(positions [4]VRAMPos, colors [4]Color)  {
+	// two triangles sharing the middle edge
+	r.pushVertices(
+		[]VRAMPos{positions[0], positions[1], positions[2], positions[1], positions[2], positions[3]},
+		[]Color{colors[0], colors[1], colors[2], colors[1], colors[2], colors[3]},
+		make([]TexCoord, 6),
+		TexInfo{},
+	)
+}
 
-	if status != gl.TRUE {
-		log.Panic("Opengl program linking failed!")
-	}
+// Quit quit and close the renderer
+func (r *Renderer) Quit()  {
+	r.deleteVRAMTarget()
+	gl.DeleteTextures(1, &r.vramSource)
+	r.deletePostChain()
+	gl.DeleteTextures(1, &r.frameTexture)
+	gl.DeleteVertexArrays(1, &r.presentVAO)
+	gl.DeleteProgram(r.presentProgram)
+	gl.DeleteVertexArrays(1, &r.vertexArrayObject)
+	gl.DeleteProgram(r.program)
 	
-	return program
+	sdl.Quit()
+	r.Window.Destroy()
+	sdl.GLDeleteContext(r.GlContext)
 }
 
-// findProgramAttrib return index of attribute attribute in program
-func findProgramAttrib(program uint32, attr string) uint32 {
-	index := gl.GetAttribLocation(program, gl.Str(attr+"\x00"))
-
-	if index < 0 {
-		log.Panicf("Attribute %s, not found in program", attr)
-	}
+// DebugCallback Debug callback function for OpenGL
+func DebugCallback(source, glType, id, severity uint32, length int32, msg string, userParam unsafe.Pointer)  {
+	log.Infof("[OpenGL Debug] Source: 0x%x, Type: 0x%x, ID: %d, Severity: 0x%x, Message: %s\n",
+		source, glType, id, severity, msg)
+}
 
-	return uint32(index)
+// SetDrawArea set the drawing area the next primitives get clipped
+// to. right and bottom are inclusive
+func (r *Renderer) SetDrawArea(left, top, right, bottom int32) {
+	r.state.drawArea = [4]int32{left, top, right, bottom}
 }
 
-// PushTriangle Add a triangle to the draw buffer
-func (r *Renderer) PushTriangle(positions [3]VRAMPos, colors [3]Color)  {
-	// make sure we have enough room left to queue the vertex
-	if r.numVertices + 3 > VERTEX_BUFFER_LEN {
-		log.Info("Vertex attrivute buffers full, forcing draw")
-		r.Draw()
-	}
+// applyDrawArea set the GL scissor box to the drawing area
+func (r *Renderer) applyDrawArea(area [4]int32) {
+	left, top, right, bottom := area[0], area[1], area[2], area[3]
 
-	for i := range 3 {
-		r.positions.Set(r.numVertices, positions[i])
-		r.colors.Set(r.numVertices, colors[i])
-		r.numVertices += 1
-	}
+	width := max(right-left+1, 0)
+	height := max(bottom-top+1, 0)
 
+	// VRAM has 0 at the top, GL at the bottom
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Scissor(left*r.scale, (VRAM_HEIGHT-1-bottom)*r.scale, width*r.scale, height*r.scale)
 }
 
-// PushQuad Add a quad to the draw buffer
-func (r *Renderer) PushQuad(positions [4]VRAMPos, colors [4]Color)  {
-	// Make sure we have enough room left to queue the vertex.
-	if r.numVertices + 6 > VERTEX_BUFFER_LEN {
-		// Vertex attribute buffers are full, force early draw
-		r.Draw()
-	}
+// SetBlendMode set the blending mode used for the next primitives
+func (r *Renderer) SetBlendMode(mode BlendMode) {
+	r.state.blendMode = mode
+}
 
-	// Push first triangle
-	for i := range 3 {
-		r.positions.Set(r.numVertices, positions[i])
-		r.colors.Set(r.numVertices, colors[i])
-		r.numVertices+=1
+// applyBlendMode set the GL blending state for the blend mode
+func (r *Renderer) applyBlendMode(mode BlendMode) {
+	if mode == BlendOpaque {
+		gl.Disable(gl.BLEND)
+		return
 	}
 
-	// Push second triangle
-	for i := 1; i < 4; i++{
-		r.positions.Set(r.numVertices, positions[i])
-		r.colors.Set(r.numVertices, colors[i])
-		r.numVertices+=1
+	gl.Enable(gl.BLEND)
+
+	switch mode {
+	case BlendHalf:
+		gl.BlendColor(0, 0, 0, 0.5)
+		gl.BlendEquation(gl.FUNC_ADD)
+		gl.BlendFunc(gl.CONSTANT_ALPHA, gl.CONSTANT_ALPHA)
+	case BlendAdd:
+		gl.BlendEquation(gl.FUNC_ADD)
+		gl.BlendFunc(gl.ONE, gl.ONE)
+	case BlendSub:
+		gl.BlendEquation(gl.FUNC_REVERSE_SUBTRACT)
+		gl.BlendFunc(gl.ONE, gl.ONE)
+	case BlendQuarter:
+		gl.BlendColor(0, 0, 0, 0.25)
+		gl.BlendEquation(gl.FUNC_ADD)
+		gl.BlendFunc(gl.CONSTANT_ALPHA, gl.ONE)
 	}
 }
 
-// Draw draw the buffered commands and reset the buffers
-//
-// TODO - improve later by using double buffering as this stalls the emulator
-func (r *Renderer) Draw()  {
-	// Make sure all the data from persisent mappings is flushed to
-	// the buffer
-	gl.MemoryBarrier(gl.CLIENT_MAPPED_BUFFER_BARRIER_BIT)
-
-	gl.DrawArrays(gl.TRIANGLES, 0, int32(r.numVertices))
-
-	// Wait for GPU to complete
-	sync := gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0)
-
-	for  {
-		r := gl.ClientWaitSync(sync, gl.SYNC_FLUSH_COMMANDS_BIT, 10000000)
+// SetMaskBit set the mask bit settings used for the next primitives
+func (r *Renderer) SetMaskBit(force, check bool) {
+	r.state.forceMaskBit = force
+	r.state.checkMaskBit = check
+}
 
-		if r == gl.ALREADY_SIGNALED || r == gl.CONDITION_SATISFIED {
-			// drawing is finished
-			break
+// applyMaskBit set the GL stencil state for the mask bit settings. The
+// stencil buffer holds the mask bit for every pixel
+//
+// NOTE - textured pixels also get the mask bit of their texel on the
+// hardware, that doesn't make it into the stencil here
+func (r *Renderer) applyMaskBit(force, check bool) {
+	gl.Enable(gl.STENCIL_TEST)
+
+	if check {
+		// only draw where the mask bit isn't set
+		gl.StencilFunc(gl.EQUAL, 0, 1)
+	} else {
+		ref := int32(0)
+		if force {
+			ref = 1
 		}
+		gl.StencilFunc(gl.ALWAYS, ref, 1)
 	}
 
-	// reset buffers
-	r.numVertices = 0
-}
-
-// Display Draw the buffered commands and display them
-func (r *Renderer) Display()  {
-	r.Draw()
-	r.Window.GLSwap()
-}
-
-// Quit quit and close the renderer
-func (r *Renderer) Quit()  {
-	gl.DeleteVertexArrays(1, &r.vertexArrayObject)
-	gl.DeleteShader(r.vertexShader)
-	gl.DeleteShader(r.fragmentShader)
-	gl.DeleteProgram(r.program)
-	
-	sdl.Quit()
-	r.Window.Destroy()
-	sdl.GLDeleteContext(r.GlContext)
+	if check && force {
+		// the reference has to be 0 for the check so bump the 0 we
+		// know is there instead of replacing it
+		gl.StencilOp(gl.KEEP, gl.KEEP, gl.INCR)
+	} else {
+		gl.StencilOp(gl.KEEP, gl.KEEP, gl.REPLACE)
+	}
 }
 
-// DebugCallback Debug callback function for OpenGL
-func DebugCallback(source, glType, id, severity uint32, length int32, msg string, userParam unsafe.Pointer)  {
-	log.Infof("[OpenGL Debug] Source: 0x%x, Type: 0x%x, ID: %d, Severity: 0x%x, Message: %s\n",
-		source, glType, id, severity, msg)
+// SetTextureWindow set the texture window for the next primitives,
+// the masks and offsets are in 8 texel steps like GP0(E2h)
+func (r *Renderer) SetTextureWindow(maskX, maskY, offsetX, offsetY uint8) {
+	r.state.texWindow = [4]uint8{maskX, maskY, offsetX, offsetY}
 }
 
-// SetDrawOffset Set value of the uniform draw offset
-func (r *Renderer) SetDrawOffset(x, y int16)  {
-	// Force draw for the primitives with the current offset
-	r.Draw()
-
-	gl.Uniform2i(r.uniformOffset, int32(x), int32(y))
+// SetDither set whether the next primitives get dithered
+func (r *Renderer) SetDither(enabled bool) {
+	r.state.dither = enabled
 }
@@ -1,8 +1,109 @@
 #version 330 core
 
 in vec3 color;
+in vec2 texcoord;
+flat in uvec4 texpage;
+flat in uint texmode;
 out vec4 frag_color;
 
+// whether to apply the dither matrix before reducing to 15-bit
+uniform bool dither;
+
+// internal resolution multiplier
+uniform int scale;
+
+// native copy of VRAM, one 16-bit pixel per texel with the first line
+// at the top
+uniform usampler2D vram;
+
+// texture window x mask, y mask, x offset, y offset in 8 texel steps
+uniform uvec4 tex_window;
+
+// which pixels of a semi transparent primitive get drawn, see
+// SEMI_PASS_* in renderer.go
+uniform int semi_pass;
+
+// texture mode flags, same as TEX_* in buffer.go
+const uint TEX_DEPTH_MASK = 0x3u;
+const uint TEX_ENABLED = 0x4u;
+const uint TEX_RAW = 0x8u;
+
+// The PSX 4x4 ordered dither matrix, in 8-bit color steps
+const int dither_matrix[16] = int[16](
+  -4, +0, -3, +1,
+  +2, -2, +3, -1,
+  -3, +1, -4, +0,
+  +3, -1, +2, -2
+);
+
+// vram_pixel the VRAM pixel at x, y, wrapping around like VRAM does
+uint vram_pixel(uint x, uint y) {
+  return texelFetch(vram, ivec2(x & 1023u, y & 511u), 0).r;
+}
+
+// sample_texture fetch the texel at uv from the texture page with the
+// texture window applied, same as sampleTexture in the GPU
+uint sample_texture(uvec2 uv) {
+  uv = (uv & ~(tex_window.xy * 8u)) | ((tex_window.zw & tex_window.xy) * 8u);
+
+  uint y = texpage.y + uv.y;
+
+  uint depth = texmode & TEX_DEPTH_MASK;
+
+  if (depth == 0u) {
+    uint word = vram_pixel(texpage.x + uv.x / 4u, y);
+    uint index = (word >> ((uv.x & 3u) * 4u)) & 0xfu;
+    return vram_pixel(texpage.z + index, texpage.w);
+  } else if (depth == 1u) {
+    uint word = vram_pixel(texpage.x + uv.x / 2u, y);
+    uint index = (word >> ((uv.x & 1u) * 8u)) & 0xffu;
+    return vram_pixel(texpage.z + index, texpage.w);
+  }
+
+  return vram_pixel(texpage.x + uv.x, y);
+}
+
 void main() {
-  frag_color = vec4(color, 1.0);
+  ivec3 c = ivec3(round(color * 255.0));
+
+  // untextured primitives are all or nothing, only texels with the
+  // mask bit set are semi transparent
+  bool semi = true;
+
+  if ((texmode & TEX_ENABLED) != 0u) {
+    uvec2 uv = uvec2(clamp(floor(texcoord), 0.0, 255.0));
+    uint texel = sample_texture(uv);
+    if (texel == 0u) {
+      discard; // fully transparent texel
+    }
+
+    semi = (texel & 0x8000u) != 0u;
+
+    ivec3 t = ivec3(texel, texel >> 5, texel >> 10) & 0x1f;
+    if ((texmode & TEX_RAW) != 0u) {
+      // already 15-bit, expanded so the truncation below keeps it
+      c = t << 3;
+    } else {
+      // 0x80 leaves the texel as it is
+      c = ((t << 3) * c) >> 7;
+    }
+  }
+
+  if ((semi_pass == 1 && semi) || (semi_pass == 2 && !semi)) {
+    discard;
+  }
+
+  if (dither) {
+    // gl_FragCoord has 0 at the bottom, VRAM has it at the top. The
+    // matrix goes by VRAM pixels no matter the scale
+    ivec2 pos = ivec2(gl_FragCoord.xy) / scale;
+    int y = 511 - pos.y;
+
+    c += dither_matrix[(y & 3) * 4 + (pos.x & 3)];
+  }
+
+  // truncate to 5:5:5 like the hardware does
+  c = clamp(c, 0, 255) >> 3;
+
+  frag_color = vec4(vec3(c) / 31.0, 1.0);
 }
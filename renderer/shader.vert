@@ -2,24 +2,41 @@
 
 in ivec2 vertex_position;
 in uvec3 vertex_color;
+// which primitive of the batch this is, later ones are closer
+in uint vertex_depth;
+// texture coordinates in texels
+in uvec2 vertex_texcoord;
+// texture page x, y and CLUT x, y in VRAM pixels
+in uvec4 vertex_texpage;
+// texture depth and flags, see TEX_* in buffer.go
+in uint vertex_texmode;
 
 out vec3 color;
-
-uniform ivec2 offset;
+out vec2 texcoord;
+flat out uvec4 texpage;
+flat out uint texmode;
 
 void main() {
-  ivec2 position = vertex_position + offset;
-  
+  // the GPU already applied the drawing offset
+  ivec2 position = vertex_position;
+
   // Convert VRAM coords (0:1023, 0:511) into openGL normal Coords (-1:1, -1:1)
   float xpos = (float(position.x) / 512) - 1.0;
 
   // VRAM puts 0 at top, OpenGL at the bottom so mirror vertically
   float ypos = 1.0 - (float(position.y) / 256);
 
-  gl_Position.xyzw = vec4(xpos, ypos, 0.0, 1.0);
+  // depth 1 is just in front of the far plane
+  float zpos = 1.0 - (float(vertex_depth) / 32768);
+
+  gl_Position.xyzw = vec4(xpos, ypos, zpos, 1.0);
 
   // convert components from [0:255] to [0:1]
   color = vec3(float(vertex_color.r) / 255,
 	       float(vertex_color.g) / 255,
 	       float(vertex_color.b) / 255);
+
+  texcoord = vec2(vertex_texcoord);
+  texpage = vertex_texpage;
+  texmode = vertex_texmode;
 }
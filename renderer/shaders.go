@@ -0,0 +1,189 @@
+package renderer
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheOrnyx/psx-go/log"
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Shader loading. The built in shaders are embedded in the binary so
+// it runs from anywhere, a shader with the same name in the override
+// directory gets used instead if there is one
+
+//go:embed shader.vert shader.frag present.vert present.frag post/*.vert post/*.frag
+var builtinShaders embed.FS
+
+var shaderDir string // override directory, empty when there isn't one
+
+// SetShaderDir set the directory checked for shaders before the built
+// in ones, empty turns it off
+func SetShaderDir(dir string) {
+	shaderDir = dir
+}
+
+// loadShader return the source of the shader called name, from the
+// override directory if it's there or the built in one otherwise
+func loadShader(name string) ([]byte, error) {
+	if shaderDir != "" {
+		source, err := os.ReadFile(filepath.Join(shaderDir, name))
+		if err == nil {
+			return source, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Failed to read shader %s: %v", name, err)
+		}
+	}
+
+	source, err := builtinShaders.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("No shader called %s: %v", name, err)
+	}
+
+	return source, nil
+}
+
+// compileShader compile and return the shader, the GL info log ends up
+// in the error if it doesn't compile
+func compileShader(name string, source []byte, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+
+	cStr, free := gl.Strs(string(source) + "\x00")
+	defer free()
+
+	gl.ShaderSource(shader, 1, cStr, nil)
+	gl.CompileShader(shader)
+
+	// Extra bit of error checking in case we're not using debug
+	// opengl context
+	status := int32(gl.FALSE)
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+
+	if status != gl.TRUE {
+		var logLen int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLen)
+
+		infoLog := strings.Repeat("\x00", int(logLen+1))
+		gl.GetShaderInfoLog(shader, logLen, nil, gl.Str(infoLog))
+		gl.DeleteShader(shader)
+
+		return 0, fmt.Errorf("Failed to compile shader %s: %s", name, strings.TrimRight(infoLog, "\x00"))
+	}
+
+	return shader, nil
+}
+
+// linkProgram link the shaders into a program. attribs get bound to
+// locations 0, 1... in order so they don't change between reloads. The
+// shaders get deleted either way
+func linkProgram(name string, shaders []uint32, attribs []string) (uint32, error) {
+	program := gl.CreateProgram()
+
+	for _, shader := range shaders {
+		gl.AttachShader(program, shader)
+	}
+
+	for i, attrib := range attribs {
+		gl.BindAttribLocation(program, uint32(i), gl.Str(attrib+"\x00"))
+	}
+
+	gl.LinkProgram(program)
+
+	// the program keeps them alive
+	for _, shader := range shaders {
+		gl.DeleteShader(shader)
+	}
+
+	var status int32 = gl.FALSE
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+
+	if status != gl.TRUE {
+		var logLen int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLen)
+
+		infoLog := strings.Repeat("\x00", int(logLen+1))
+		gl.GetProgramInfoLog(program, logLen, nil, gl.Str(infoLog))
+		gl.DeleteProgram(program)
+
+		return 0, fmt.Errorf("Failed to link program %s: %s", name, strings.TrimRight(infoLog, "\x00"))
+	}
+
+	return program, nil
+}
+
+// buildProgram load, compile and link a vertex and fragment shader pair
+func buildProgram(vertName, fragName string, attribs []string) (uint32, error) {
+	vertSource, err := loadShader(vertName)
+	if err != nil {
+		return 0, err
+	}
+
+	fragSource, err := loadShader(fragName)
+	if err != nil {
+		return 0, err
+	}
+
+	return buildProgramFromSource(vertName, vertSource, fragName, fragSource, attribs)
+}
+
+// buildProgramFromSource compile and link a vertex and fragment shader pair
+func buildProgramFromSource(vertName string, vertSource []byte, fragName string, fragSource []byte, attribs []string) (uint32, error) {
+	vertShader, err := compileShader(vertName, vertSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+
+	fragShader, err := compileShader(fragName, fragSource, gl.FRAGMENT_SHADER)
+	if err != nil {
+		gl.DeleteShader(vertShader)
+		return 0, err
+	}
+
+	return linkProgram(vertName+"+"+fragName, []uint32{vertShader, fragShader}, attribs)
+}
+
+// ReloadShaders rebuild every program from the shader sources again,
+// for trying out shader changes without restarting. If anything fails
+// to build the old shaders are kept
+func (r *Renderer) ReloadShaders() error {
+	r.Draw()
+
+	drawProgram, err := buildProgram("shader.vert", "shader.frag", drawAttribs)
+	if err != nil {
+		return err
+	}
+
+	presentProgram, err := buildProgram("present.vert", "present.frag", nil)
+	if err != nil {
+		gl.DeleteProgram(drawProgram)
+		return err
+	}
+
+	chain, err := reloadPostChain(r.postConfigs)
+	if err != nil {
+		gl.DeleteProgram(drawProgram)
+		gl.DeleteProgram(presentProgram)
+		return err
+	}
+
+	gl.DeleteProgram(r.program)
+	r.program = drawProgram
+	r.setupDrawUniforms()
+
+	gl.DeleteProgram(r.presentProgram)
+	r.presentProgram = presentProgram
+	r.setupPresentUniforms()
+
+	if err := r.SetPostChain(chain); err != nil {
+		return err
+	}
+
+	log.Info("Reloaded shaders")
+
+	r.bindVRAMTarget()
+	return nil
+}
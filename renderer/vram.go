@@ -0,0 +1,238 @@
+package renderer
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Stuff for the VRAM framebuffer the primitives get drawn into. It's
+// scale times the size of the real VRAM so things look nicer on big
+// screens, anything going in or out of it gets scaled to match
+//
+// Textures don't come from the framebuffer, they come from a native
+// resolution copy of the GPU's own VRAM. The GPU draws everything in
+// software too so its VRAM is the real one, GPUREAD reads it and it
+// sends us whatever changed before a primitive samples it. The
+// framebuffer is just a sharper picture of the same thing
+
+const (
+	VRAM_WIDTH  = 1024 // VRAM width in pixels
+	VRAM_HEIGHT = 512  // VRAM height in lines
+
+	MAX_INTERNAL_SCALE = 8 // Biggest internal resolution multiplier
+)
+
+// setupVRAMTarget create the framebuffer primitives get drawn into at
+// the current scale. The mask bit lives in the stencil buffer
+func (r *Renderer) setupVRAMTarget() error {
+	width := VRAM_WIDTH * r.scale
+	height := VRAM_HEIGHT * r.scale
+
+	gl.GenTextures(1, &r.vramTexture)
+	gl.BindTexture(gl.TEXTURE_2D, r.vramTexture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+
+	// a texture rather than a renderbuffer so mask bits can be
+	// uploaded along with the pixels
+	gl.GenTextures(1, &r.vramStencil)
+	gl.BindTexture(gl.TEXTURE_2D, r.vramStencil)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.DEPTH24_STENCIL8, width, height, 0, gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+
+	gl.GenFramebuffers(1, &r.vramFramebuffer)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.vramFramebuffer)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, r.vramTexture, 0)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.DEPTH_STENCIL_ATTACHMENT, gl.TEXTURE_2D, r.vramStencil, 0)
+
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("VRAM framebuffer incomplete: 0x%x", status)
+	}
+
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.Viewport(0, 0, width, height)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.STENCIL_BUFFER_BIT)
+
+	return nil
+}
+
+// setupTextureSource create the texture textured primitives sample
+// from, one 16-bit VRAM pixel per texel so the shader can do the CLUT
+// lookups itself
+func (r *Renderer) setupTextureSource() {
+	gl.GenTextures(1, &r.vramSource)
+	gl.BindTexture(gl.TEXTURE_2D, r.vramSource)
+
+	// zeroed like the GPU's VRAM starts out
+	zeroes := make([]uint16, VRAM_WIDTH*VRAM_HEIGHT)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R16UI, VRAM_WIDTH, VRAM_HEIGHT, 0, gl.RED_INTEGER, gl.UNSIGNED_SHORT, gl.Ptr(zeroes))
+
+	// integer textures aren't complete with anything but nearest
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+}
+
+// UpdateTextures copy native 15-bit pixels into the VRAM copy textures
+// get sampled from. Anything pending was sent before the pixels
+// changed so it gets drawn first
+func (r *Renderer) UpdateTextures(x, y, width, height int32, pixels []uint16) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	r.Draw()
+
+	// first line at the top here, it's never shown or drawn into
+	gl.BindTexture(gl.TEXTURE_2D, r.vramSource)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 2)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, x, y, width, height, gl.RED_INTEGER, gl.UNSIGNED_SHORT, gl.Ptr(pixels))
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+}
+
+// deleteVRAMTarget delete the VRAM framebuffer and its attachments
+func (r *Renderer) deleteVRAMTarget() {
+	gl.DeleteFramebuffers(1, &r.vramFramebuffer)
+	gl.DeleteTextures(1, &r.vramStencil)
+	gl.DeleteTextures(1, &r.vramTexture)
+}
+
+// bindVRAMTarget go back to drawing primitives into the VRAM framebuffer
+func (r *Renderer) bindVRAMTarget() {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.vramFramebuffer)
+	gl.Viewport(0, 0, VRAM_WIDTH*r.scale, VRAM_HEIGHT*r.scale)
+	gl.UseProgram(r.program)
+	gl.BindVertexArray(r.vertexArrayObject)
+}
+
+// InternalScale return the internal resolution multiplier
+func (r *Renderer) InternalScale() int32 {
+	return r.scale
+}
+
+// SetInternalScale change the internal resolution multiplier, 1 is
+// native. Whatever is in VRAM gets carried over to the new size
+func (r *Renderer) SetInternalScale(scale int32) error {
+	if scale < 1 || scale > MAX_INTERNAL_SCALE {
+		return fmt.Errorf("Internal scale %d out of range, must be 1 to %d", scale, MAX_INTERNAL_SCALE)
+	}
+
+	var maxSize int32
+	gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &maxSize)
+	if VRAM_WIDTH*scale > maxSize {
+		return fmt.Errorf("Internal scale %d needs %d pixel textures, the GPU only does %d", scale, VRAM_WIDTH*scale, maxSize)
+	}
+
+	if scale == r.scale {
+		return nil
+	}
+
+	r.Draw()
+	pixels := r.ReadVRAM(0, 0, VRAM_WIDTH, VRAM_HEIGHT)
+
+	r.deleteVRAMTarget()
+	r.scale = scale
+	if err := r.setupVRAMTarget(); err != nil {
+		return err
+	}
+
+	r.bindVRAMTarget()
+	gl.Uniform1i(r.uniformScale, scale)
+	r.UploadVRAM(0, 0, VRAM_WIDTH, VRAM_HEIGHT, pixels)
+
+	return nil
+}
+
+// UploadVRAM write 15-bit pixels into a rectangle of VRAM, each one
+// becomes a scale x scale block. The rectangle has to fit in VRAM
+func (r *Renderer) UploadVRAM(x, y, width, height int32, pixels []uint16) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	// anything drawn before has to land first
+	r.Draw()
+
+	scaledWidth := width * r.scale
+	scaledHeight := height * r.scale
+	colors := make([]uint8, scaledWidth*scaledHeight*4)
+	masks := make([]uint32, scaledWidth*scaledHeight)
+
+	for row := int32(0); row < scaledHeight; row++ {
+		// GL has the first line at the bottom
+		src := pixels[(height-1-row/r.scale)*width:]
+		dst := row * scaledWidth
+
+		for col := int32(0); col < scaledWidth; col++ {
+			pixel := src[col/r.scale]
+			i := dst + col
+
+			colors[i*4+0] = Expand5(pixel)
+			colors[i*4+1] = Expand5(pixel >> 5)
+			colors[i*4+2] = Expand5(pixel >> 10)
+			colors[i*4+3] = 0xff
+
+			// stencil is the low 8 bits, depth isn't used
+			masks[i] = uint32(pixel >> 15)
+		}
+	}
+
+	glX := x * r.scale
+	glY := (VRAM_HEIGHT - y - height) * r.scale
+
+	gl.BindTexture(gl.TEXTURE_2D, r.vramTexture)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, glX, glY, scaledWidth, scaledHeight, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(colors))
+
+	gl.BindTexture(gl.TEXTURE_2D, r.vramStencil)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, glX, glY, scaledWidth, scaledHeight, gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8, gl.Ptr(masks))
+}
+
+// ReadVRAM read a rectangle of VRAM back as 15-bit pixels. Every
+// pixel comes from the top left of its scale x scale block so it
+// matches what was drawn at native resolution as close as possible
+func (r *Renderer) ReadVRAM(x, y, width, height int32) []uint16 {
+	r.Draw()
+
+	scaledWidth := width * r.scale
+	scaledHeight := height * r.scale
+	colors := make([]uint8, scaledWidth*scaledHeight*4)
+	masks := make([]uint32, scaledWidth*scaledHeight)
+
+	glX := x * r.scale
+	glY := (VRAM_HEIGHT - y - height) * r.scale
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, r.vramFramebuffer)
+	gl.ReadPixels(glX, glY, scaledWidth, scaledHeight, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(colors))
+	gl.ReadPixels(glX, glY, scaledWidth, scaledHeight, gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8, gl.Ptr(masks))
+
+	pixels := make([]uint16, width*height)
+	for row := int32(0); row < height; row++ {
+		// top line of the block, GL has the first line at the bottom
+		src := (scaledHeight - 1 - row*r.scale) * scaledWidth
+
+		for col := int32(0); col < width; col++ {
+			i := src + col*r.scale
+
+			pixel := uint16(colors[i*4+0]>>3) |
+				uint16(colors[i*4+1]>>3)<<5 |
+				uint16(colors[i*4+2]>>3)<<10
+			if masks[i]&0xff != 0 {
+				pixel |= 0x8000
+			}
+
+			pixels[row*width+col] = pixel
+		}
+	}
+
+	return pixels
+}
+
+// Expand5 expand the low 5 bits of c to 8 bits, the same way for
+// the window and anything else showing VRAM colors
+func Expand5(c uint16) uint8 {
+	c &= 0x1f
+
+	return uint8((c << 3) | (c >> 2))
+}
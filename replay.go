@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/TheOrnyx/psx-go/gpu"
+	"github.com/TheOrnyx/psx-go/log"
+	"github.com/TheOrnyx/psx-go/renderer"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// runReplay the replay subcommand, plays a GPU recording made with
+// -record into a GPU on its own:
+//
+//	psx-go replay [flags] recording
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	window := flags.Bool("window", false, "show the frames in a window instead of running headless")
+	frames := flags.Uint64("frames", 0, "stop after this many frames (0 plays the whole recording)")
+	dumpFrame := flags.String("dump-frame", "", "write the last frame to this PNG file")
+	dumpVRAM := flags.String("dump-vram", "", "write a PNG of VRAM to this path when done")
+	vramView := flags.String("vram-view", "15", "how to show VRAM in dumps: 15, 4 or 8 (bpp)")
+	inspect := flags.Uint64("inspect", 0, "list the primitives drawn during this frame (0 doesn't inspect)")
+	highlight := flags.Int("highlight", -1, "highlight this primitive of the inspected frame in the VRAM dump")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Panic("Usage: replay [flags] recording")
+	}
+
+	viewMode, err := gpu.ParseVRAMViewMode(*vramView)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Panicf("Failed to open recording: %v", err)
+	}
+	defer file.Close()
+
+	headless := &gpu.HeadlessBackend{}
+	var backend gpu.Backend = headless
+	if *window {
+		if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
+			log.Panicf("Failed to initialize SDL: %v", err)
+		}
+
+		glRenderer, err := renderer.NewRenderer()
+		if err != nil {
+			sdl.Quit()
+			log.Panicf("Failed to initialize renderer: %v", err)
+		}
+		backend = &frameKeeper{Backend: glRenderer, headless: headless}
+	}
+
+	g := gpu.NewGPU(backend)
+	defer g.Quit()
+
+	replay, err := gpu.NewReplay(file, &g)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+
+	for frame := uint64(1); *frames == 0 || frame <= *frames; frame++ {
+		if frame == *inspect {
+			g.CaptureFrame()
+		}
+
+		err := replay.NextFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			log.Panicf("Replay failed: %v", err)
+		}
+
+		if *window && quitRequested() {
+			break
+		}
+	}
+
+	if *dumpFrame != "" && headless.LastFrame != nil {
+		if err := writePNG(*dumpFrame, headless); err != nil {
+			log.Warnf("Failed to write frame: %v", err)
+		}
+	}
+
+	if g.LastCapture != nil {
+		listCapture(g.LastCapture)
+
+		if *highlight >= 0 && *highlight < len(g.LastCapture.Primitives) {
+			p := &g.LastCapture.Primitives[*highlight]
+			log.Infof("Highlighted primitive:\n%v", p)
+			g.Highlight = p
+		}
+	}
+
+	if *dumpVRAM != "" {
+		if err := g.DumpVRAM(*dumpVRAM, viewMode); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+
+	log.Infof("Replayed %d frames", headless.Frames)
+}
+
+// frameKeeper backend that passes everything on to another one but
+// also keeps the frames like the headless backend does
+type frameKeeper struct {
+	gpu.Backend
+	headless *gpu.HeadlessBackend
+}
+
+// NeedsFrame always, the headless side keeps every frame
+func (f *frameKeeper) NeedsFrame(area renderer.DisplayArea) bool {
+	return true
+}
+
+// Present show the frame and keep it
+func (f *frameKeeper) Present(frame *image.RGBA, area renderer.DisplayArea) {
+	f.headless.Present(frame, area)
+	f.Backend.Present(frame, area)
+}
+
+// quitRequested poll the SDL events, returns true if the window was
+// closed or escape pressed
+func quitRequested() bool {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch t := event.(type) {
+		case *sdl.QuitEvent:
+			return true
+		case *sdl.KeyboardEvent:
+			if t.Type == sdl.KEYDOWN && t.Keysym.Sym == sdl.K_ESCAPE {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// writePNG write the last frame the headless backend got to path
+func writePNG(path string, headless *gpu.HeadlessBackend) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, headless.LastFrame)
+}
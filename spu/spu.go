@@ -0,0 +1,150 @@
+/*
+ * The SPU package, the sound chip. There are no voices yet, just the
+ * registers and a mixer that takes the CD audio input and makes 44.1kHz
+ * stereo samples for the frontend to play
+ */
+package spu
+
+const (
+	CPU_CLOCK         = 33868800                // CPU cycles per second
+	SAMPLE_RATE       = 44100                   // output samples per second
+	CYCLES_PER_SAMPLE = CPU_CLOCK / SAMPLE_RATE // CPU cycles per output sample
+
+	REG_COUNT      = 0x140           // 16 bit registers from 0x1f801c00
+	CD_INPUT_LEN   = SAMPLE_RATE / 2 // stereo samples of CD audio that can be waiting, an 18.9kHz XA sector is 9408
+	OUTPUT_LEN     = SAMPLE_RATE / 2 // stereo samples kept for the frontend before new ones get dropped
+	SAMPLE_MAX     = 0x7fff          // clamp for mixing
+	SAMPLE_MIN     = -0x8000         // clamp for mixing
+	VOLUME_SHIFT   = 15              // volumes are 1.15 fixed point
+	FULL_VOLUME    = 0x7fff          // volume when sweep mode is on, sweeps aren't done yet
+	SPUSTAT_MIRROR = 0x3f            // SPUSTAT bits that copy SPUCNT
+)
+
+// Register offsets from 0x1f801c00
+const (
+	REG_MAIN_VOL_L = 0x180 // main volume left
+	REG_MAIN_VOL_R = 0x182 // main volume right
+	REG_SPUCNT     = 0x1aa // control
+	REG_SPUSTAT    = 0x1ae // status
+	REG_CD_VOL_L   = 0x1b0 // CD audio input volume left
+	REG_CD_VOL_R   = 0x1b2 // CD audio input volume right
+)
+
+// SPUCNT bits
+const (
+	SPUCNT_CD_ENABLE uint16 = 1 << 0  // mix in the CD audio input
+	SPUCNT_UNMUTE    uint16 = 1 << 14 // 0 mutes the output
+	SPUCNT_ENABLE    uint16 = 1 << 15 // the SPU is on
+)
+
+// The SPU
+type SPU struct {
+	regs    [REG_COUNT]uint16 // the registers as they were written
+	cycles  uint32            // CPU cycles towards the next output sample
+	cdInput []int16           // CD audio waiting to be mixed, left and right interleaved
+	output  []int16           // mixed samples for the frontend, left and right interleaved
+}
+
+// NewSPU create and return a new SPU
+func NewSPU() SPU {
+	return SPU{}
+}
+
+// Read16 read the 16 bit register at offset
+func (s *SPU) Read16(offset uint32) uint16 {
+	switch offset {
+	case REG_SPUSTAT:
+		// TODO - the busy, DMA and IRQ bits
+		return s.regs[REG_SPUCNT/2] & SPUSTAT_MIRROR
+	}
+
+	return s.regs[offset/2]
+}
+
+// Write16 write the 16 bit register at offset
+func (s *SPU) Write16(offset uint32, val uint16) {
+	switch offset {
+	case REG_SPUSTAT:
+		return // read only
+	}
+
+	s.regs[offset/2] = val
+}
+
+// PushCDAudio add a stereo sample to the CD audio input, it's dropped if
+// the input is already full
+func (s *SPU) PushCDAudio(left, right int16) {
+	if len(s.cdInput) >= CD_INPUT_LEN*2 {
+		return
+	}
+
+	s.cdInput = append(s.cdInput, left, right)
+}
+
+// Tick run the SPU for cpuCycles, mixing an output sample every
+// CYCLES_PER_SAMPLE
+func (s *SPU) Tick(cpuCycles uint32) {
+	s.cycles += cpuCycles
+
+	for s.cycles >= CYCLES_PER_SAMPLE {
+		s.cycles -= CYCLES_PER_SAMPLE
+		s.mixSample()
+	}
+}
+
+// TakeOutput return the mixed samples so far and start again
+func (s *SPU) TakeOutput() []int16 {
+	out := s.output
+	s.output = nil
+
+	return out
+}
+
+// mixSample make one output sample
+func (s *SPU) mixSample() {
+	var left, right int32
+
+	// the CD input gets used up whether it's mixed in or not
+	if len(s.cdInput) >= 2 {
+		cdLeft, cdRight := int32(s.cdInput[0]), int32(s.cdInput[1])
+		s.cdInput = s.cdInput[2:]
+
+		if s.control()&SPUCNT_CD_ENABLE != 0 {
+			left += cdLeft * int32(int16(s.regs[REG_CD_VOL_L/2])) >> VOLUME_SHIFT
+			right += cdRight * int32(int16(s.regs[REG_CD_VOL_R/2])) >> VOLUME_SHIFT
+		}
+	}
+
+	if s.control()&(SPUCNT_ENABLE|SPUCNT_UNMUTE) != SPUCNT_ENABLE|SPUCNT_UNMUTE {
+		left, right = 0, 0
+	}
+
+	left = clamp(left * mainVolume(s.regs[REG_MAIN_VOL_L/2]) >> VOLUME_SHIFT)
+	right = clamp(right * mainVolume(s.regs[REG_MAIN_VOL_R/2]) >> VOLUME_SHIFT)
+
+	if len(s.output) < OUTPUT_LEN*2 {
+		s.output = append(s.output, int16(left), int16(right))
+	}
+}
+
+// control the SPUCNT register
+func (s *SPU) control() uint16 {
+	return s.regs[REG_SPUCNT/2]
+}
+
+// mainVolume turn a main volume register into a 1.15 volume
+//
+// TODO - sweep mode, just full volume for now
+func mainVolume(reg uint16) int32 {
+	if reg&0x8000 != 0 {
+		return FULL_VOLUME
+	}
+
+	// 15 bit signed volume, shifted up to 1.15
+	return int32(int16(reg << 1))
+}
+
+// clamp clamp a mixed sample to 16 bits
+func clamp(sample int32) int32 {
+	return max(SAMPLE_MIN, min(SAMPLE_MAX, sample))
+}